@@ -2,15 +2,20 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
 	"server-master/internal/client"
 	"server-master/internal/client/mihomo"
+	"server-master/internal/metrics"
 	"server-master/pkg/logger"
 	"syscall"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func main() {
@@ -57,6 +62,12 @@ func runDaemon(syncer *client.Syncer, cfg *client.Config) {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
+	// Serve metrics
+	if cfg.Metrics.Listen != "" {
+		srv := serveMetrics(cfg.Metrics.Listen)
+		defer srv.Close()
+	}
+
 	// Manage Mihomo process
 	var mm *mihomo.Manager
 	if cfg.Mihomo.Enable {
@@ -95,3 +106,18 @@ func runDaemon(syncer *client.Syncer, cfg *client.Config) {
 		}
 	}
 }
+
+// serveMetrics starts the Prometheus listener in the background.
+func serveMetrics(addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(metrics.Client, promhttp.HandlerOpts{}))
+	srv := &http.Server{Addr: addr, Handler: mux}
+
+	go func() {
+		slog.Info("Metrics listening on " + addr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Metrics listener failed", "error", err)
+		}
+	}()
+	return srv
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"server-master/internal/config"
+	"strings"
+)
+
+// hashToken implements "ServerMaster hash-token": it prints the hash of a
+// token for use in config.yaml. The token is read from stdin when not given
+// as an argument, to keep it out of the shell history.
+func hashToken(args []string) int {
+	fs := flag.NewFlagSet("hash-token", flag.ExitOnError)
+	algo := fs.String("algo", "argon2id", "Hash algorithm: sha256, bcrypt or argon2id")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: ServerMaster hash-token [-algo argon2id] [token]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	token := fs.Arg(0)
+	if token == "" {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			fmt.Fprintln(os.Stderr, "failed to read token from stdin:", err)
+			return 1
+		}
+		token = strings.TrimSpace(line)
+	}
+	if token == "" {
+		fs.Usage()
+		return 2
+	}
+
+	hash, err := config.HashToken(token, *algo)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Println(hash)
+	if *algo != "sha256" {
+		fmt.Fprintln(os.Stderr, "note: tokens hashed with", *algo, "require rate-limit.enable")
+	}
+	return 0
+}
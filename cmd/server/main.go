@@ -11,6 +11,15 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "hash-token":
+			os.Exit(hashToken(os.Args[2:]))
+		case "token":
+			os.Exit(manageTokens(os.Args[2:]))
+		}
+	}
+
 	configPath := flag.String("c", "./config.yaml", "Path to config file")
 	flag.Parse()
 
@@ -25,7 +34,19 @@ func main() {
 		os.Exit(1)
 	}
 
-	// 3. Run Application
+	// 3. Reload config on SIGHUP
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			slog.Info("Received SIGHUP, reloading config")
+			if err := application.Reload(); err != nil {
+				slog.Error("Config reload failed, keeping current config", "error", err)
+			}
+		}
+	}()
+
+	// 4. Run Application
 	if err := application.Run(ctx); err != nil {
 		slog.Error("Application exited with error", "error", err)
 		os.Exit(1)
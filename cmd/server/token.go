@@ -0,0 +1,134 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"server-master/internal/config"
+	"server-master/internal/service"
+	"text/tabwriter"
+	"time"
+)
+
+const tokenUsage = `Usage: ServerMaster token [-c config.yaml] <command> [arguments]
+
+Commands:
+  list                                         list stored tokens
+  create [-name n] [-profile p] [-expires d]   create a token and print its secret
+  rotate <id>                                  replace the secret of a token
+  revoke <id>                                  disable a token
+
+A running server picks up the change on SIGHUP or, with reload.watch, by
+itself.`
+
+// manageTokens implements "ServerMaster token": it edits the token store
+// configured in config.yaml.
+func manageTokens(args []string) int {
+	fs := flag.NewFlagSet("token", flag.ExitOnError)
+	configPath := fs.String("c", "./config.yaml", "Path to config file")
+	fs.Usage = func() { fmt.Fprintln(fs.Output(), tokenUsage) }
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to load config:", err)
+		return 1
+	}
+	store := service.NewTokenStore(cfg)
+
+	cmd, rest := fs.Arg(0), fs.Args()[1:]
+	switch cmd {
+	case "list":
+		err = listTokens(store)
+	case "create":
+		err = createToken(store, rest)
+	case "rotate", "revoke":
+		if len(rest) != 1 {
+			fs.Usage()
+			return 2
+		}
+		if cmd == "rotate" {
+			var secret string
+			if secret, _, err = store.Rotate(rest[0]); err == nil {
+				fmt.Println(secret)
+			}
+		} else {
+			_, err = store.Revoke(rest[0])
+		}
+	default:
+		fs.Usage()
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func listTokens(store *service.TokenStore) error {
+	entries, err := store.List()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tPROFILE\tCREATED\tEXPIRES\tSTATUS")
+	for _, e := range entries {
+		status := "active"
+		switch {
+		case !e.RevokedAt.IsZero():
+			status = "revoked"
+		case !e.ExpiresAt.IsZero() && time.Now().After(e.ExpiresAt):
+			status = "expired"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", e.ID, e.Name, e.Profile,
+			e.CreatedAt.Format(time.DateTime), formatDate(e.ExpiresAt), status)
+	}
+	return w.Flush()
+}
+
+func createToken(store *service.TokenStore, args []string) error {
+	fs := flag.NewFlagSet("token create", flag.ExitOnError)
+	name := fs.String("name", "", "Label of the token")
+	profile := fs.String("profile", "", "Profile served to the token")
+	expires := fs.String("expires", "", "Expiry as YYYY-MM-DD or RFC 3339")
+	fs.Parse(args)
+
+	spec := service.TokenSpec{Name: *name, Profile: *profile}
+	if *expires != "" {
+		t, err := parseExpiry(*expires)
+		if err != nil {
+			return err
+		}
+		spec.ExpiresAt = t
+	}
+	secret, entry, err := store.Create(spec)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Created token %s; the secret is shown only once:\n", entry.ID)
+	fmt.Println(secret)
+	return nil
+}
+
+func parseExpiry(s string) (time.Time, error) {
+	if t, err := time.ParseInLocation(time.DateOnly, s, time.Local); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid expiry %q: want YYYY-MM-DD or RFC 3339", s)
+	}
+	return t, nil
+}
+
+func formatDate(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.DateTime)
+}
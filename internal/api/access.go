@@ -0,0 +1,68 @@
+package api
+
+import (
+	"server-master/internal/service"
+	"server-master/pkg/logger"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TokenIdentifier maps subscription tokens to names that are safe to log.
+type TokenIdentifier interface {
+	Identity(token string) string
+}
+
+// targetKey is the gin context key holding the rendered output format.
+const targetKey = "target"
+
+// AccessLog writes one structured log line per request. Unlike gin.Logger it
+// never logs the query string or path tokens, and tokens appear only by name
+// or fingerprint.
+func AccessLog(ids TokenIdentifier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		// The route pattern keeps path tokens (/sub/:token) out of the log.
+		path := c.FullPath()
+		if path == "" {
+			path = c.Request.URL.Path
+		}
+		args := []any{
+			"method", c.Request.Method,
+			"path", path,
+			"status", c.Writer.Status(),
+			"ip", c.ClientIP(),
+			"user_agent", c.Request.UserAgent(),
+			"size", max(c.Writer.Size(), 0),
+			"latency", time.Since(start),
+		}
+		if token := c.GetString(tokenKey); token != "" {
+			args = append(args, "token", ids.Identity(token))
+		} else if token := tokenFromRequest(c); token != "" && (strings.HasPrefix(path, "/sub") || strings.HasPrefix(path, "/file")) {
+			// Rejected tokens are not looked up again.
+			args = append(args, "token", service.TokenFingerprint(token))
+		}
+		if target := c.GetString(targetKey); target != "" {
+			args = append(args, "target", target)
+		}
+		if len(c.Errors) > 0 {
+			args = append(args, "error", c.Errors.String())
+		}
+		logger.Info("HTTP request", args...)
+	}
+}
+
+// tokenFromRequest returns the subscription token from the Authorization
+// header, the path (/sub/<token>) or the query string, in that order.
+func tokenFromRequest(c *gin.Context) string {
+	if token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok && token != "" {
+		return token
+	}
+	if token := c.Param("token"); token != "" {
+		return token
+	}
+	return c.Query("token")
+}
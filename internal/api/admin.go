@@ -0,0 +1,249 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"server-master/internal/service"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminAuth defines the interface for admin API authorization.
+type AdminAuth interface {
+	Enabled() bool
+	ValidateToken(token string) bool
+}
+
+// TaskService defines the interface for inspecting and triggering cron tasks.
+type TaskService interface {
+	Tasks() []service.TaskStatus
+	Trigger(name string) error
+}
+
+// CacheService defines the interface for inspecting the subscription cache.
+type CacheService interface {
+	AdditionStatus() []service.AdditionStatus
+	FlushCache()
+}
+
+// HealthService defines the interface for listing proxy health results.
+type HealthService interface {
+	Nodes() []service.NodeHealth
+}
+
+// PortService defines the interface for listing the active dynamic ports.
+type PortService interface {
+	ActivePorts() []string
+}
+
+// TokenActivityService defines the interface for listing per-token access.
+type TokenActivityService interface {
+	Tokens() []service.TokenActivity
+}
+
+// BanService defines the interface for guarding the admin API and for
+// inspecting and lifting client bans.
+type BanService interface {
+	GuardService
+	Bans() []service.Ban
+	Unban(ip string) bool
+	ClearBans() int
+}
+
+// TokenManager defines the interface for managing stored tokens.
+type TokenManager interface {
+	List() ([]service.StoredToken, error)
+	Create(spec service.TokenSpec) (string, service.StoredToken, error)
+	Revoke(id string) (service.StoredToken, error)
+	Rotate(id string) (string, service.StoredToken, error)
+}
+
+type AdminHandler struct {
+	auth   AdminAuth
+	tasks  TaskService
+	cache  CacheService
+	ports  PortService
+	health HealthService
+	tokens TokenActivityService
+	bans   BanService
+	store  TokenManager
+}
+
+func NewAdminHandler(a AdminAuth, t TaskService, c CacheService, p PortService, hs HealthService, ta TokenActivityService, b BanService, tm TokenManager) *AdminHandler {
+	return &AdminHandler{auth: a, tasks: t, cache: c, ports: p, health: hs, tokens: ta, bans: b, store: tm}
+}
+
+// Register registers the admin routes to the router.
+func (h *AdminHandler) Register(r *gin.RouterGroup) {
+	admin := r.Group("/admin")
+	admin.Use(h.EnabledMiddleware(), guardClient(h.bans), h.AuthMiddleware())
+	{
+		admin.GET("/ports", h.Ports)
+		admin.GET("/tasks", h.Tasks)
+		admin.POST("/tasks/:name/run", h.RunTask)
+		admin.GET("/additions", h.Additions)
+		admin.POST("/cache/flush", h.FlushCache)
+		admin.GET("/health", h.Health)
+		admin.GET("/tokens", h.Tokens)
+		admin.GET("/bans", h.Bans)
+		admin.DELETE("/bans", h.ClearBans)
+		admin.DELETE("/bans/:ip", h.Unban)
+		admin.GET("/token-store", h.ListStoredTokens)
+		admin.POST("/token-store", h.CreateToken)
+		admin.POST("/token-store/:id/rotate", h.RotateToken)
+		admin.DELETE("/token-store/:id", h.RevokeToken)
+	}
+}
+
+// EnabledMiddleware answers 404 while the API is disabled, before any rate
+// limit applies, so that its existence is not revealed.
+func (h *AdminHandler) EnabledMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !h.auth.Enabled() {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Not found"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// AuthMiddleware checks the bearer token. Failed attempts count towards a
+// ban of the client IP, as for subscription tokens.
+func (h *AdminHandler) AuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			h.bans.RecordFailure(c.ClientIP())
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Missing admin token"})
+			return
+		}
+		if !h.auth.ValidateToken(token) {
+			h.bans.RecordFailure(c.ClientIP())
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid admin token"})
+			return
+		}
+		c.Next()
+	}
+}
+
+func (h *AdminHandler) Ports(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"ports": h.ports.ActivePorts()})
+}
+
+func (h *AdminHandler) Tasks(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"tasks": h.tasks.Tasks()})
+}
+
+func (h *AdminHandler) RunTask(c *gin.Context) {
+	err := h.tasks.Trigger(c.Param("name"))
+	switch {
+	case errors.Is(err, service.ErrTaskNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrTaskRunning):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusAccepted, gin.H{"status": "triggered"})
+	}
+}
+
+func (h *AdminHandler) Additions(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"additions": h.cache.AdditionStatus()})
+}
+
+func (h *AdminHandler) FlushCache(c *gin.Context) {
+	h.cache.FlushCache()
+	c.JSON(http.StatusOK, gin.H{"status": "flushed"})
+}
+
+func (h *AdminHandler) Health(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"nodes": h.health.Nodes()})
+}
+
+func (h *AdminHandler) Tokens(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"tokens": h.tokens.Tokens()})
+}
+
+func (h *AdminHandler) Bans(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"bans": h.bans.Bans()})
+}
+
+func (h *AdminHandler) ClearBans(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"cleared": h.bans.ClearBans()})
+}
+
+func (h *AdminHandler) Unban(c *gin.Context) {
+	if !h.bans.Unban(c.Param("ip")) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "IP is not banned"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "unbanned"})
+}
+
+// storeError maps token store errors to responses.
+func storeError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrTokenStoreDisabled), errors.Is(err, service.ErrStoredTokenMissing):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrUnknownProfile):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrTokenRevoked):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrTokenStoreNotApplied):
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+func (h *AdminHandler) ListStoredTokens(c *gin.Context) {
+	tokens, err := h.store.List()
+	if err != nil {
+		storeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"tokens": tokens})
+}
+
+func (h *AdminHandler) CreateToken(c *gin.Context) {
+	var spec service.TokenSpec
+	if err := c.ShouldBindJSON(&spec); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	secret, entry, err := h.store.Create(spec)
+	if errors.Is(err, service.ErrTokenStoreNotApplied) {
+		// The token was saved: its secret must not be lost.
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "token": secret, "entry": entry})
+		return
+	}
+	if err != nil {
+		storeError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"token": secret, "entry": entry})
+}
+
+func (h *AdminHandler) RotateToken(c *gin.Context) {
+	secret, entry, err := h.store.Rotate(c.Param("id"))
+	if errors.Is(err, service.ErrTokenStoreNotApplied) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "token": secret, "entry": entry})
+		return
+	}
+	if err != nil {
+		storeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"token": secret, "entry": entry})
+}
+
+func (h *AdminHandler) RevokeToken(c *gin.Context) {
+	entry, err := h.store.Revoke(c.Param("id"))
+	if err != nil {
+		storeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"entry": entry})
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"server-master/pkg/compress"
+	"strings"
+	"sync"
+	"time"
+)
+
+// contentETag returns a strong entity tag for body.
+func contentETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return fmt.Sprintf(`"%x"`, sum[:16])
+}
+
+// encodedETag derives the entity tag of a representation compressed with
+// encoding, since it differs from the uncompressed one byte for byte.
+func encodedETag(etag, encoding string) string {
+	return strings.TrimSuffix(etag, `"`) + "-" + encoding + `"`
+}
+
+// notModified evaluates the conditional headers of r against the current
+// representation. As in RFC 9110, If-Modified-Since is ignored when
+// If-None-Match is present.
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, v := range strings.Split(inm, ",") {
+			v = strings.TrimSpace(v)
+			if v == "*" || strings.TrimPrefix(v, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" {
+		t, err := http.ParseTime(ims)
+		return err == nil && !modified.Truncate(time.Second).After(t)
+	}
+	return false
+}
+
+// versions remembers when the content served under a key last changed, so
+// that generated responses keep a stable Last-Modified between polls. It
+// also keeps the compressed forms of the current content.
+type versions struct {
+	mu   sync.Mutex
+	seen map[string]*version
+}
+
+type version struct {
+	etag     string
+	modified time.Time
+	encoded  map[string][]byte // by encoding
+}
+
+func newVersions() *versions {
+	return &versions{seen: make(map[string]*version)}
+}
+
+// modified returns the time at which key was first served with etag.
+func (v *versions) modified(key, etag string) time.Time {
+	return v.current(key, etag).modified
+}
+
+// current returns the version of key, starting a new one if etag changed.
+func (v *versions) current(key, etag string) *version {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if prev, ok := v.seen[key]; ok && prev.etag == etag {
+		return prev
+	}
+	cur := &version{etag: etag, modified: time.Now(), encoded: make(map[string][]byte)}
+	v.seen[key] = cur
+	return cur
+}
+
+// encode returns body, the content of key at etag, compressed in encoding.
+// The result is cached until the content changes.
+func (v *versions) encode(key, etag, encoding string, body []byte) ([]byte, error) {
+	cur := v.current(key, etag)
+	v.mu.Lock()
+	data, ok := cur.encoded[encoding]
+	v.mu.Unlock()
+	if ok {
+		return data, nil
+	}
+
+	data, err := compress.Encode(encoding, body)
+	if err != nil {
+		return nil, err
+	}
+	v.mu.Lock()
+	cur.encoded[encoding] = data
+	v.mu.Unlock()
+	return data, nil
+}
@@ -1,7 +1,11 @@
 package api
 
 import (
+	"mime"
 	"net/http"
+	"os"
+	"path/filepath"
+	"server-master/pkg/compress"
 
 	"github.com/gin-gonic/gin"
 )
@@ -9,19 +13,61 @@ import (
 // FileService defines the interface for static rule file serving.
 type FileService interface {
 	GetFilePath(filename string) (string, error)
+	ETag(path string) (string, error)
+	Encoded(path, encoding string) (string, bool)
+	Visible(token, filename string) bool
+	VerifySignature(tokenID, filename, sig, ip string) (string, bool)
 }
 
 type FileHandler struct {
 	service FileService
+	tokens  TokenValidator
+	quota   QuotaService
+	guard   GuardService
 }
 
-func NewFileHandler(s FileService) *FileHandler {
-	return &FileHandler{service: s}
+func NewFileHandler(s FileService, v TokenValidator, q QuotaService, g GuardService) *FileHandler {
+	return &FileHandler{service: s, tokens: v, quota: q, guard: g}
 }
 
 // Register registers the file routes to the router.
 func (h *FileHandler) Register(r *gin.RouterGroup) {
-	r.GET("/file/:filename", h.Handle)
+	r.GET("/file/:filename", guardClient(h.guard), h.AuthMiddleware(), h.Handle)
+}
+
+// AuthMiddleware accepts a subscription token like /sub does, or the
+// signature that generated subscriptions add to rule-provider URLs. Files
+// the token's profile may not download are reported as missing.
+func (h *FileHandler) AuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		filename := c.Param("filename")
+		if tid, sig := c.Query("tid"), c.Query("sig"); tid != "" || sig != "" {
+			id, ok := h.service.VerifySignature(tid, filename, sig, c.ClientIP())
+			if !ok {
+				h.guard.RecordFailure(c.ClientIP())
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid file signature"})
+				return
+			}
+			if !h.guard.AllowTokenID(id) {
+				c.Header("Retry-After", "60")
+				c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Rate limit exceeded"})
+				return
+			}
+			c.Next()
+			return
+		}
+
+		token, ok := authenticate(c, h.tokens, h.guard, h.quota)
+		if !ok {
+			return
+		}
+		if !h.service.Visible(token, filename) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "file not found: " + filepath.Base(filename)})
+			return
+		}
+		c.Set(tokenKey, token)
+		c.Next()
+	}
 }
 
 func (h *FileHandler) Handle(c *gin.Context) {
@@ -37,5 +83,46 @@ func (h *FileHandler) Handle(c *gin.Context) {
 		return
 	}
 
+	c.Header("Vary", "Accept-Encoding")
+	c.Header("Cache-Control", "no-cache")
+	etag, _ := h.service.ETag(path)
+
+	if enc := compress.Negotiate(c.GetHeader("Accept-Encoding")); enc != "" {
+		if sidecar, ok := h.service.Encoded(path, enc); ok && h.serveEncoded(c, path, sidecar, enc, etag) {
+			return
+		}
+	}
+
+	// http.ServeFile answers If-None-Match against this header and
+	// If-Modified-Since against the file's modification time.
+	if etag != "" {
+		c.Header("ETag", etag)
+	}
 	c.File(path)
 }
+
+// serveEncoded serves the pre-compressed sidecar of path. It reports false
+// if the sidecar cannot be read, leaving the response untouched.
+func (h *FileHandler) serveEncoded(c *gin.Context, path, sidecar, encoding, etag string) bool {
+	f, err := os.Open(sidecar)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+
+	ctype := mime.TypeByExtension(filepath.Ext(path))
+	if ctype == "" {
+		ctype = "text/plain; charset=utf-8"
+	}
+	c.Header("Content-Type", ctype)
+	c.Header("Content-Encoding", encoding)
+	if etag != "" {
+		c.Header("ETag", encodedETag(etag, encoding))
+	}
+	http.ServeContent(c.Writer, c.Request, filepath.Base(path), info.ModTime(), f)
+	return true
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"net/http"
+	"server-master/internal/metrics"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// MetricsAuth defines the interface for metrics endpoint authorization.
+type MetricsAuth interface {
+	MetricsEnabled() bool
+	ValidateMetricsToken(token string) bool
+}
+
+type MetricsHandler struct {
+	auth    MetricsAuth
+	handler http.Handler
+}
+
+func NewMetricsHandler(a MetricsAuth) *MetricsHandler {
+	return &MetricsHandler{
+		auth:    a,
+		handler: promhttp.HandlerFor(metrics.Server, promhttp.HandlerOpts{}),
+	}
+}
+
+// Register registers the metrics route to the router.
+func (h *MetricsHandler) Register(r *gin.RouterGroup) {
+	r.GET("/metrics", h.Handle)
+}
+
+func (h *MetricsHandler) Handle(c *gin.Context) {
+	if !h.auth.MetricsEnabled() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+		return
+	}
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !h.auth.ValidateMetricsToken(token) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid metrics token"})
+		return
+	}
+	h.handler.ServeHTTP(c.Writer, c.Request)
+}
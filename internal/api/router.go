@@ -1,6 +1,9 @@
 package api
 
 import (
+	"fmt"
+	"net/netip"
+	"server-master/internal/config"
 	"server-master/internal/service"
 
 	"github.com/gin-gonic/gin"
@@ -11,12 +14,28 @@ type Router interface {
 	Register(r *gin.RouterGroup)
 }
 
-func NewRouter(routers ...Router) *gin.Engine {
+// trustedProxyKey is the gin context key set on requests whose connection
+// comes from a trusted proxy.
+const trustedProxyKey = "trustedProxy"
+
+// NewRouter creates a router that trusts only the given proxies, addresses
+// or CIDRs, to set the client IP through X-Forwarded-For and the external
+// URL through X-Forwarded-Proto and X-Forwarded-Host. With none, ClientIP
+// is the address of the connection.
+func NewRouter(ids TokenIdentifier, trustedProxies []string, routers ...Router) (*gin.Engine, error) {
 	r := gin.New()
-	
+	if err := r.SetTrustedProxies(trustedProxies); err != nil {
+		return nil, err
+	}
+	trusted, err := parsePrefixes(trustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
 	// Use standard middlewares
-	r.Use(gin.Logger())
+	r.Use(AccessLog(ids))
 	r.Use(gin.Recovery())
+	r.Use(markTrustedProxy(trusted))
 
 	// Create a root group to pass to routers
 	root := r.Group("/")
@@ -24,13 +43,57 @@ func NewRouter(routers ...Router) *gin.Engine {
 		router.Register(root)
 	}
 
-	return r
+	return r, nil
 }
 
-// NewDefaultRouter creates a router with all standard handlers initialized.
-func NewDefaultRouter(svcs *service.Container) *gin.Engine {
-	return NewRouter(
-		NewSubHandler(svcs.Subscription),
-		NewFileHandler(svcs.File),
+// markTrustedProxy marks the requests whose connection comes from one of
+// the trusted prefixes.
+func markTrustedProxy(trusted []netip.Prefix) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if addr, err := netip.ParseAddr(c.RemoteIP()); err == nil {
+			addr = addr.Unmap()
+			for _, p := range trusted {
+				if p.Contains(addr) {
+					c.Set(trustedProxyKey, true)
+					break
+				}
+			}
+		}
+		c.Next()
+	}
+}
+
+// parsePrefixes parses addresses and CIDRs, taking addresses as
+// single-address prefixes.
+func parsePrefixes(list []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(list))
+	for _, s := range list {
+		if p, err := netip.ParsePrefix(s); err == nil {
+			prefixes = append(prefixes, p.Masked())
+			continue
+		}
+		a, err := netip.ParseAddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %q", s)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(a, a.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// NewDefaultRouter creates a router with all standard handlers initialized,
+// trusting the configured trusted proxies.
+func NewDefaultRouter(cfg *config.Config, svcs *service.Container) (*gin.Engine, error) {
+	r, err := NewRouter(
+		svcs.Audit,
+		cfg.TrustedProxies,
+		NewSubHandler(svcs.Subscription, svcs.Quota, svcs.Audit, svcs.Guard),
+		NewFileHandler(svcs.File, svcs.Subscription, svcs.Quota, svcs.Guard),
+		NewAdminHandler(svcs.Admin, svcs.Cron, svcs.Subscription, svcs.Port, svcs.Health, svcs.Audit, svcs.Guard, svcs.Tokens),
+		NewMetricsHandler(svcs.Admin),
 	)
+	if err != nil {
+		return nil, fmt.Errorf("trusted-proxies: %w", err)
+	}
+	return r, nil
 }
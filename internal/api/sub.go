@@ -1,80 +1,280 @@
 package api
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"path/filepath"
 	"server-master/internal/config"
+	"server-master/internal/metrics"
 	"server-master/internal/model"
+	"server-master/internal/render"
+	"server-master/internal/service"
+	"server-master/pkg/compress"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"gopkg.in/yaml.v3"
 )
 
+// TokenValidator defines the interface for checking subscription tokens.
+type TokenValidator interface {
+	ValidateToken(token string) bool
+}
+
 // SubscriptionService defines the interface for subscription management.
 type SubscriptionService interface {
-	GenerateConfig(ctx context.Context) (*model.ClashConfig, string, error)
-	ValidateToken(token string) bool
+	TokenValidator
+	GenerateConfig(ctx context.Context, token string) (*model.ClashConfig, string, error)
+	TokenLabel(token string) string
 	GetConfig() config.SubscriptionConfig
 }
 
+// QuotaService defines the interface for per-token quota lookups.
+type QuotaService interface {
+	UserInfo(token string) (string, bool)
+	Expired(token string) bool
+}
+
+// AuditService defines the interface for recording per-token access.
+type AuditService interface {
+	Record(token string, ev service.AccessEvent)
+}
+
+// GuardService defines the interface for rate limiting and client bans.
+type GuardService interface {
+	Banned(ip string) (time.Time, bool)
+	AllowIP(ip string) bool
+	AllowToken(token string) bool
+	AllowTokenID(id string) bool
+	RecordFailure(ip string)
+	ClientAllowed(token, ip string) bool
+}
+
+// tokenKey is the gin context key holding the authenticated token.
+const tokenKey = "token"
+
+// minCompressSize is the smallest subscription worth compressing.
+const minCompressSize = 1024
+
 type SubHandler struct {
 	service SubscriptionService
+	quota   QuotaService
+	audit   AuditService
+	guard   GuardService
+
+	// versions tracks Last-Modified per token and target.
+	versions *versions
 }
 
-func NewSubHandler(s SubscriptionService) *SubHandler {
-	return &SubHandler{service: s}
+func NewSubHandler(s SubscriptionService, q QuotaService, a AuditService, g GuardService) *SubHandler {
+	return &SubHandler{service: s, quota: q, audit: a, guard: g, versions: newVersions()}
 }
 
 // Register registers the subscription routes to the router.
 func (h *SubHandler) Register(r *gin.RouterGroup) {
 	sub := r.Group("/sub")
-	sub.Use(h.AuthMiddleware())
+	sub.Use(h.MetricsMiddleware(), h.GuardMiddleware(), h.AuthMiddleware(), h.AuditMiddleware())
 	{
 		sub.GET("", h.Handle)
+		sub.GET("/:token", h.Handle)
+	}
+}
+
+// MetricsMiddleware counts requests by token name and response status.
+// Requests that fail authentication are counted under "unknown".
+func (h *SubHandler) MetricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		label := "unknown"
+		if token := c.GetString(tokenKey); token != "" {
+			label = h.service.TokenLabel(token)
+		}
+		metrics.SubRequests.WithLabelValues(label, strconv.Itoa(c.Writer.Status())).Inc()
+	}
+}
+
+// AuditMiddleware records the request in the token's access history.
+func (h *SubHandler) AuditMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		h.audit.Record(c.GetString(tokenKey), service.AccessEvent{
+			IP:        c.ClientIP(),
+			UserAgent: c.Request.UserAgent(),
+			Target:    c.GetString(targetKey),
+			Status:    c.Writer.Status(),
+		})
 	}
 }
 
-// AuthMiddleware validates the subscription token before proceeding.
+// GuardMiddleware rejects banned clients and clients over their rate limit
+// before the token is looked at.
+func (h *SubHandler) GuardMiddleware() gin.HandlerFunc {
+	return guardClient(h.guard)
+}
+
+// AuthMiddleware validates the subscription token before proceeding. Failed
+// attempts count towards a ban of the client IP.
 func (h *SubHandler) AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		token := c.Query("token")
-		if token == "" {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Missing subscription token"})
+		token, ok := authenticate(c, h.service, h.guard, h.quota)
+		if !ok {
 			return
 		}
+		c.Set(tokenKey, token)
+		c.Next()
+	}
+}
 
-		if !h.service.ValidateToken(token) {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid subscription token"})
+// guardClient rejects banned clients and clients over their rate limit.
+func guardClient(g GuardService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ip := c.ClientIP()
+		if until, banned := g.Banned(ip); banned {
+			c.Header("Retry-After", strconv.Itoa(int(time.Until(until).Seconds())+1))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed attempts"})
+			return
+		}
+		if !g.AllowIP(ip) {
+			c.Header("Retry-After", "60")
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Rate limit exceeded"})
 			return
 		}
 		c.Next()
 	}
 }
 
+// authenticate checks the subscription token of the request and aborts it
+// if the token is missing, invalid, expired or over its rate limit.
+func authenticate(c *gin.Context, v TokenValidator, g GuardService, q QuotaService) (string, bool) {
+	token := tokenFromRequest(c)
+	if token == "" {
+		g.RecordFailure(c.ClientIP())
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Missing subscription token"})
+		return "", false
+	}
+
+	if !v.ValidateToken(token) {
+		g.RecordFailure(c.ClientIP())
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid subscription token"})
+		return "", false
+	}
+
+	if !g.ClientAllowed(token, c.ClientIP()) {
+		g.RecordFailure(c.ClientIP())
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Client not allowed for this token"})
+		return "", false
+	}
+
+	if !g.AllowToken(token) {
+		c.Header("Retry-After", "60")
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Rate limit exceeded"})
+		return "", false
+	}
+
+	if q.Expired(token) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Subscription expired"})
+		return "", false
+	}
+	return token, true
+}
+
 func (h *SubHandler) Handle(c *gin.Context) {
-	config, userInfo, err := h.service.GenerateConfig(c.Request.Context())
+	target := c.Query("target")
+	if target == "" {
+		target = render.Detect(c.GetHeader("User-Agent"))
+	}
+	renderer, err := render.Get(target)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.Set(targetKey, renderer.Name())
+
+	token := c.GetString(tokenKey)
+	ctx := service.WithBaseURL(c.Request.Context(), requestBaseURL(c))
+	config, userInfo, err := h.service.GenerateConfig(ctx, token)
 	if err != nil {
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate configuration"})
 		return
 	}
+	if info, ok := h.quota.UserInfo(token); ok {
+		userInfo = info
+	}
 
-	h.setClashHeaders(c, userInfo)
+	var buf bytes.Buffer
+	warnings, err := renderer.Render(&buf, config)
+	if err != nil {
+		slog.Error("Failed to render subscription", "target", renderer.Name(), "error", err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to render configuration"})
+		return
+	}
+	for _, w := range warnings {
+		slog.Warn("Subscription render warning", "target", renderer.Name(), "warning", w)
+	}
 
-	c.Status(http.StatusOK)
-	c.Header("Content-Type", "application/yaml; charset=utf-8")
-	if err := yaml.NewEncoder(c.Writer).Encode(config); err != nil {
-		_ = c.Error(err)
+	h.setClashHeaders(c, userInfo, renderer.FileExt())
+	if len(warnings) > 0 {
+		c.Header("X-Render-Warnings", strconv.Itoa(len(warnings)))
+	}
+
+	// The usage in Subscription-Userinfo changes without the content, so it
+	// is not part of the ETag; it is sent with 304 responses as well.
+	body := buf.Bytes()
+	key := service.TokenFingerprint(token) + "/" + renderer.Name()
+	etag := contentETag(body)
+	modified := h.versions.modified(key, etag)
+	c.Header("Vary", "Accept-Encoding")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Last-Modified", modified.UTC().Format(http.TimeFormat))
+
+	if enc := compress.Negotiate(c.GetHeader("Accept-Encoding")); enc != "" && len(body) >= minCompressSize {
+		if encoded, err := h.versions.encode(key, etag, enc, body); err == nil {
+			body, etag = encoded, encodedETag(etag, enc)
+			c.Header("Content-Encoding", enc)
+		}
 	}
+	c.Header("ETag", etag)
+	if notModified(c.Request, etag, modified) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Data(http.StatusOK, renderer.ContentType(), body)
 }
 
-func (h *SubHandler) setClashHeaders(c *gin.Context, userInfo string) {
+func (h *SubHandler) setClashHeaders(c *gin.Context, userInfo string, ext string) {
 	cfg := h.service.GetConfig()
-	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", cfg.Filename))
+	filename := strings.TrimSuffix(cfg.Filename, filepath.Ext(cfg.Filename)) + ext
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
 	c.Header("profile-update-interval", strconv.Itoa(cfg.UpdateInterval))
 	c.Header("profile-web-page-url", cfg.ProfileURL)
 	if userInfo != "" {
 		c.Header("Subscription-Userinfo", userInfo)
 	}
 }
+
+// requestBaseURL returns the URL of this server as used by the client,
+// honouring the X-Forwarded-Proto and X-Forwarded-Host headers only when
+// they were set by a trusted proxy.
+func requestBaseURL(c *gin.Context) string {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	host := c.Request.Host
+	if !c.GetBool(trustedProxyKey) {
+		return scheme + "://" + host
+	}
+	if proto := c.GetHeader("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+	if fwd, _, _ := strings.Cut(c.GetHeader("X-Forwarded-Host"), ","); strings.TrimSpace(fwd) != "" {
+		host = strings.TrimSpace(fwd)
+	}
+	return scheme + "://" + host
+}
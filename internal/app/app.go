@@ -5,13 +5,17 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
 	"net/http"
 	"os"
+	"reflect"
 	"server-master/internal/api"
 	"server-master/internal/config"
 	"server-master/internal/service"
 	"server-master/pkg/logger"
 	"server-master/pkg/utils"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -19,17 +23,31 @@ import (
 
 // App manages the application's lifecycle and dependencies.
 type App struct {
+	configPath  string
 	cfg         *config.Config
+	svcs        *service.Container
+	queue       *utils.Queue[string]
 	cronService *service.CronService
 	server      *http.Server
+	challenge   *http.Server  // ACME HTTP-01 responder, if enabled
+	certs       *certReloader // certificate files, if configured
+	reloadMu    sync.Mutex
+}
+
+// cronTask describes a background task and when a config change requires it
+// to be re-initialized.
+type cronTask struct {
+	task    service.Task
+	enabled func(c *config.Config) bool
+	changed func(old, new *config.Config) bool
 }
 
 // New creates and assembles a new App instance.
 func New(configPath string) (*App, error) {
 	// 1. Load Config
-	cfg, err := config.Load(configPath)
+	cfg, err := loadConfig(configPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load config: %w", err)
+		return nil, err
 	}
 
 	// 2. Init Logger
@@ -48,33 +66,212 @@ func New(configPath string) (*App, error) {
 	queue := utils.NewQueue[string](cfg.Cron.DynamicPort.ActiveNum)
 	svcs := service.NewContainer(cfg, queue)
 
+	a := &App{
+		configPath:  configPath,
+		cfg:         cfg,
+		svcs:        svcs,
+		queue:       queue,
+		cronService: svcs.Cron,
+	}
+	svcs.Tokens.OnChange(a.Reload)
+
 	// 4. Register Cron Tasks
-	cronService := service.NewCronService()
-	if cfg.Cron.DynamicPort.Enable {
-		if err := cronService.AddTask(svcs.Port); err != nil {
-			slog.Error("Failed to register dynamic port task", "error", err)
+	for _, t := range a.cronTasks() {
+		if !t.enabled(cfg) {
+			continue
 		}
-	}
-	if cfg.Cron.RuleSet.Enable {
-		if err := cronService.AddTask(svcs.Ruleset); err != nil {
-			slog.Error("Failed to register ruleset task", "error", err)
+		if err := a.cronService.AddTask(t.task); err != nil {
+			slog.Error("Failed to register task", "name", t.task.Name(), "error", err)
 		}
 	}
 
 	// 5. Build Router using default services
-	router := api.NewDefaultRouter(svcs)
+	router, err := api.NewDefaultRouter(cfg, svcs)
+	if err != nil {
+		return nil, err
+	}
 
 	// 6. Build HTTP Server
-	server := &http.Server{
+	a.server = &http.Server{
 		Addr:    cfg.Listen,
 		Handler: router,
 	}
+	if err := a.setupTLS(cfg.TLS); err != nil {
+		return nil, fmt.Errorf("failed to set up TLS: %w", err)
+	}
 
-	return &App{
-		cfg:         cfg,
-		cronService: cronService,
-		server:      server,
-	}, nil
+	return a, nil
+}
+
+func (a *App) cronTasks() []cronTask {
+	return []cronTask{
+		{
+			task:    a.svcs.Port,
+			enabled: func(c *config.Config) bool { return c.Cron.DynamicPort.Enable },
+			// Any change to the port range or target needs the iptables chain rebuilt.
+			changed: func(old, new *config.Config) bool { return old.Cron.DynamicPort != new.Cron.DynamicPort },
+		},
+		{
+			task:    a.svcs.Ruleset,
+			enabled: func(c *config.Config) bool { return c.Cron.RuleSet.Enable },
+			// Sources are read on every run, so only the schedule matters.
+			changed: func(old, new *config.Config) bool { return old.Cron.RuleSet.Cycle != new.Cron.RuleSet.Cycle },
+		},
+		{
+			task:    a.svcs.Quota,
+			enabled: func(c *config.Config) bool { return c.Cron.Traffic.Enable },
+			changed: trafficChanged,
+		},
+		{
+			task:    a.svcs.Health,
+			enabled: func(c *config.Config) bool { return c.Cron.HealthCheck.Enable },
+			changed: func(old, new *config.Config) bool { return old.Cron.HealthCheck.Cycle != new.Cron.HealthCheck.Cycle },
+		},
+	}
+}
+
+// trafficChanged reports whether the traffic source must be rebuilt, which
+// is the case when its settings or the set of counted tokens change.
+func trafficChanged(old, new *config.Config) bool {
+	if old.Cron.Traffic != new.Cron.Traffic {
+		return true
+	}
+	counted := func(c *config.Config) []string {
+		var out []string
+		for _, t := range c.Tokens {
+			if t.Quota != nil {
+				out = append(out, fmt.Sprintf("%s:%d", t.Name, t.Quota.Port))
+			}
+		}
+		return out
+	}
+	return !slices.Equal(counted(old), counted(new))
+}
+
+// loadConfig reads the config file and merges in the tokens of the token
+// store.
+func loadConfig(path string) (*config.Config, error) {
+	cfg, err := config.Load(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+	if cfg.TokenStore.Path == "" {
+		return cfg, nil
+	}
+	tokens, err := service.LoadTokens(cfg.TokenStore.Path)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.MergeTokens(tokens); err != nil {
+		return nil, fmt.Errorf("invalid token store: %w", err)
+	}
+	return cfg, nil
+}
+
+// Reload re-reads the config file and swaps it into all services. Tasks are
+// re-initialized only if their settings changed; settings bound at startup
+// (listen address, logging, gin mode, TLS, trusted proxies) still require
+// a restart.
+func (a *App) Reload() error {
+	a.reloadMu.Lock()
+	defer a.reloadMu.Unlock()
+
+	newCfg, err := loadConfig(a.configPath)
+	if err != nil {
+		return err
+	}
+	old := a.cfg
+
+	if newCfg.Listen != old.Listen || newCfg.LogPath != old.LogPath || newCfg.GinMode != old.GinMode ||
+		newCfg.Log != old.Log || !reflect.DeepEqual(newCfg.TLS, old.TLS) || !slices.Equal(newCfg.TrustedProxies, old.TrustedProxies) {
+		slog.Warn("Changes to listen, log, log-path, gin-mode, tls or trusted-proxies take effect after restart")
+	}
+
+	// 1. Stop tasks that are disabled or changed while they still see the
+	// old config, so that cleanup undoes exactly what Init set up.
+	tasks := a.cronTasks()
+	start := make([]bool, len(tasks))
+	for i, t := range tasks {
+		name := t.task.Name()
+		running := a.cronService.HasTask(name)
+		want := t.enabled(newCfg)
+		start[i] = want && (!running || t.changed(old, newCfg))
+		if running && (!want || start[i]) {
+			a.cronService.RemoveTask(name)
+		}
+	}
+
+	// 2. Swap the new config in
+	if !a.cronService.HasTask(a.svcs.Port.Name()) {
+		a.queue.Resize(newCfg.Cron.DynamicPort.ActiveNum)
+	}
+	a.svcs.Reload(newCfg)
+	a.cfg = newCfg
+
+	// 3. Start the tasks that were stopped or newly enabled
+	for i, t := range tasks {
+		if !start[i] {
+			continue
+		}
+		if err := a.cronService.AddTask(t.task); err != nil {
+			slog.Error("Failed to register task", "name", t.task.Name(), "error", err)
+		}
+	}
+
+	// Refresh rule files right away if their sources changed.
+	if newCfg.Cron.RuleSet.Enable && !ruleSourcesEqual(old.Cron.RuleSet, newCfg.Cron.RuleSet) {
+		if err := a.cronService.Trigger(a.svcs.Ruleset.Name()); err != nil {
+			slog.Warn("Failed to refresh rule sets", "error", err)
+		}
+	}
+
+	slog.Info("Configuration reloaded", "path", a.configPath)
+	return nil
+}
+
+func ruleSourcesEqual(a, b config.RuleSetConfig) bool {
+	return slices.EqualFunc(a.Categories, b.Categories, func(x, y config.RuleCategory) bool {
+		return x.Name == y.Name && slices.Equal(x.Sources, y.Sources)
+	})
+}
+
+// watchConfig polls the config file and the token store and reloads when
+// either changes.
+func (a *App) watchConfig(ctx context.Context, interval time.Duration) {
+	stat := func() map[string]fileState {
+		a.reloadMu.Lock()
+		paths := []string{a.configPath, a.cfg.TokenStore.Path}
+		a.reloadMu.Unlock()
+
+		out := make(map[string]fileState, len(paths))
+		for _, p := range paths {
+			if st, ok := statFile(p); ok {
+				out[p] = st
+			}
+		}
+		return out
+	}
+	last := stat()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			cur := stat()
+			if _, ok := cur[a.configPath]; !ok || maps.Equal(cur, last) {
+				continue
+			}
+			last = cur
+			slog.Info("Config file changed, reloading", "path", a.configPath)
+			if err := a.Reload(); err != nil {
+				slog.Error("Config reload failed, keeping current config", "error", err)
+			}
+		}
+	}
 }
 
 // Run starts the application and blocks until the context is canceled.
@@ -83,16 +280,39 @@ func (a *App) Run(ctx context.Context) error {
 	a.cronService.Start()
 	slog.Info("Cron tasks started")
 
-	// 2. Start HTTP Server
-	errChan := make(chan error, 1)
+	// 2. Watch config file
+	if a.cfg.Reload.Watch {
+		go a.watchConfig(ctx, time.Duration(a.cfg.Reload.Interval)*time.Second)
+	}
+
+	// 3. Start HTTP Server
+	errChan := make(chan error, 2)
 	go func() {
-		slog.Info("Server listening on " + a.cfg.Listen)
-		if err := a.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		var err error
+		if a.server.TLSConfig != nil {
+			slog.Info("Server listening on " + a.cfg.Listen + " (HTTPS)")
+			err = a.server.ListenAndServeTLS("", "")
+		} else {
+			slog.Info("Server listening on " + a.cfg.Listen)
+			err = a.server.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			errChan <- fmt.Errorf("server failed: %w", err)
 		}
 	}()
+	if a.challenge != nil {
+		go func() {
+			slog.Info("ACME challenge server listening on " + a.challenge.Addr)
+			if err := a.challenge.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				errChan <- fmt.Errorf("ACME challenge server failed: %w", err)
+			}
+		}()
+	}
+	if a.certs != nil {
+		go a.certs.watch(ctx, certCheckInterval)
+	}
 
-	// 3. Wait for Termination Signal or Error
+	// 4. Wait for Termination Signal or Error
 	select {
 	case <-ctx.Done():
 		slog.Info("Shutting down gracefully...")
@@ -100,7 +320,7 @@ func (a *App) Run(ctx context.Context) error {
 		return err
 	}
 
-	// 4. Graceful Shutdown
+	// 5. Graceful Shutdown
 	return a.Shutdown()
 }
 
@@ -116,6 +336,11 @@ func (a *App) Shutdown() error {
 	if err := a.server.Shutdown(ctx); err != nil {
 		return fmt.Errorf("server shutdown failed: %w", err)
 	}
+	if a.challenge != nil {
+		if err := a.challenge.Shutdown(ctx); err != nil {
+			return fmt.Errorf("ACME challenge server shutdown failed: %w", err)
+		}
+	}
 
 	slog.Info("Server exited")
 	return nil
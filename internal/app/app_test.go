@@ -0,0 +1,228 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestApp_Reload(t *testing.T) {
+	tempDir := t.TempDir()
+	configPath := filepath.Join(tempDir, "config.yaml")
+	countersPath := filepath.Join(tempDir, "counters.json")
+	if err := os.WriteFile(countersPath, []byte(`{}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	writeConfig := func(token string, traffic bool) {
+		t.Helper()
+		content := fmt.Sprintf(`
+listen: ":0"
+proxy-path: %q
+rule-path: %q
+log-path: %q
+tokens: [%q]
+cron:
+  traffic:
+    enable: %t
+    source: file
+    source-path: %q
+    state-path: %q
+`, filepath.Join(tempDir, "proxy.yaml"), tempDir, filepath.Join(tempDir, "server.log"), token, traffic,
+			countersPath, filepath.Join(tempDir, "traffic.json"))
+		if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeConfig("old", true)
+	a, err := New(configPath)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer a.cronService.Stop()
+
+	taskName := a.svcs.Quota.Name()
+	if !a.cronService.HasTask(taskName) {
+		t.Fatal("expected traffic task to be registered")
+	}
+
+	writeConfig("new", false)
+	if err := a.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if !a.svcs.Subscription.ValidateToken("new") || a.svcs.Subscription.ValidateToken("old") {
+		t.Error("expected tokens to be replaced after reload")
+	}
+	if a.cronService.HasTask(taskName) {
+		t.Error("expected traffic task to be removed after it was disabled")
+	}
+
+	// An invalid config must be rejected and the current one kept.
+	if err := os.WriteFile(configPath, []byte(`listen: ""`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Reload(); err == nil {
+		t.Error("expected Reload to fail for invalid config")
+	}
+	if !a.svcs.Subscription.ValidateToken("new") {
+		t.Error("expected current config to be kept after failed reload")
+	}
+}
+
+func TestApp_TrustedProxies(t *testing.T) {
+	tempDir := t.TempDir()
+	configPath := filepath.Join(tempDir, "config.yaml")
+	content := fmt.Sprintf(`
+listen: ":0"
+proxy-path: %q
+rule-path: %q
+log-path: %q
+trusted-proxies: ["10.0.0.1"]
+tokens:
+  - {token: "office", allow-ips: ["192.0.2.0/24"]}
+  - "home"
+rate-limit:
+  enable: true
+  ban-threshold: 1
+`, filepath.Join(tempDir, "proxy.yaml"), tempDir, filepath.Join(tempDir, "server.log"))
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	proxy := `
+rule-providers:
+  direct:
+    type: http
+    behavior: domain
+    url: "https://sub.example.com/file/direct-domain.yaml"
+rules:
+  - RULE-SET,direct,DIRECT
+  - MATCH,DIRECT
+`
+	if err := os.WriteFile(filepath.Join(tempDir, "proxy.yaml"), []byte(proxy), 0644); err != nil {
+		t.Fatal(err)
+	}
+	a, err := New(configPath)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer a.cronService.Stop()
+
+	get := func(remote, forwardedFor, token string) int {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/sub?token="+token, nil)
+		req.RemoteAddr = remote + ":40000"
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		w := httptest.NewRecorder()
+		a.server.Handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// A direct client cannot claim an allowed address.
+	if code := get("198.51.100.7", "192.0.2.10", "office"); code != http.StatusForbidden {
+		t.Errorf("spoofed X-Forwarded-For: got %d, want 403", code)
+	}
+	// The trusted proxy can.
+	if code := get("10.0.0.1", "192.0.2.10", "office"); code == http.StatusForbidden || code == http.StatusUnauthorized {
+		t.Errorf("forwarded by trusted proxy: got %d", code)
+	}
+
+	// A banned client cannot escape its ban by claiming another address.
+	get("198.51.100.8", "", "wrong")
+	if code := get("198.51.100.8", "192.0.2.11", "office"); code != http.StatusTooManyRequests {
+		t.Errorf("banned client with spoofed X-Forwarded-For: got %d, want 429", code)
+	}
+
+	// Only a trusted proxy can set the external URL, which decides what
+	// gets signed.
+	signed := func(remote string) bool {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/sub?token=home", nil)
+		req.RemoteAddr = remote + ":40000"
+		req.Header.Set("X-Forwarded-Proto", "https")
+		req.Header.Set("X-Forwarded-Host", "sub.example.com")
+		w := httptest.NewRecorder()
+		a.server.Handler.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("subscription from %s: got %d: %s", remote, w.Code, w.Body.String())
+		}
+		return strings.Contains(w.Body.String(), "sig=")
+	}
+	if !signed("10.0.0.1") {
+		t.Error("X-Forwarded-Host of the trusted proxy was ignored")
+	}
+	if signed("198.51.100.9") {
+		t.Error("X-Forwarded-Host of a direct client was honoured")
+	}
+}
+
+func TestApp_AdminGuard(t *testing.T) {
+	tempDir := t.TempDir()
+	configPath := filepath.Join(tempDir, "config.yaml")
+	writeConfig := func(admin bool) {
+		t.Helper()
+		content := fmt.Sprintf(`
+listen: ":0"
+proxy-path: %q
+rule-path: %q
+log-path: %q
+tokens: ["sub"]
+admin:
+  enable: %t
+  token: "admin-secret"
+rate-limit:
+  enable: true
+  ban-threshold: 2
+`, filepath.Join(tempDir, "proxy.yaml"), tempDir, filepath.Join(tempDir, "server.log"), admin)
+		if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig(true)
+	a, err := New(configPath)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer a.cronService.Stop()
+
+	get := func(token string) int {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/admin/tasks", nil)
+		req.RemoteAddr = "198.51.100.7:40000"
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		a.server.Handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := get("admin-secret"); code != http.StatusOK {
+		t.Fatalf("valid admin token: got %d, want 200", code)
+	}
+	// Guessing admin tokens leads to a ban like guessing subscription tokens.
+	if code := get(""); code != http.StatusUnauthorized {
+		t.Errorf("missing admin token: got %d, want 401", code)
+	}
+	if code := get("guess"); code != http.StatusUnauthorized {
+		t.Errorf("wrong admin token: got %d, want 401", code)
+	}
+	if code := get("admin-secret"); code != http.StatusTooManyRequests {
+		t.Errorf("banned client: got %d, want 429", code)
+	}
+
+	// A disabled API does not reveal itself, not even to banned clients.
+	writeConfig(false)
+	if err := a.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if code := get("admin-secret"); code != http.StatusNotFound {
+		t.Errorf("disabled admin API: got %d, want 404", code)
+	}
+}
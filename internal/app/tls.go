@@ -0,0 +1,156 @@
+package app
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"server-master/internal/config"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// certCheckInterval is how often certificate files are checked for renewal.
+const certCheckInterval = time.Minute
+
+// fileState identifies the version of a file for change detection.
+type fileState struct {
+	mod  time.Time
+	size int64
+}
+
+func statFile(path string) (fileState, bool) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileState{}, false
+	}
+	return fileState{info.ModTime(), info.Size()}, true
+}
+
+// certReloader serves the certificate in certFile and keyFile and re-reads
+// them when they change, e.g. after a renewal by certbot.
+type certReloader struct {
+	certFile, keyFile string
+
+	mu    sync.RWMutex
+	cert  *tls.Certificate
+	state [2]fileState
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reload loads the key pair if either file changed. On failure the current
+// certificate is kept.
+func (r *certReloader) reload() error {
+	certState, _ := statFile(r.certFile)
+	keyState, _ := statFile(r.keyFile)
+	state := [2]fileState{certState, keyState}
+
+	r.mu.RLock()
+	unchanged := r.cert != nil && state == r.state
+	r.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	r.mu.Lock()
+	r.cert = &cert
+	r.state = state
+	r.mu.Unlock()
+
+	if cert.Leaf != nil {
+		slog.Info("TLS certificate loaded", "path", r.certFile, "not_after", cert.Leaf.NotAfter)
+	}
+	return nil
+}
+
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// watch polls the certificate files until ctx is done.
+func (r *certReloader) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.reload(); err != nil {
+				slog.Error("Keeping current TLS certificate", "error", err)
+			}
+		}
+	}
+}
+
+// newACMEManager builds the certificate manager for c. Certificates are
+// requested on the first TLS handshake for each domain and renewed before
+// they expire.
+func newACMEManager(c config.ACMEConfig) (*autocert.Manager, error) {
+	client := &acme.Client{DirectoryURL: c.DirectoryURL}
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read acme ca-file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("acme ca-file %s contains no certificates", c.CAFile)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+		client.HTTPClient = &http.Client{Transport: transport}
+	}
+
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(c.CacheDir),
+		HostPolicy: autocert.HostWhitelist(c.Domains...),
+		Email:      c.Email,
+		Client:     client,
+	}, nil
+}
+
+// setupTLS configures the server for HTTPS. With ACME, a second plain HTTP
+// server answers the HTTP-01 challenges.
+func (a *App) setupTLS(c config.TLSConfig) error {
+	switch {
+	case c.ACME.Enable:
+		m, err := newACMEManager(c.ACME)
+		if err != nil {
+			return err
+		}
+		a.server.TLSConfig = m.TLSConfig()
+		a.challenge = &http.Server{
+			Addr:    c.ACME.HTTPListen,
+			Handler: m.HTTPHandler(nil),
+		}
+	case c.CertFile != "":
+		r, err := newCertReloader(c.CertFile, c.KeyFile)
+		if err != nil {
+			return err
+		}
+		a.server.TLSConfig = &tls.Config{GetCertificate: r.GetCertificate, MinVersion: tls.VersionTLS12}
+		a.certs = r
+	}
+	return nil
+}
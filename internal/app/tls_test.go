@@ -0,0 +1,140 @@
+package app
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"server-master/internal/config"
+	"testing"
+	"time"
+)
+
+// writeSelfSigned writes a self-signed certificate with the given serial
+// number and its key.
+func writeSelfSigned(t *testing.T, certFile, keyFile string, serial int64) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeSelfSigned(t, certFile, keyFile, 1)
+
+	r, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("newCertReloader failed: %v", err)
+	}
+	serial := func() int64 {
+		t.Helper()
+		cert, err := r.GetCertificate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert.Leaf.SerialNumber.Int64()
+	}
+	// touch moves the modification time forward, since a rewrite within the
+	// file system's timestamp granularity would go unnoticed.
+	touch := func(offset time.Duration) {
+		t.Helper()
+		for _, f := range []string{certFile, keyFile} {
+			if err := os.Chtimes(f, time.Now().Add(offset), time.Now().Add(offset)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// A renewal is picked up.
+	writeSelfSigned(t, certFile, keyFile, 2)
+	touch(time.Minute)
+	if err := r.reload(); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	if got := serial(); got != 2 {
+		t.Errorf("serial after renewal = %d, want 2", got)
+	}
+
+	// A broken renewal keeps the current certificate.
+	if err := os.WriteFile(certFile, []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	touch(2 * time.Minute)
+	if err := r.reload(); err == nil {
+		t.Error("expected reload of a broken certificate to fail")
+	}
+	if got := serial(); got != 2 {
+		t.Errorf("serial after failed reload = %d, want 2", got)
+	}
+}
+
+func TestNewACMEManager(t *testing.T) {
+	dir := t.TempDir()
+	c := config.ACMEConfig{
+		Enable:       true,
+		Domains:      []string{"sub.example.com"},
+		DirectoryURL: "https://127.0.0.1:14000/dir",
+		CacheDir:     dir,
+	}
+
+	m, err := newACMEManager(c)
+	if err != nil {
+		t.Fatalf("newACMEManager failed: %v", err)
+	}
+	if m.Client.DirectoryURL != c.DirectoryURL {
+		t.Errorf("DirectoryURL = %q, want %q", m.Client.DirectoryURL, c.DirectoryURL)
+	}
+	if err := m.HostPolicy(t.Context(), "other.example.com"); err == nil {
+		t.Error("expected certificates to be limited to the configured domains")
+	}
+
+	// Requests other than challenges are redirected to HTTPS.
+	w := httptest.NewRecorder()
+	m.HTTPHandler(nil).ServeHTTP(w, httptest.NewRequest("GET", "http://sub.example.com/sub/abc", nil))
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "https://sub.example.com/sub/abc" {
+		t.Errorf("got %d %q, want redirect to https", w.Code, w.Header().Get("Location"))
+	}
+
+	// The CA of a private directory can be trusted explicitly.
+	c.CAFile = filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(c.CAFile, []byte("not a certificate"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newACMEManager(c); err == nil {
+		t.Error("expected a ca-file without certificates to be rejected")
+	}
+	writeSelfSigned(t, c.CAFile, filepath.Join(dir, "ca-key.pem"), 1)
+	if _, err := newACMEManager(c); err != nil {
+		t.Errorf("newACMEManager with ca-file failed: %v", err)
+	}
+}
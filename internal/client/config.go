@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 
 	"server-master/internal/model"
+	"server-master/pkg/fetch"
 
 	"gopkg.in/yaml.v3"
 )
@@ -19,6 +20,13 @@ type Config struct {
 	Overrides      *ConfigOverrides `yaml:"overrides,omitempty" json:"overrides,omitempty"`
 	Mihomo         MihomoConfig     `yaml:"mihomo" json:"mihomo"`
 	Log            LogConfig        `yaml:"log" json:"log"`
+	Metrics        MetricsConfig    `yaml:"metrics" json:"metrics"`
+}
+
+// MetricsConfig controls the Prometheus listener in daemon mode
+type MetricsConfig struct {
+	// Listen is the address serving /metrics; empty disables the listener.
+	Listen string `yaml:"listen" json:"listen"`
 }
 
 type LogConfig struct {
@@ -41,6 +49,9 @@ type Addition struct {
 	GroupType    string   `yaml:"group-type" json:"group_type"`
 	UserAgent    string   `yaml:"user-agent" json:"user_agent"`
 	PrependRules []string `yaml:"prepend-rules" json:"prepend_rules"`
+
+	// Transport options: timeout, retries, proxy, headers and basic auth.
+	fetch.Options `yaml:",inline"`
 }
 
 // ConfigOverrides 定义需要覆盖的 Clash 配置项
@@ -92,6 +103,9 @@ func (c *Config) Validate() error {
 		if add.GroupName == "" {
 			return fmt.Errorf("addition[%d]: group-name is required", i)
 		}
+		if err := add.Options.Validate(add.URL); err != nil {
+			return fmt.Errorf("addition[%d]: %w", i, err)
+		}
 	}
 	if c.Mihomo.Enable {
 		if c.Mihomo.BinPath == "" {
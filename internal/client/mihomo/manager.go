@@ -9,6 +9,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"server-master/internal/client"
+	"server-master/internal/metrics"
 	"sync"
 	"syscall"
 	"time"
@@ -54,11 +55,13 @@ func (m *Manager) supervisor(ctx context.Context) {
 				slog.Error("Mihomo kernel exited with error", "error", err)
 				if ctx.Err() == nil {
 					slog.Info("Restarting mihomo in 5 seconds... (like Restart=always)")
+					metrics.MihomoRestarts.WithLabelValues("error").Inc()
 					time.Sleep(5 * time.Second)
 				}
 			} else {
 				slog.Info("Mihomo kernel exited gracefully")
 				if ctx.Err() == nil {
+					metrics.MihomoRestarts.WithLabelValues("exit").Inc()
 					time.Sleep(1 * time.Second)
 				}
 			}
@@ -1,13 +1,17 @@
 package client
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
+	"server-master/internal/metrics"
 	"server-master/internal/model"
+	"server-master/pkg/fetch"
 	"sync"
 	"time"
 
@@ -18,7 +22,18 @@ import (
 type Syncer struct {
 	cfg        *Config
 	httpClient *http.Client
+	fetcher    *fetch.Fetcher
 	ReloadFunc func(context.Context) error
+
+	// upstream is the last configuration served by ServerMaster, reused when
+	// it answers a conditional request with 304 Not Modified.
+	upstream upstreamCache
+}
+
+type upstreamCache struct {
+	body         []byte
+	etag         string
+	lastModified string
 }
 
 func NewSyncer(cfg *Config) *Syncer {
@@ -27,10 +42,24 @@ func NewSyncer(cfg *Config) *Syncer {
 		httpClient: &http.Client{
 			Timeout: 30 * time.Second,
 		},
+		fetcher: fetch.New(30 * time.Second),
 	}
 }
 
+// Sync fetches, merges and saves the configuration, then triggers a reload.
 func (s *Syncer) Sync(ctx context.Context) error {
+	start := time.Now()
+	err := s.sync(ctx)
+
+	metrics.SyncDuration.Observe(time.Since(start).Seconds())
+	metrics.Syncs.WithLabelValues(metrics.Result(err)).Inc()
+	if err == nil {
+		metrics.LastSuccessfulSync.SetToCurrentTime()
+	}
+	return err
+}
+
+func (s *Syncer) sync(ctx context.Context) error {
 	slog.Info("Starting synchronization and merge...")
 
 	var finalCfg *model.ClashConfig
@@ -68,9 +97,14 @@ func (s *Syncer) Sync(ctx context.Context) error {
 	}
 
 	// 5. Save final configuration
-	if err := s.saveConfig(finalCfg); err != nil {
+	changed, err := s.saveConfig(finalCfg)
+	if err != nil {
 		return err
 	}
+	if !changed {
+		slog.Info("Configuration unchanged, skipping reload")
+		return nil
+	}
 
 	// 6. Trigger reload
 	if s.ReloadFunc != nil {
@@ -84,11 +118,21 @@ func (s *Syncer) Sync(ctx context.Context) error {
 	return nil
 }
 
+// fetchUpstream downloads the configuration from ServerMaster. Once a copy
+// is cached the request is conditional, and a 304 reuses the copy.
 func (s *Syncer) fetchUpstream(ctx context.Context) (*model.ClashConfig, error) {
 	req, err := http.NewRequestWithContext(ctx, "GET", s.cfg.ServerURL, nil)
 	if err != nil {
 		return nil, err
 	}
+	if s.upstream.body != nil {
+		if s.upstream.etag != "" {
+			req.Header.Set("If-None-Match", s.upstream.etag)
+		}
+		if s.upstream.lastModified != "" {
+			req.Header.Set("If-Modified-Since", s.upstream.lastModified)
+		}
+	}
 
 	resp, err := s.httpClient.Do(req)
 	if err != nil {
@@ -96,14 +140,30 @@ func (s *Syncer) fetchUpstream(ctx context.Context) (*model.ClashConfig, error)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
+	var body []byte
+	switch {
+	case resp.StatusCode == http.StatusNotModified && s.upstream.body != nil:
+		slog.Info("Upstream config not modified")
+		body = s.upstream.body
+	case resp.StatusCode == http.StatusOK:
+		if body, err = io.ReadAll(resp.Body); err != nil {
+			return nil, err
+		}
+	default:
 		return nil, fmt.Errorf("upstream returned %s", resp.Status)
 	}
 
 	var cfg model.ClashConfig
-	if err := yaml.NewDecoder(resp.Body).Decode(&cfg); err != nil {
+	if err := yaml.Unmarshal(body, &cfg); err != nil {
 		return nil, err
 	}
+	if resp.StatusCode == http.StatusOK {
+		s.upstream = upstreamCache{
+			body:         body,
+			etag:         resp.Header.Get("ETag"),
+			lastModified: resp.Header.Get("Last-Modified"),
+		}
+	}
 	return &cfg, nil
 }
 
@@ -114,29 +174,18 @@ func (s *Syncer) mergeAdditions(ctx context.Context, base *model.ClashConfig) er
 
 	for _, it := range s.cfg.Additions {
 		g.Go(func() error {
-			req, err := http.NewRequestWithContext(ctx, "GET", it.URL, nil)
-			if err != nil {
-				return err
-			}
 			ua := it.UserAgent
 			if ua == "" {
 				ua = "Clash"
 			}
-			req.Header.Set("User-Agent", ua)
-
-			resp, err := s.httpClient.Do(req)
+			resp, err := s.fetcher.Fetch(ctx, it.URL, ua, it.Options)
 			if err != nil {
-				return err
+				return fmt.Errorf("addition %s: %w", it.URL, err)
 			}
-			defer resp.Body.Close()
 
-			if resp.StatusCode != http.StatusOK {
-				return fmt.Errorf("addition %s returned %s", it.URL, resp.Status)
-			}
-
-			var data model.ClashConfig
-			if err := yaml.NewDecoder(resp.Body).Decode(&data); err != nil {
-				return err
+			data, err := model.ParseSubscription(resp.Body)
+			if err != nil {
+				return fmt.Errorf("addition %s: %w", it.URL, err)
 			}
 
 			mu.Lock()
@@ -161,22 +210,27 @@ func (s *Syncer) mergeAdditions(ctx context.Context, base *model.ClashConfig) er
 	return g.Wait()
 }
 
-func (s *Syncer) saveConfig(cfg *model.ClashConfig) error {
+// saveConfig writes cfg to the config path and reports whether the file
+// changed. An identical file is left untouched.
+func (s *Syncer) saveConfig(cfg *model.ClashConfig) (bool, error) {
 	if err := os.MkdirAll(filepath.Dir(s.cfg.ConfigPath), 0755); err != nil {
-		return fmt.Errorf("failed to create config directory: %w", err)
+		return false, fmt.Errorf("failed to create config directory: %w", err)
 	}
 
 	data, err := yaml.Marshal(cfg)
 	if err != nil {
-		return fmt.Errorf("failed to marshal final config: %w", err)
+		return false, fmt.Errorf("failed to marshal final config: %w", err)
+	}
+	if current, err := os.ReadFile(s.cfg.ConfigPath); err == nil && bytes.Equal(current, data) {
+		return false, nil
 	}
 
 	if err := os.WriteFile(s.cfg.ConfigPath, data, 0644); err != nil {
-		return fmt.Errorf("failed to save configuration: %w", err)
+		return false, fmt.Errorf("failed to save configuration: %w", err)
 	}
 
 	slog.Info("Configuration saved", "path", s.cfg.ConfigPath)
-	return nil
+	return true, nil
 }
 
 // applyOverrides 将客户端配置覆盖应用到 Clash 配置
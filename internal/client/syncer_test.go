@@ -2,13 +2,16 @@ package client
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"server-master/internal/metrics"
 	"server-master/internal/model"
 	"testing"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"gopkg.in/yaml.v3"
 )
 
@@ -173,3 +176,73 @@ func TestSyncer_Sync_Overrides(t *testing.T) {
 		t.Errorf("Expected DNS.Nameserver ['223.5.5.5'], got %v", final.DNS.Nameserver)
 	}
 }
+
+func TestSyncer_Sync_Metrics(t *testing.T) {
+	serverMaster := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer serverMaster.Close()
+
+	cfg := &Config{
+		ServerURL:  serverMaster.URL + "/sub",
+		ConfigPath: filepath.Join(t.TempDir(), "final.yaml"),
+	}
+
+	failures := metrics.Syncs.WithLabelValues("failure")
+	before := testutil.ToFloat64(failures)
+
+	if err := NewSyncer(cfg).Sync(context.Background()); err == nil {
+		t.Fatal("expected Sync to fail when upstream is unavailable")
+	}
+	if got := testutil.ToFloat64(failures) - before; got != 1 {
+		t.Errorf("failure count increased by %v, want 1", got)
+	}
+}
+
+func TestSyncer_Sync_Conditional(t *testing.T) {
+	rules := []string{"MATCH,DIRECT"}
+	notModified := 0
+	serverMaster := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := yaml.Marshal(model.ClashConfig{Proxies: []model.ClashProxy{{Name: "ServerProxy"}}, Rules: rules})
+		etag := fmt.Sprintf(`"%d"`, len(body))
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write(body)
+	}))
+	defer serverMaster.Close()
+
+	configPath := filepath.Join(t.TempDir(), "final.yaml")
+	syncer := NewSyncer(&Config{ServerURL: serverMaster.URL + "/sub", ConfigPath: configPath})
+	reloads := 0
+	syncer.ReloadFunc = func(context.Context) error { reloads++; return nil }
+
+	for range 2 {
+		if err := syncer.Sync(context.Background()); err != nil {
+			t.Fatalf("Sync failed: %v", err)
+		}
+	}
+	if notModified != 1 {
+		t.Errorf("server answered %d requests with 304, want 1", notModified)
+	}
+	if reloads != 1 {
+		t.Errorf("reloaded %d times, want 1 for an unchanged config", reloads)
+	}
+
+	rules = []string{"DOMAIN,example.com,DIRECT", "MATCH,DIRECT"}
+	if err := syncer.Sync(context.Background()); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if reloads != 2 {
+		t.Errorf("reloaded %d times after an upstream change, want 2", reloads)
+	}
+	data, _ := os.ReadFile(configPath)
+	var final model.ClashConfig
+	yaml.Unmarshal(data, &final)
+	if len(final.Rules) != 2 {
+		t.Errorf("expected the updated rules to be saved, got %v", final.Rules)
+	}
+}
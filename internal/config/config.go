@@ -2,117 +2,526 @@ package config
 
 import (
 	"fmt"
+	"net/netip"
+	"net/url"
 	"os"
+	"path"
+	"regexp"
+	"server-master/internal/model"
+	"server-master/pkg/fetch"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 // Config represents the root configuration structure
 type Config struct {
-        Listen       string             `yaml:"listen" json:"listen"`
-        GinMode      string             `yaml:"gin-mode" json:"gin_mode"`
-        Log          LogConfig          `yaml:"log" json:"log"`
-        ProxyPath    string             `yaml:"proxy-path" json:"proxy_path"`
-        Tokens       []string           `yaml:"tokens" json:"tokens"`
-        LogPath      string             `yaml:"log-path" json:"log_path"`
-        RulePath     string             `yaml:"rule-path" json:"rule_path"`
-        Additions    []Addition         `yaml:"additions" json:"additions"`
-        Cron         CronConfig         `yaml:"cron" json:"cron"`
-        Subscription SubscriptionConfig `yaml:"subscription" json:"subscription"`
+	Listen string `yaml:"listen" json:"listen"`
+	// TrustedProxies lists the addresses or CIDRs of reverse proxies whose
+	// X-Forwarded-* headers are honoured. Empty trusts no proxy.
+	TrustedProxies []string            `yaml:"trusted-proxies" json:"trusted_proxies"`
+	GinMode        string              `yaml:"gin-mode" json:"gin_mode"`
+	Log            LogConfig           `yaml:"log" json:"log"`
+	ProxyPath      string              `yaml:"proxy-path" json:"proxy_path"`
+	Tokens         []Token             `yaml:"tokens" json:"tokens"`
+	TokenStore     TokenStoreConfig    `yaml:"token-store" json:"token_store"`
+	Profiles       map[string]Profile  `yaml:"profiles" json:"profiles"`
+	LogPath        string              `yaml:"log-path" json:"log_path"`
+	RulePath       string              `yaml:"rule-path" json:"rule_path"`
+	Additions      []Addition          `yaml:"additions" json:"additions"`
+	ProxyGroups    []ProxyGroup        `yaml:"proxy-groups" json:"proxy_groups"`
+	Dedup          bool                `yaml:"dedup" json:"dedup"` // drop addition proxies whose server:port is already present
+	Cache          CacheConfig         `yaml:"cache" json:"cache"`
+	Cron           CronConfig          `yaml:"cron" json:"cron"`
+	Subscription   SubscriptionConfig  `yaml:"subscription" json:"subscription"`
+	Reload         ReloadConfig        `yaml:"reload" json:"reload"`
+	Admin          AdminConfig         `yaml:"admin" json:"admin"`
+	RateLimit      RateLimitConfig     `yaml:"rate-limit" json:"rate_limit"`
+	Metrics        MetricsConfig       `yaml:"metrics" json:"metrics"`
+	TLS            TLSConfig           `yaml:"tls" json:"tls"`
+	RuleProviders  RuleProvidersConfig `yaml:"rule-providers" json:"rule_providers"`
+
+	// tokenIndex maps token values to Tokens entries; built by Validate.
+	tokenIndex map[string]int
+	// slowTokens lists the Tokens entries stored as bcrypt/argon2id hashes.
+	slowTokens []int
+	// verified caches the sha256 form of presented tokens that matched a
+	// slow hash, since verifying one takes tens of milliseconds.
+	verified *sync.Map
+}
+
+// TokenStoreConfig locates the file of tokens managed through the CLI and
+// the admin API
+type TokenStoreConfig struct {
+	// Path of the JSON store; empty disables it.
+	Path string `yaml:"path" json:"path"`
+}
+
+// CacheConfig controls caching of the additions' upstream subscriptions
+type CacheConfig struct {
+	// Dir stores the last good copy of every addition so that it survives
+	// restarts. Empty disables persistence.
+	Dir string `yaml:"dir" json:"dir"`
+	// TTL in seconds after which an addition is refetched in the background.
+	TTL int `yaml:"ttl" json:"ttl"`
+	// MaxStale in seconds is how long the last good copy is served while
+	// refetches keep failing.
+	MaxStale int `yaml:"max-stale" json:"max_stale"`
+}
+
+// ReloadConfig controls automatic reloading of the config file
+type ReloadConfig struct {
+	// Watch polls the config file and reloads it when it changes.
+	Watch bool `yaml:"watch" json:"watch"`
+	// Interval is the polling interval in seconds.
+	Interval int `yaml:"interval" json:"interval"`
+}
+
+// RateLimitConfig protects /sub against token guessing and abuse
+type RateLimitConfig struct {
+	Enable bool `yaml:"enable" json:"enable"`
+	// IPRate and TokenRate are the sustained requests per minute allowed per
+	// client IP and per token; bursts of the same size are allowed.
+	IPRate    int `yaml:"ip-rate" json:"ip_rate"`
+	TokenRate int `yaml:"token-rate" json:"token_rate"`
+	// An IP is banned for BanDuration seconds after BanThreshold failed
+	// authentications within BanWindow seconds.
+	BanThreshold int `yaml:"ban-threshold" json:"ban_threshold"`
+	BanWindow    int `yaml:"ban-window" json:"ban_window"`
+	BanDuration  int `yaml:"ban-duration" json:"ban_duration"`
+}
+
+// AdminConfig controls the /admin API
+type AdminConfig struct {
+	Enable bool `yaml:"enable" json:"enable"`
+	// Token is sent as "Authorization: Bearer <token>".
+	Token string `yaml:"token" json:"-"`
+}
+
+// MetricsConfig controls the Prometheus /metrics endpoint
+type MetricsConfig struct {
+	Enable bool `yaml:"enable" json:"enable"`
+	// Token must be sent as "Authorization: Bearer <token>". It is required
+	// when Enable is set.
+	Token string `yaml:"token" json:"-"`
+}
+
+// TLSConfig serves HTTPS on listen, from certificate files or via ACME
+type TLSConfig struct {
+	// CertFile and KeyFile are PEM files. They are re-read when they change,
+	// so renewals by an external tool apply without a restart.
+	CertFile string     `yaml:"cert-file" json:"cert_file"`
+	KeyFile  string     `yaml:"key-file" json:"key_file"`
+	ACME     ACMEConfig `yaml:"acme" json:"acme"`
+}
+
+// Enabled reports whether listen serves HTTPS.
+func (t TLSConfig) Enabled() bool {
+	return t.CertFile != "" || t.ACME.Enable
+}
+
+// ACMEConfig obtains and renews certificates automatically with the HTTP-01
+// challenge
+type ACMEConfig struct {
+	Enable  bool     `yaml:"enable" json:"enable"`
+	Domains []string `yaml:"domains" json:"domains"`
+	Email   string   `yaml:"email" json:"email"`
+	// DirectoryURL of the CA; defaults to Let's Encrypt.
+	DirectoryURL string `yaml:"directory-url" json:"directory_url"`
+	// CAFile is a PEM bundle trusted when talking to the CA, for private CAs
+	// and test servers such as pebble.
+	CAFile string `yaml:"ca-file" json:"ca_file"`
+	// CacheDir stores the account key and the issued certificates.
+	CacheDir string `yaml:"cache-dir" json:"cache_dir"`
+	// HTTPListen answers HTTP-01 challenges and redirects everything else to
+	// HTTPS. Defaults to ":80".
+	HTTPListen string `yaml:"http-listen" json:"http_listen"`
 }
 
 // LogConfig holds logger settings
 type LogConfig struct {
-        Level  string `yaml:"level" json:"level"`
-        Format string `yaml:"format" json:"format"`
+	Level  string `yaml:"level" json:"level"`
+	Format string `yaml:"format" json:"format"`
+}
+
+// Token grants access to /sub and selects the profile used to build the
+// subscription. It may be written as a plain string in YAML.
+type Token struct {
+	// Value is the token in plain text or hashed, see HashToken.
+	Value   string `yaml:"token" json:"token"`
+	Name    string `yaml:"name" json:"name"`
+	Profile string `yaml:"profile" json:"profile"`
+	Quota   *Quota `yaml:"quota,omitempty" json:"quota,omitempty"`
+	// AllowIPs restricts the token to these addresses or CIDR ranges.
+	AllowIPs []string `yaml:"allow-ips,omitempty" json:"allow_ips,omitempty"`
+	// Expires, if set, is when the token stops working.
+	Expires time.Time `yaml:"expires,omitempty" json:"expires,omitzero"`
+}
+
+// Expired reports whether the token or its quota has passed its expiry date
+// at now.
+func (t Token) Expired(now time.Time) bool {
+	if !t.Expires.IsZero() && now.After(t.Expires) {
+		return true
+	}
+	return t.Quota != nil && !t.Quota.Expire.IsZero() && now.After(t.Quota.Expire)
+}
+
+// Quota limits a token's traffic and lifetime. Usage is tracked under the
+// token's name, so tokens with a quota must be named.
+type Quota struct {
+	Total  ByteSize  `yaml:"total" json:"total"`
+	Expire time.Time `yaml:"expire" json:"expire"`
+	// Port is the server port whose traffic is attributed to this token by the
+	// iptables source. Defaults to cron.dynamic-port.trojan-port.
+	Port int `yaml:"port" json:"port"`
+}
+
+// UnmarshalYAML accepts both the legacy scalar form and the mapping form.
+func (t *Token) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		t.Value = node.Value
+		return nil
+	}
+	type raw Token
+	return node.Decode((*raw)(t))
+}
+
+// Profile customizes the subscription served to the tokens that select it.
+// Zero values fall back to the global settings.
+type Profile struct {
+	// ProxyPath overrides the base proxy file.
+	ProxyPath string `yaml:"proxy-path" json:"proxy_path"`
+	// Additions lists the group-names of the additions to merge. Omitted means
+	// all additions, an empty list means none.
+	Additions []string `yaml:"additions" json:"additions"`
+	// PrependRules are inserted before the additions' prepend rules.
+	PrependRules []string `yaml:"prepend-rules" json:"prepend_rules"`
+	// Groups restricts the visible proxy groups. Empty means all groups.
+	Groups []string `yaml:"groups" json:"groups"`
+	// DynamicPort toggles port randomization; unset follows cron.dynamic-port.enable.
+	DynamicPort *bool `yaml:"dynamic-port" json:"dynamic_port"`
+	// Files lists glob patterns of the rule files under rule-path that the
+	// profile may download from /file. Empty means all files.
+	Files []string `yaml:"files" json:"files"`
+}
+
+// FileVisible reports whether the profile may download the rule file name.
+func (p Profile) FileVisible(name string) bool {
+	if len(p.Files) == 0 {
+		return true
+	}
+	for _, pattern := range p.Files {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
 }
 
+// DefaultProfile is used by tokens that do not name a profile. It may be
+// overridden by defining a profile with this name.
+const DefaultProfile = "default"
+
 // SubscriptionConfig holds settings for subscription response headers
 type SubscriptionConfig struct {
-        Filename       string `yaml:"filename" json:"filename"`
-        UpdateInterval int    `yaml:"update-interval" json:"update_interval"`
-        ProfileURL     string `yaml:"profile-url" json:"profile_url"`
+	Filename       string `yaml:"filename" json:"filename"`
+	UpdateInterval int    `yaml:"update-interval" json:"update_interval"`
+	ProfileURL     string `yaml:"profile-url" json:"profile_url"`
 }
 
 // Addition represents an external subscription to be merged
 type Addition struct {
-        URL          string   `yaml:"url" json:"url"`
-        GroupName    string   `yaml:"group-name" json:"group_name"`
-        GroupType    string   `yaml:"group-type" json:"group_type"`
-        UserAgent    string   `yaml:"user-agent" json:"user_agent"`
-        PrependRules []string `yaml:"prepend-rules" json:"prepend_rules"`
+	URL          string   `yaml:"url" json:"url"`
+	GroupName    string   `yaml:"group-name" json:"group_name"`
+	GroupType    string   `yaml:"group-type" json:"group_type"`
+	UserAgent    string   `yaml:"user-agent" json:"user_agent"`
+	PrependRules []string `yaml:"prepend-rules" json:"prepend_rules"`
+
+	// Transport options: timeout, retries, proxy, headers and basic auth.
+	fetch.Options `yaml:",inline"`
+
+	// Include keeps only the proxies whose name matches this regex.
+	Include string `yaml:"include" json:"include"`
+	// Exclude drops the proxies whose name matches this regex, e.g. the
+	// "剩余流量" / "官网" info nodes many providers ship.
+	Exclude string `yaml:"exclude" json:"exclude"`
+	// Rename rules are applied in order to the names of the kept proxies.
+	Rename []RenameRule `yaml:"rename" json:"rename"`
+	// Flag prepends the emoji flag of the region detected in the name.
+	Flag   bool   `yaml:"flag" json:"flag"`
+	Prefix string `yaml:"prefix" json:"prefix"`
+	Suffix string `yaml:"suffix" json:"suffix"`
+}
+
+// ProxyGroup generates a proxy group from the proxies of the base file and
+// all additions whose names match Filter and/or Region.
+type ProxyGroup struct {
+	Name string `yaml:"name" json:"name"`
+	// Type is select, url-test, fallback or load-balance.
+	Type string `yaml:"type" json:"type"`
+	// Proxies are fixed members placed before the matched proxies, e.g.
+	// other generated groups to build a fallback chain.
+	Proxies []string `yaml:"proxies" json:"proxies"`
+	// Filter and Exclude are regexes matched against proxy names.
+	Filter  string `yaml:"filter" json:"filter"`
+	Exclude string `yaml:"exclude" json:"exclude"`
+	// Region matches proxies whose names contain keywords of this region
+	// (ISO code such as HK, JP, US).
+	Region    string `yaml:"region" json:"region"`
+	URL       string `yaml:"url" json:"url"`
+	Interval  int    `yaml:"interval" json:"interval"`
+	Tolerance int    `yaml:"tolerance" json:"tolerance"`
+	Strategy  string `yaml:"strategy" json:"strategy"`
+}
+
+// RenameRule replaces every match of a regex in proxy names. Replace may
+// reference capture groups as $1.
+type RenameRule struct {
+	Match   string `yaml:"match" json:"match"`
+	Replace string `yaml:"replace" json:"replace"`
 }
 
 // CronConfig holds configurations for background tasks
 type CronConfig struct {
-        DynamicPort DynamicPortConfig `yaml:"dynamic-port" json:"dynamic_port"`
-        RuleSet     RuleSetConfig     `yaml:"rule-set" json:"rule_set"`
+	DynamicPort DynamicPortConfig `yaml:"dynamic-port" json:"dynamic_port"`
+	RuleSet     RuleSetConfig     `yaml:"rule-set" json:"rule_set"`
+	Traffic     TrafficConfig     `yaml:"traffic" json:"traffic"`
+	HealthCheck HealthCheckConfig `yaml:"health-check" json:"health_check"`
 }
 
 // DynamicPortConfig holds settings for randomizing proxy ports
 type DynamicPortConfig struct {
-        Enable     bool   `yaml:"enable" json:"enable"`
-        Max        int    `yaml:"max" json:"max"`
-        Min        int    `yaml:"min" json:"min"`
-        ActiveNum  int    `yaml:"active-num" json:"active_num"`
-        TrojanPort int    `yaml:"trojan-port" json:"trojan_port"`
-        Cycle      string `yaml:"cycle" json:"cycle"`
+	Enable     bool   `yaml:"enable" json:"enable"`
+	Max        int    `yaml:"max" json:"max"`
+	Min        int    `yaml:"min" json:"min"`
+	ActiveNum  int    `yaml:"active-num" json:"active_num"`
+	TrojanPort int    `yaml:"trojan-port" json:"trojan_port"`
+	Cycle      string `yaml:"cycle" json:"cycle"`
 }
 
 // RuleSetConfig holds settings for automated rule updates
 type RuleSetConfig struct {
-        Enable bool     `yaml:"enable" json:"enable"`
-        Direct []string `yaml:"direct" json:"direct"`
-        Proxy  []string `yaml:"proxy" json:"proxy"`
-        Reject []string `yaml:"reject" json:"reject"`
-        Cycle  string   `yaml:"cycle" json:"cycle"`
+	Enable bool `yaml:"enable" json:"enable"`
+	// Categories are downloaded from their sources and classified into one
+	// rule file per kind, see RuleKinds.
+	Categories []RuleCategory `yaml:"categories" json:"categories"`
+	// Direct, Proxy and Reject are shorthands for the categories of these
+	// names. Validate moves them to Categories.
+	Direct []string `yaml:"direct" json:"direct,omitempty"`
+	Proxy  []string `yaml:"proxy" json:"proxy,omitempty"`
+	Reject []string `yaml:"reject" json:"reject,omitempty"`
+	Cycle  string   `yaml:"cycle" json:"cycle"`
+}
+
+// RuleCategory is a named group of rule sources, e.g. "streaming" or "ai"
+type RuleCategory struct {
+	Name    string   `yaml:"name" json:"name"`
+	Sources []string `yaml:"sources" json:"sources"`
+	// Policy the category's RULE-SET rules point to in generated
+	// subscriptions. The direct, proxy and reject categories default to
+	// the rule-providers policies.
+	Policy string `yaml:"policy" json:"policy"`
+}
+
+// RuleKinds lists the kinds rules are classified into. The rules of a
+// category of each kind are written to the rule file "<category>-<kind>".
+var RuleKinds = []string{"domain", "classic", "ip"}
+
+var categoryName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+// RuleFile returns the name of the rule file holding the rules of kind of
+// category.
+func RuleFile(category, kind string) string {
+	return category + "-" + kind
+}
+
+// RuleFiles returns the rule files written for the categories in the
+// default order of their RULE-SET rules: category by category, with IP
+// rules, which need DNS resolution, after all others.
+func (r RuleSetConfig) RuleFiles() []string {
+	var files, ipFiles []string
+	for _, c := range r.Categories {
+		for _, kind := range RuleKinds {
+			if kind == "ip" {
+				ipFiles = append(ipFiles, RuleFile(c.Name, kind))
+			} else {
+				files = append(files, RuleFile(c.Name, kind))
+			}
+		}
+	}
+	return append(files, ipFiles...)
+}
+
+// RuleFileCategory returns the category and kind of the rule file name.
+func (r RuleSetConfig) RuleFileCategory(name string) (RuleCategory, string, bool) {
+	for _, c := range r.Categories {
+		for _, kind := range RuleKinds {
+			if RuleFile(c.Name, kind) == name {
+				return c, kind, true
+			}
+		}
+	}
+	return RuleCategory{}, "", false
+}
+
+// RuleProvidersConfig controls the rule-providers and RULE-SET rules that
+// generated subscriptions get for the rule files served by /file
+type RuleProvidersConfig struct {
+	Enable bool `yaml:"enable" json:"enable"`
+	// BaseURL is the external URL of this server, e.g.
+	// https://sm.example.com. Empty derives it from each /sub request. Only
+	// rule providers under this URL are signed, even if Enable is off.
+	BaseURL string `yaml:"base-url" json:"base_url"`
+	// Interval in seconds at which clients refresh the rule files.
+	Interval int `yaml:"interval" json:"interval"`
+	// Format of the domain and ipcidr rule files: "mrs" (default) refers
+	// to their compiled copies, "yaml" to the YAML files.
+	Format string `yaml:"format" json:"format"`
+	// Order lists the rule files to reference, in rule order. Defaults to
+	// all files of the rule-set categories, see RuleSetConfig.RuleFiles.
+	Order []string `yaml:"order" json:"order"`
+	// Policies of the direct, proxy and reject categories when they do not
+	// set one.
+	DirectPolicy string `yaml:"direct-policy" json:"direct_policy"`
+	ProxyPolicy  string `yaml:"proxy-policy" json:"proxy_policy"`
+	RejectPolicy string `yaml:"reject-policy" json:"reject_policy"`
+}
+
+// TrafficConfig holds settings for collecting per-token traffic usage
+type TrafficConfig struct {
+	Enable bool `yaml:"enable" json:"enable"`
+	// Source is where byte counters come from: "iptables" or "file".
+	Source string `yaml:"source" json:"source"`
+	// SourcePath is the JSON counter file read by the file source.
+	SourcePath string `yaml:"source-path" json:"source_path"`
+	// StatePath persists accumulated usage across restarts.
+	StatePath string `yaml:"state-path" json:"state_path"`
+	Cycle     string `yaml:"cycle" json:"cycle"`
+}
+
+// HealthCheckConfig holds settings for probing proxies and excluding dead ones
+type HealthCheckConfig struct {
+	Enable bool   `yaml:"enable" json:"enable"`
+	Cycle  string `yaml:"cycle" json:"cycle"`
+	// Timeout is the per-probe timeout in seconds.
+	Timeout int `yaml:"timeout" json:"timeout"`
+	// TLS additionally performs a TLS handshake for proxies that use TLS.
+	TLS bool `yaml:"tls" json:"tls"`
+	// Threshold is the number of consecutive failures after which a node is
+	// considered dead.
+	Threshold int `yaml:"threshold" json:"threshold"`
+	// Action applied to dead nodes: "drop" removes them, "demote" moves them
+	// to the end of the proxy list and of every group.
+	Action      string `yaml:"action" json:"action"`
+	Concurrency int    `yaml:"concurrency" json:"concurrency"`
 }
 
 // Load loads the configuration from the given path
 func Load(path string) (*Config, error) {
-        data, err := os.ReadFile(path)
-        if err != nil {
-                return nil, fmt.Errorf("failed to read config file: %w", err)
-        }
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
 
-        var cfg Config
-        if err := yaml.Unmarshal(data, &cfg); err != nil {
-                return nil, fmt.Errorf("failed to unmarshal config: %w", err)
-        }
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
+	}
 
-        if err := cfg.Validate(); err != nil {
-                return nil, fmt.Errorf("invalid configuration: %w", err)
-        }
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
 
-        return &cfg, nil
+	return &cfg, nil
+}
+
+// MergeTokens appends tokens, e.g. from the token store, and validates the
+// result.
+func (c *Config) MergeTokens(tokens []Token) error {
+	if len(tokens) == 0 {
+		return nil
+	}
+	c.Tokens = append(c.Tokens, tokens...)
+	return c.Validate()
 }
 
 // Validate checks the configuration for required fields and logical consistency
 func (c *Config) Validate() error {
-        if c.Listen == "" {
-                return fmt.Errorf("listen address is required")
-        }
-        if c.GinMode == "" {
-                c.GinMode = "release"
-        }
-        if c.Log.Level == "" {
-                c.Log.Level = "info"
-        }
-        if c.Log.Format == "" {
-                c.Log.Format = "json"
-        }
-        if c.ProxyPath == "" {
-                return fmt.Errorf("proxy-path is required")
-        }
-        if len(c.Tokens) == 0 {
-                return fmt.Errorf("at least one token is required")
-        }
-        if c.LogPath == "" {
-                c.LogPath = "server.log"
-        }
-        if c.RulePath == "" {
-                return fmt.Errorf("rule-path is required")
-        }
+	if c.Listen == "" {
+		return fmt.Errorf("listen address is required")
+	}
+	for _, p := range c.TrustedProxies {
+		if _, err := netip.ParsePrefix(p); err != nil {
+			if _, err := netip.ParseAddr(p); err != nil {
+				return fmt.Errorf("trusted-proxies: invalid address %q", p)
+			}
+		}
+	}
+	if c.GinMode == "" {
+		c.GinMode = "release"
+	}
+	if c.Log.Level == "" {
+		c.Log.Level = "info"
+	}
+	if c.Log.Format == "" {
+		c.Log.Format = "json"
+	}
+	if c.ProxyPath == "" {
+		return fmt.Errorf("proxy-path is required")
+	}
+	if len(c.Tokens) == 0 && c.TokenStore.Path == "" {
+		return fmt.Errorf("at least one token is required")
+	}
+	c.tokenIndex = make(map[string]int, len(c.Tokens))
+	c.slowTokens = nil
+	c.verified = new(sync.Map)
+	for i := range c.Tokens {
+		t := &c.Tokens[i]
+		if t.Value == "" {
+			return fmt.Errorf("tokens[%d]: token is required", i)
+		}
+		if err := checkTokenHash(t.Value); err != nil {
+			return fmt.Errorf("tokens[%d]: %w", i, err)
+		}
+		if strings.HasPrefix(t.Value, sha256Prefix) {
+			t.Value = strings.ToLower(t.Value)
+		}
+		if _, ok := c.tokenIndex[t.Value]; ok {
+			return fmt.Errorf("tokens[%d]: duplicate token", i)
+		}
+		c.tokenIndex[t.Value] = i
+		if isSlowHash(t.Value) {
+			c.slowTokens = append(c.slowTokens, i)
+		}
+		if t.Quota != nil {
+			if t.Name == "" {
+				return fmt.Errorf("tokens[%d]: name is required when quota is set", i)
+			}
+			if t.Quota.Port == 0 {
+				t.Quota.Port = c.Cron.DynamicPort.TrojanPort
+			}
+		}
+		for _, ip := range t.AllowIPs {
+			if _, err := ParsePrefix(ip); err != nil {
+				return fmt.Errorf("tokens[%d]: allow-ips: %w", i, err)
+			}
+		}
+		if t.Profile != "" && t.Profile != DefaultProfile {
+			if _, ok := c.Profiles[t.Profile]; !ok {
+				return fmt.Errorf("tokens[%d]: unknown profile %q", i, t.Profile)
+			}
+		}
+	}
+	if c.LogPath == "" {
+		c.LogPath = "server.log"
+	}
+	if c.RulePath == "" {
+		return fmt.Errorf("rule-path is required")
+	}
 	for i, add := range c.Additions {
 		if add.URL == "" {
 			return fmt.Errorf("addition[%d]: URL is required", i)
@@ -120,6 +529,42 @@ func (c *Config) Validate() error {
 		if add.GroupName == "" {
 			return fmt.Errorf("addition[%d]: group-name is required", i)
 		}
+		if err := add.Options.Validate(add.URL); err != nil {
+			return fmt.Errorf("addition[%d]: %w", i, err)
+		}
+		for _, expr := range []string{add.Include, add.Exclude} {
+			if _, err := regexp.Compile(expr); err != nil {
+				return fmt.Errorf("addition[%d]: invalid regex %q: %w", i, expr, err)
+			}
+		}
+		for j, r := range add.Rename {
+			if _, err := regexp.Compile(r.Match); err != nil {
+				return fmt.Errorf("addition[%d]: rename[%d]: invalid regex %q: %w", i, j, r.Match, err)
+			}
+		}
+	}
+
+	for i := range c.ProxyGroups {
+		if err := c.ProxyGroups[i].validate(); err != nil {
+			return fmt.Errorf("proxy-groups[%d]: %w", i, err)
+		}
+	}
+
+	groups := make(map[string]bool, len(c.Additions))
+	for _, add := range c.Additions {
+		groups[add.GroupName] = true
+	}
+	for name, p := range c.Profiles {
+		for _, g := range p.Additions {
+			if !groups[g] {
+				return fmt.Errorf("profile %q: unknown addition %q", name, g)
+			}
+		}
+		for _, pattern := range p.Files {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("profile %q: invalid files pattern %q", name, pattern)
+			}
+		}
 	}
 
 	if c.Cron.DynamicPort.Enable {
@@ -131,12 +576,122 @@ func (c *Config) Validate() error {
 		}
 	}
 
-	if c.Cron.RuleSet.Enable {
-		if c.Cron.RuleSet.Cycle == "" {
-			c.Cron.RuleSet.Cycle = "@every 1h"
+	if err := c.Cron.RuleSet.validate(); err != nil {
+		return fmt.Errorf("cron.rule-set: %w", err)
+	}
+
+	if c.Cron.Traffic.Enable {
+		switch c.Cron.Traffic.Source {
+		case "iptables":
+			// Bytes are counted per port, so each quota needs its own.
+			ports := make(map[int]int)
+			for i, t := range c.Tokens {
+				if t.Quota == nil {
+					continue
+				}
+				if t.Quota.Port == 0 {
+					return fmt.Errorf("tokens[%d]: quota.port is required for iptables source", i)
+				}
+				if j, ok := ports[t.Quota.Port]; ok {
+					return fmt.Errorf("tokens[%d]: quota.port %d is already used by tokens[%d]; the iptables source counts traffic per port", i, t.Quota.Port, j)
+				}
+				ports[t.Quota.Port] = i
+			}
+		case "file":
+			if c.Cron.Traffic.SourcePath == "" {
+				return fmt.Errorf("cron.traffic: source-path is required for file source")
+			}
+		default:
+			return fmt.Errorf("cron.traffic: unknown source %q", c.Cron.Traffic.Source)
+		}
+		if c.Cron.Traffic.Cycle == "" {
+			c.Cron.Traffic.Cycle = "@every 5m"
+		}
+		if c.Cron.Traffic.StatePath == "" {
+			c.Cron.Traffic.StatePath = "traffic.json"
+		}
+	}
+
+	if c.Cron.HealthCheck.Enable {
+		h := &c.Cron.HealthCheck
+		switch h.Action {
+		case "":
+			h.Action = "drop"
+		case "drop", "demote":
+		default:
+			return fmt.Errorf("cron.health-check: unknown action %q", h.Action)
+		}
+		if h.Cycle == "" {
+			h.Cycle = "@every 5m"
+		}
+		if h.Timeout <= 0 {
+			h.Timeout = 5
+		}
+		if h.Threshold <= 0 {
+			h.Threshold = 3
+		}
+		if h.Concurrency <= 0 {
+			h.Concurrency = 16
+		}
+	}
+
+	if c.Admin.Enable {
+		if c.Admin.Token == "" {
+			return fmt.Errorf("admin: token is required")
+		}
+		if _, ok := c.Token(c.Admin.Token); ok {
+			return fmt.Errorf("admin: token must differ from subscription tokens")
 		}
 	}
 
+	if c.Metrics.Enable && c.Metrics.Token == "" {
+		return fmt.Errorf("metrics: token is required")
+	}
+
+	if err := c.TLS.validate(); err != nil {
+		return fmt.Errorf("tls: %w", err)
+	}
+	if err := c.RuleProviders.validate(c.Cron.RuleSet); err != nil {
+		return fmt.Errorf("rule-providers: %w", err)
+	}
+
+	if c.Cache.TTL <= 0 {
+		c.Cache.TTL = 300
+	}
+	if c.Cache.MaxStale <= 0 {
+		c.Cache.MaxStale = 86400
+	}
+	if c.Cache.MaxStale < c.Cache.TTL {
+		return fmt.Errorf("cache: max-stale (%d) must not be less than ttl (%d)", c.Cache.MaxStale, c.Cache.TTL)
+	}
+
+	if c.RateLimit.Enable {
+		r := &c.RateLimit
+		if r.IPRate <= 0 {
+			r.IPRate = 30
+		}
+		if r.TokenRate <= 0 {
+			r.TokenRate = 60
+		}
+		if r.BanThreshold <= 0 {
+			r.BanThreshold = 10
+		}
+		if r.BanWindow <= 0 {
+			r.BanWindow = 600
+		}
+		if r.BanDuration <= 0 {
+			r.BanDuration = 3600
+		}
+	} else if len(c.slowTokens) > 0 {
+		// Every unknown token is checked against each slow hash, so clients
+		// must be limited before they can make the server hash at will.
+		return fmt.Errorf("rate-limit: enable is required when tokens use bcrypt or argon2id hashes")
+	}
+
+	if c.Reload.Watch && c.Reload.Interval <= 0 {
+		c.Reload.Interval = 5
+	}
+
 	// Set default values for subscription
 	if c.Subscription.Filename == "" {
 		c.Subscription.Filename = "Jacko.yaml"
@@ -150,3 +705,250 @@ func (c *Config) Validate() error {
 
 	return nil
 }
+
+func (g *ProxyGroup) validate() error {
+	if g.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	switch g.Type {
+	case "":
+		g.Type = "url-test"
+	case "select", "url-test", "fallback", "load-balance":
+	default:
+		return fmt.Errorf("unknown type %q", g.Type)
+	}
+	if g.Filter == "" && g.Region == "" && len(g.Proxies) == 0 {
+		return fmt.Errorf("one of filter, region or proxies is required")
+	}
+	for _, expr := range []string{g.Filter, g.Exclude} {
+		if _, err := regexp.Compile(expr); err != nil {
+			return fmt.Errorf("invalid regex %q: %w", expr, err)
+		}
+	}
+	if g.Region != "" {
+		if _, ok := model.LookupRegion(g.Region); !ok {
+			return fmt.Errorf("unknown region %q", g.Region)
+		}
+	}
+	switch g.Strategy {
+	case "", "consistent-hashing", "round-robin", "sticky-sessions":
+	default:
+		return fmt.Errorf("unknown strategy %q", g.Strategy)
+	}
+	if g.Type != "select" {
+		if g.URL == "" {
+			g.URL = "https://www.gstatic.com/generate_204"
+		}
+		if g.Interval <= 0 {
+			g.Interval = 300
+		}
+	}
+	return nil
+}
+
+// ParsePrefix parses an IP address or CIDR range. A single address becomes
+// a full-length prefix.
+func ParsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		return p.Masked(), err
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// Token looks up the configured token matching the presented value, which
+// may be stored in plain text or hashed.
+func (c *Config) Token(value string) (Token, bool) {
+	if value == "" {
+		return Token{}, false
+	}
+	if c.tokenIndex == nil {
+		// Configs built without Validate (e.g. in tests) have no index.
+		for _, t := range c.Tokens {
+			if matchToken(t.Value, value) {
+				return t, true
+			}
+		}
+		return Token{}, false
+	}
+
+	// A presented hash must not match its stored form.
+	if i, ok := c.tokenIndex[value]; ok && !isHashed(c.Tokens[i].Value) {
+		return c.Tokens[i], true
+	}
+	hashed := sha256Token(value)
+	if i, ok := c.tokenIndex[hashed]; ok {
+		return c.Tokens[i], true
+	}
+	if i, ok := c.verified.Load(hashed); ok {
+		return c.Tokens[i.(int)], true
+	}
+	for _, i := range c.slowTokens {
+		if matchToken(c.Tokens[i].Value, value) {
+			c.verified.Store(hashed, i)
+			return c.Tokens[i], true
+		}
+	}
+	return Token{}, false
+}
+
+func (t *TLSConfig) validate() error {
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return fmt.Errorf("cert-file and key-file must be set together")
+	}
+	if !t.ACME.Enable {
+		return nil
+	}
+	a := &t.ACME
+	if t.CertFile != "" {
+		return fmt.Errorf("use either cert-file/key-file or acme")
+	}
+	if len(a.Domains) == 0 {
+		return fmt.Errorf("acme: at least one domain is required")
+	}
+	if a.CacheDir == "" {
+		return fmt.Errorf("acme: cache-dir is required")
+	}
+	if a.DirectoryURL == "" {
+		a.DirectoryURL = "https://acme-v02.api.letsencrypt.org/directory"
+	}
+	if a.HTTPListen == "" {
+		a.HTTPListen = ":80"
+	}
+	return nil
+}
+
+func (r *RuleSetConfig) validate() error {
+	legacy := []RuleCategory{{Name: "reject", Sources: r.Reject}, {Name: "direct", Sources: r.Direct}, {Name: "proxy", Sources: r.Proxy}}
+	for _, l := range legacy {
+		if len(l.Sources) == 0 {
+			continue
+		}
+		if slices.ContainsFunc(r.Categories, func(c RuleCategory) bool { return c.Name == l.Name }) {
+			return fmt.Errorf("%s is also defined in categories", l.Name)
+		}
+		r.Categories = append(r.Categories, l)
+	}
+	r.Direct, r.Proxy, r.Reject = nil, nil, nil
+
+	seen := make(map[string]bool, len(r.Categories))
+	for i, c := range r.Categories {
+		if !categoryName.MatchString(c.Name) {
+			return fmt.Errorf("categories[%d]: invalid name %q", i, c.Name)
+		}
+		if seen[c.Name] {
+			return fmt.Errorf("categories[%d]: duplicate name %q", i, c.Name)
+		}
+		seen[c.Name] = true
+	}
+
+	if r.Enable && r.Cycle == "" {
+		r.Cycle = "@every 1h"
+	}
+	return nil
+}
+
+// Policy returns the policy the RULE-SET rules of category c point to.
+func (r RuleProvidersConfig) Policy(c RuleCategory) string {
+	if c.Policy != "" {
+		return c.Policy
+	}
+	switch c.Name {
+	case "direct":
+		return r.DirectPolicy
+	case "proxy":
+		return r.ProxyPolicy
+	case "reject":
+		return r.RejectPolicy
+	}
+	return ""
+}
+
+func (r *RuleProvidersConfig) validate(rs RuleSetConfig) error {
+	// base-url also locates the hand-written providers to sign.
+	if r.BaseURL != "" {
+		u, err := url.Parse(r.BaseURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid base-url %q", r.BaseURL)
+		}
+		r.BaseURL = strings.TrimSuffix(r.BaseURL, "/")
+	}
+	if !r.Enable {
+		return nil
+	}
+	if r.Interval <= 0 {
+		r.Interval = 86400
+	}
+	switch r.Format {
+	case "":
+		r.Format = "mrs"
+	case "mrs", "yaml":
+	default:
+		return fmt.Errorf("unsupported format %q", r.Format)
+	}
+	if r.DirectPolicy == "" {
+		r.DirectPolicy = "DIRECT"
+	}
+	if r.RejectPolicy == "" {
+		r.RejectPolicy = "REJECT"
+	}
+	if len(rs.Categories) == 0 {
+		return fmt.Errorf("cron.rule-set defines no categories")
+	}
+	if len(r.Order) == 0 {
+		r.Order = rs.RuleFiles()
+	}
+	for _, name := range r.Order {
+		c, _, ok := rs.RuleFileCategory(name)
+		if !ok {
+			return fmt.Errorf("unknown rule file %q in order", name)
+		}
+		if r.Policy(c) == "" {
+			return fmt.Errorf("category %q has no policy", c.Name)
+		}
+	}
+	return nil
+}
+
+// Profile resolves the named profile with global defaults applied. Unknown
+// names resolve to the default profile.
+func (c *Config) Profile(name string) Profile {
+	if name == "" {
+		name = DefaultProfile
+	}
+	p, ok := c.Profiles[name]
+	if !ok {
+		p = c.Profiles[DefaultProfile]
+	}
+	if p.ProxyPath == "" {
+		p.ProxyPath = c.ProxyPath
+	}
+	if p.DynamicPort == nil {
+		enable := c.Cron.DynamicPort.Enable
+		p.DynamicPort = &enable
+	}
+	return p
+}
+
+// SelectAdditions returns the additions enabled for the profile, in
+// configuration order.
+func (c *Config) SelectAdditions(p Profile) []Addition {
+	if p.Additions == nil {
+		return c.Additions
+	}
+	want := make(map[string]bool, len(p.Additions))
+	for _, g := range p.Additions {
+		want[g] = true
+	}
+	var out []Addition
+	for _, add := range c.Additions {
+		if want[add.GroupName] {
+			out = append(out, add)
+		}
+	}
+	return out
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"slices"
 	"testing"
 )
 
@@ -31,7 +32,7 @@ rule-path: "rules/"
 		t.Errorf("expected listen :8080, got %s", cfg.Listen)
 	}
 
-	if len(cfg.Tokens) != 1 || cfg.Tokens[0] != "test-token" {
+	if len(cfg.Tokens) != 1 || cfg.Tokens[0].Value != "test-token" {
 		t.Error("tokens not loaded correctly")
 	}
 
@@ -58,7 +59,7 @@ func TestConfigValidate(t *testing.T) {
 			cfg: Config{
 				Listen:    ":8080",
 				ProxyPath: "p.yaml",
-				Tokens:    []string{"t"},
+				Tokens:    []Token{{Value: "t"}},
 				RulePath:  "r/",
 			},
 			wantErr: false,
@@ -67,17 +68,201 @@ func TestConfigValidate(t *testing.T) {
 			name: "missing listen",
 			cfg: Config{
 				ProxyPath: "p.yaml",
-				Tokens:    []string{"t"},
+				Tokens:    []Token{{Value: "t"}},
 				RulePath:  "r/",
 			},
 			wantErr: true,
 		},
+		{
+			name: "no tokens with token store",
+			cfg: Config{
+				Listen:     ":8080",
+				ProxyPath:  "p.yaml",
+				RulePath:   "r/",
+				TokenStore: TokenStoreConfig{Path: "tokens.json"},
+			},
+			wantErr: false,
+		},
+		{
+			name: "no tokens",
+			cfg: Config{
+				Listen:    ":8080",
+				ProxyPath: "p.yaml",
+				RulePath:  "r/",
+			},
+			wantErr: true,
+		},
+		{
+			name: "tls cert without key",
+			cfg: Config{
+				Listen:    ":8443",
+				ProxyPath: "p.yaml",
+				Tokens:    []Token{{Value: "t"}},
+				RulePath:  "r/",
+				TLS:       TLSConfig{CertFile: "cert.pem"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "tls acme without domains",
+			cfg: Config{
+				Listen:    ":8443",
+				ProxyPath: "p.yaml",
+				Tokens:    []Token{{Value: "t"}},
+				RulePath:  "r/",
+				TLS:       TLSConfig{ACME: ACMEConfig{Enable: true, CacheDir: "acme/"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "tls acme and cert files",
+			cfg: Config{
+				Listen:    ":8443",
+				ProxyPath: "p.yaml",
+				Tokens:    []Token{{Value: "t"}},
+				RulePath:  "r/",
+				TLS: TLSConfig{CertFile: "cert.pem", KeyFile: "key.pem",
+					ACME: ACMEConfig{Enable: true, Domains: []string{"example.com"}, CacheDir: "acme/"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "rule providers without proxy policy",
+			cfg: Config{
+				Listen:        ":8080",
+				ProxyPath:     "p.yaml",
+				Tokens:        []Token{{Value: "t"}},
+				RulePath:      "r/",
+				Cron:          CronConfig{RuleSet: RuleSetConfig{Proxy: []string{"proxy.txt"}}},
+				RuleProviders: RuleProvidersConfig{Enable: true},
+			},
+			wantErr: true,
+		},
+		{
+			name: "rule providers with category policies",
+			cfg: Config{
+				Listen:    ":8080",
+				ProxyPath: "p.yaml",
+				Tokens:    []Token{{Value: "t"}},
+				RulePath:  "r/",
+				Cron: CronConfig{RuleSet: RuleSetConfig{
+					Categories: []RuleCategory{{Name: "streaming", Sources: []string{"s.txt"}, Policy: "Media"}},
+					Reject:     []string{"reject.txt"},
+				}},
+				RuleProviders: RuleProvidersConfig{Enable: true},
+			},
+			wantErr: false,
+		},
+		{
+			name: "rule providers without categories",
+			cfg: Config{
+				Listen:        ":8080",
+				ProxyPath:     "p.yaml",
+				Tokens:        []Token{{Value: "t"}},
+				RulePath:      "r/",
+				RuleProviders: RuleProvidersConfig{Enable: true, ProxyPolicy: "Proxy"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "duplicate rule category",
+			cfg: Config{
+				Listen:    ":8080",
+				ProxyPath: "p.yaml",
+				Tokens:    []Token{{Value: "t"}},
+				RulePath:  "r/",
+				Cron: CronConfig{RuleSet: RuleSetConfig{
+					Categories: []RuleCategory{{Name: "direct"}},
+					Direct:     []string{"direct.txt"},
+				}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid rule category name",
+			cfg: Config{
+				Listen:    ":8080",
+				ProxyPath: "p.yaml",
+				Tokens:    []Token{{Value: "t"}},
+				RulePath:  "r/",
+				Cron:      CronConfig{RuleSet: RuleSetConfig{Categories: []RuleCategory{{Name: "../ai"}}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "rule providers with unknown file",
+			cfg: Config{
+				Listen:        ":8080",
+				ProxyPath:     "p.yaml",
+				Tokens:        []Token{{Value: "t"}},
+				RulePath:      "r/",
+				Cron:          CronConfig{RuleSet: RuleSetConfig{Direct: []string{"direct.txt"}}},
+				RuleProviders: RuleProvidersConfig{Enable: true, ProxyPolicy: "Proxy", Order: []string{"direct"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "rule providers with unknown format",
+			cfg: Config{
+				Listen:        ":8080",
+				ProxyPath:     "p.yaml",
+				Tokens:        []Token{{Value: "t"}},
+				RulePath:      "r/",
+				RuleProviders: RuleProvidersConfig{Enable: true, ProxyPolicy: "Proxy", Format: "srs"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "metrics without token",
+			cfg: Config{
+				Listen:    ":8080",
+				ProxyPath: "p.yaml",
+				Tokens:    []Token{{Value: "t"}},
+				RulePath:  "r/",
+				Metrics:   MetricsConfig{Enable: true},
+			},
+			wantErr: true,
+		},
+		{
+			name: "iptables quotas sharing the default port",
+			cfg: Config{
+				Listen:    ":8080",
+				ProxyPath: "p.yaml",
+				Tokens: []Token{
+					{Value: "a", Name: "a", Quota: &Quota{Total: 1 << 30}},
+					{Value: "b", Name: "b", Quota: &Quota{Total: 1 << 30}},
+				},
+				RulePath: "r/",
+				Cron: CronConfig{
+					DynamicPort: DynamicPortConfig{TrojanPort: 443},
+					Traffic:     TrafficConfig{Enable: true, Source: "iptables"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "iptables quotas on distinct ports",
+			cfg: Config{
+				Listen:    ":8080",
+				ProxyPath: "p.yaml",
+				Tokens: []Token{
+					{Value: "a", Name: "a", Quota: &Quota{Total: 1 << 30}},
+					{Value: "b", Name: "b", Quota: &Quota{Total: 1 << 30, Port: 8443}},
+				},
+				RulePath: "r/",
+				Cron: CronConfig{
+					DynamicPort: DynamicPortConfig{TrojanPort: 443},
+					Traffic:     TrafficConfig{Enable: true, Source: "iptables"},
+				},
+			},
+			wantErr: false,
+		},
 		{
 			name: "invalid cron ports",
 			cfg: Config{
 				Listen:    ":8080",
 				ProxyPath: "p.yaml",
-				Tokens:    []string{"t"},
+				Tokens:    []Token{{Value: "t"}},
 				RulePath:  "r/",
 				Cron: CronConfig{
 					DynamicPort: DynamicPortConfig{
@@ -100,3 +285,293 @@ func TestConfigValidate(t *testing.T) {
 		})
 	}
 }
+
+func TestConfigLoad_TokenProfiles(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+
+	content := `
+listen: ":8080"
+proxy-path: "proxies.yaml"
+rule-path: "rules/"
+tokens:
+  - "legacy-token"
+  - token: "ci-token"
+    name: "ci"
+    profile: "ci"
+profiles:
+  ci:
+    proxy-path: "ci.yaml"
+    additions: []
+    dynamic-port: false
+additions:
+  - url: "https://example.com/sub"
+    group-name: "HK"
+    timeout: 30
+    proxy: "socks5://127.0.0.1:1080"
+    headers:
+      X-Token: "abc"
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to create temp config: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	if cfg.Tokens[0].Value != "legacy-token" || cfg.Tokens[0].Profile != "" {
+		t.Errorf("legacy token not decoded: %+v", cfg.Tokens[0])
+	}
+	if cfg.Tokens[1].Value != "ci-token" || cfg.Tokens[1].Name != "ci" {
+		t.Errorf("mapping token not decoded: %+v", cfg.Tokens[1])
+	}
+
+	if add := cfg.Additions[0]; add.Timeout != 30 || add.Proxy != "socks5://127.0.0.1:1080" || add.Headers["X-Token"] != "abc" {
+		t.Errorf("addition transport options not decoded: %+v", add.Options)
+	}
+
+	def := cfg.Profile(cfg.Tokens[0].Profile)
+	if def.ProxyPath != "proxies.yaml" || len(cfg.SelectAdditions(def)) != 1 {
+		t.Errorf("default profile should inherit globals: %+v", def)
+	}
+
+	ci := cfg.Profile("ci")
+	if ci.ProxyPath != "ci.yaml" || *ci.DynamicPort {
+		t.Errorf("ci profile not applied: %+v", ci)
+	}
+	if adds := cfg.SelectAdditions(ci); len(adds) != 0 {
+		t.Errorf("empty additions list should select none, got %d", len(adds))
+	}
+}
+
+func TestConfigValidate_Profiles(t *testing.T) {
+	base := func() Config {
+		return Config{
+			Listen:    ":8080",
+			ProxyPath: "p.yaml",
+			RulePath:  "r/",
+			Additions: []Addition{{URL: "u", GroupName: "HK"}},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		mutate  func(c *Config)
+		wantErr bool
+	}{
+		{
+			name: "known profile",
+			mutate: func(c *Config) {
+				c.Tokens = []Token{{Value: "t", Profile: "p"}}
+				c.Profiles = map[string]Profile{"p": {Additions: []string{"HK"}}}
+			},
+		},
+		{
+			name: "unknown profile",
+			mutate: func(c *Config) {
+				c.Tokens = []Token{{Value: "t", Profile: "missing"}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown addition in profile",
+			mutate: func(c *Config) {
+				c.Tokens = []Token{{Value: "t"}}
+				c.Profiles = map[string]Profile{"p": {Additions: []string{"US"}}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid files pattern in profile",
+			mutate: func(c *Config) {
+				c.Tokens = []Token{{Value: "t"}}
+				c.Profiles = map[string]Profile{"p": {Files: []string{"direct-[.yaml"}}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "duplicate token",
+			mutate: func(c *Config) {
+				c.Tokens = []Token{{Value: "t"}, {Value: "t"}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "proxy group defaults",
+			mutate: func(c *Config) {
+				c.Tokens = []Token{{Value: "t"}}
+				c.ProxyGroups = []ProxyGroup{{Name: "HK", Region: "HK"}}
+			},
+		},
+		{
+			name: "proxy group unknown region",
+			mutate: func(c *Config) {
+				c.Tokens = []Token{{Value: "t"}}
+				c.ProxyGroups = []ProxyGroup{{Name: "XX", Region: "XX"}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "proxy group without members",
+			mutate: func(c *Config) {
+				c.Tokens = []Token{{Value: "t"}}
+				c.ProxyGroups = []ProxyGroup{{Name: "Empty", Type: "select"}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "admin without token",
+			mutate: func(c *Config) {
+				c.Tokens = []Token{{Value: "t"}}
+				c.Admin = AdminConfig{Enable: true}
+			},
+			wantErr: true,
+		},
+		{
+			name: "admin token reuses subscription token",
+			mutate: func(c *Config) {
+				c.Tokens = []Token{{Value: "t"}}
+				c.Admin = AdminConfig{Enable: true, Token: "t"}
+			},
+			wantErr: true,
+		},
+		{
+			name: "token allow-ips",
+			mutate: func(c *Config) {
+				c.Tokens = []Token{{Value: "t", AllowIPs: []string{"10.0.0.0/8", "2001:db8::1"}}}
+			},
+		},
+		{
+			name: "token invalid allow-ips",
+			mutate: func(c *Config) {
+				c.Tokens = []Token{{Value: "t", AllowIPs: []string{"10.0.0.300"}}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "cache max-stale below ttl",
+			mutate: func(c *Config) {
+				c.Tokens = []Token{{Value: "t"}}
+				c.Cache = CacheConfig{TTL: 600, MaxStale: 60}
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := base()
+			tt.mutate(&cfg)
+			err := cfg.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    ByteSize
+		wantErr bool
+	}{
+		{"1024", 1024, false},
+		{"100GB", 100 << 30, false},
+		{"1.5T", 3 << 39, false},
+		{"512 MiB", 512 << 20, false},
+		{"abc", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseByteSize(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseByteSize(%q) = %d, %v; want %d, wantErr %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestConfig_HashedTokens(t *testing.T) {
+	for _, algo := range []string{"sha256", "bcrypt", "argon2id"} {
+		t.Run(algo, func(t *testing.T) {
+			hash, err := HashToken("s3cret", algo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg := Config{
+				Listen:    ":8080",
+				ProxyPath: "p.yaml",
+				RulePath:  "r/",
+				Tokens:    []Token{{Value: "plain"}, {Value: hash, Name: "hashed"}},
+			}
+			// Slow hashes are only accepted behind the rate limiter.
+			if err := cfg.Validate(); (err != nil) != isSlowHash(hash) {
+				t.Fatalf("Validate() without rate-limit error = %v", err)
+			}
+			cfg.RateLimit.Enable = true
+			if err := cfg.Validate(); err != nil {
+				t.Fatalf("Validate() error = %v", err)
+			}
+
+			for range 2 { // the second lookup hits the verification cache
+				if tok, ok := cfg.Token("s3cret"); !ok || tok.Name != "hashed" {
+					t.Errorf("Token(raw) = %+v, %v", tok, ok)
+				}
+			}
+			if _, ok := cfg.Token(hash); ok {
+				t.Error("the stored hash must not be accepted as a token")
+			}
+			if _, ok := cfg.Token("wrong"); ok {
+				t.Error("wrong token accepted")
+			}
+			if _, ok := cfg.Token("plain"); !ok {
+				t.Error("plain tokens must keep working")
+			}
+		})
+	}
+
+	bad := Config{Listen: ":8080", ProxyPath: "p.yaml", RulePath: "r/", Tokens: []Token{{Value: "sha256:abcd"}}}
+	if err := bad.Validate(); err == nil {
+		t.Error("expected malformed sha256 hash to be rejected")
+	}
+}
+
+func TestConfigValidate_RuleCategories(t *testing.T) {
+	cfg := Config{
+		Listen:    ":8080",
+		ProxyPath: "p.yaml",
+		RulePath:  "r/",
+		Tokens:    []Token{{Value: "t"}},
+		Cron: CronConfig{RuleSet: RuleSetConfig{
+			Categories: []RuleCategory{{Name: "ai", Sources: []string{"ai.txt"}, Policy: "AI"}},
+			Direct:     []string{"direct.txt"},
+			Reject:     []string{"reject.txt"},
+		}},
+		RuleProviders: RuleProvidersConfig{Enable: true, ProxyPolicy: "Proxy"},
+	}
+	for range 2 { // Validate must be idempotent
+		if err := cfg.Validate(); err != nil {
+			t.Fatalf("Validate() error = %v", err)
+		}
+	}
+
+	rs := cfg.Cron.RuleSet
+	var names []string
+	for _, c := range rs.Categories {
+		names = append(names, c.Name)
+	}
+	if want := []string{"ai", "reject", "direct"}; !slices.Equal(names, want) {
+		t.Errorf("categories = %v, want %v", names, want)
+	}
+	want := []string{
+		"ai-domain", "ai-classic", "reject-domain", "reject-classic", "direct-domain", "direct-classic",
+		"ai-ip", "reject-ip", "direct-ip",
+	}
+	if !slices.Equal(cfg.RuleProviders.Order, want) {
+		t.Errorf("order = %v, want %v", cfg.RuleProviders.Order, want)
+	}
+	if c, kind, ok := rs.RuleFileCategory("reject-ip"); !ok || kind != "ip" || cfg.RuleProviders.Policy(c) != "REJECT" {
+		t.Errorf("RuleFileCategory(reject-ip) = %+v, %q, %v", c, kind, ok)
+	}
+}
@@ -0,0 +1,53 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ByteSize is a number of bytes that may be written with a binary unit
+// suffix in YAML, e.g. 512MB, 100GB or 1.5T.
+type ByteSize int64
+
+var sizeUnits = []struct {
+	suffix string
+	factor float64
+}{
+	{"TB", 1 << 40}, {"T", 1 << 40},
+	{"GB", 1 << 30}, {"G", 1 << 30},
+	{"MB", 1 << 20}, {"M", 1 << 20},
+	{"KB", 1 << 10}, {"K", 1 << 10},
+	{"B", 1},
+}
+
+// ParseByteSize parses a size such as "100GB" into bytes.
+func ParseByteSize(raw string) (ByteSize, error) {
+	s := strings.ToUpper(strings.TrimSpace(raw))
+	s = strings.Replace(s, "IB", "B", 1)
+	factor := 1.0
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, u.suffix))
+			factor = u.factor
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", raw)
+	}
+	return ByteSize(n * factor), nil
+}
+
+// UnmarshalYAML accepts both plain integers and unit-suffixed strings.
+func (b *ByteSize) UnmarshalYAML(node *yaml.Node) error {
+	v, err := ParseByteSize(node.Value)
+	if err != nil {
+		return err
+	}
+	*b = v
+	return nil
+}
@@ -0,0 +1,141 @@
+package config
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Tokens in config.yaml may be stored as hashes instead of plain text:
+//
+//	sha256:<hex>                                  fast, looked up directly
+//	$2a$... / $2b$... / $2y$...                   bcrypt
+//	$argon2id$v=19$m=...,t=...,p=...$salt$hash    argon2id (PHC format)
+//
+// bcrypt and argon2id hashes must be verified one by one; successful
+// verifications are cached for the lifetime of the config.
+
+const sha256Prefix = "sha256:"
+
+// argon2id parameters used by HashToken.
+const (
+	argonMemory  = 19 * 1024
+	argonTime    = 2
+	argonThreads = 1
+	argonKeyLen  = 32
+)
+
+// sha256Token returns the sha256: form of token.
+func sha256Token(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return sha256Prefix + hex.EncodeToString(sum[:])
+}
+
+// isHashed reports whether stored is a hash rather than a plain token.
+func isHashed(stored string) bool {
+	return strings.HasPrefix(stored, sha256Prefix) || isSlowHash(stored)
+}
+
+// isSlowHash reports whether stored is a bcrypt or argon2id hash.
+func isSlowHash(stored string) bool {
+	return strings.HasPrefix(stored, "$2a$") || strings.HasPrefix(stored, "$2b$") ||
+		strings.HasPrefix(stored, "$2y$") || strings.HasPrefix(stored, "$argon2id$")
+}
+
+// HashToken hashes token for storage in config.yaml. algo is sha256, bcrypt
+// or argon2id.
+func HashToken(token, algo string) (string, error) {
+	switch algo {
+	case "sha256":
+		return sha256Token(token), nil
+	case "bcrypt":
+		h, err := bcrypt.GenerateFromPassword([]byte(token), bcrypt.DefaultCost)
+		return string(h), err
+	case "argon2id":
+		salt := make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(token), salt, argonTime, argonMemory, argonThreads, argonKeyLen)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, argonMemory, argonTime, argonThreads,
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+	default:
+		return "", fmt.Errorf("unknown hash algorithm %q", algo)
+	}
+}
+
+// checkTokenHash validates the format of a stored token.
+func checkTokenHash(stored string) error {
+	switch {
+	case strings.HasPrefix(stored, sha256Prefix):
+		if b, err := hex.DecodeString(strings.TrimPrefix(stored, sha256Prefix)); err != nil || len(b) != sha256.Size {
+			return fmt.Errorf("invalid sha256 token hash")
+		}
+	case strings.HasPrefix(stored, "$argon2id$"):
+		if _, err := parseArgon2id(stored); err != nil {
+			return err
+		}
+	case isSlowHash(stored):
+		if _, err := bcrypt.Cost([]byte(stored)); err != nil {
+			return fmt.Errorf("invalid bcrypt token hash: %w", err)
+		}
+	}
+	return nil
+}
+
+// matchToken compares a presented token with a stored plain or hashed one.
+func matchToken(stored, token string) bool {
+	switch {
+	case strings.HasPrefix(stored, sha256Prefix):
+		return subtle.ConstantTimeCompare([]byte(stored), []byte(sha256Token(token))) == 1
+	case strings.HasPrefix(stored, "$argon2id$"):
+		h, err := parseArgon2id(stored)
+		if err != nil {
+			return false
+		}
+		key := argon2.IDKey([]byte(token), h.salt, h.time, h.memory, h.threads, uint32(len(h.key)))
+		return subtle.ConstantTimeCompare(key, h.key) == 1
+	case isSlowHash(stored):
+		return bcrypt.CompareHashAndPassword([]byte(stored), []byte(token)) == nil
+	default:
+		return subtle.ConstantTimeCompare([]byte(stored), []byte(token)) == 1
+	}
+}
+
+type argon2idHash struct {
+	memory    uint32
+	time      uint32
+	threads   uint8
+	salt, key []byte
+}
+
+func parseArgon2id(stored string) (*argon2idHash, error) {
+	// "", "argon2id", "v=19", "m=..,t=..,p=..", salt, key
+	parts := strings.Split(stored, "$")
+	if len(parts) != 6 {
+		return nil, fmt.Errorf("invalid argon2id token hash")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, fmt.Errorf("unsupported argon2id version %q", parts[2])
+	}
+	var h argon2idHash
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.memory, &h.time, &h.threads); err != nil {
+		return nil, fmt.Errorf("invalid argon2id parameters %q", parts[3])
+	}
+	var err error
+	if h.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, fmt.Errorf("invalid argon2id salt")
+	}
+	if h.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(h.key) == 0 {
+		return nil, fmt.Errorf("invalid argon2id key")
+	}
+	return &h, nil
+}
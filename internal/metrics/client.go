@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+const clientNamespace = "smclient"
+
+// Client is the registry served on SMClient's metrics listener.
+var Client = prometheus.NewRegistry()
+
+var (
+	// Syncs counts synchronization runs by result.
+	Syncs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: clientNamespace,
+		Name:      "syncs_total",
+		Help:      "Synchronization runs by result (success or failure).",
+	}, []string{"result"})
+
+	// SyncDuration observes how long a synchronization run takes.
+	SyncDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: clientNamespace,
+		Name:      "sync_duration_seconds",
+		Help:      "Time spent on a synchronization run.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	// LastSuccessfulSync records the time of the last successful sync.
+	LastSuccessfulSync = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: clientNamespace,
+		Name:      "last_successful_sync_timestamp_seconds",
+		Help:      "Unix time of the last successful synchronization.",
+	})
+
+	// MihomoRestarts counts restarts of the mihomo kernel by reason.
+	MihomoRestarts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: clientNamespace,
+		Name:      "mihomo_restarts_total",
+		Help:      "Mihomo kernel restarts by reason (error or exit).",
+	}, []string{"reason"})
+)
+
+func init() {
+	Client.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		Syncs,
+		SyncDuration,
+		LastSuccessfulSync,
+		MihomoRestarts,
+	)
+}
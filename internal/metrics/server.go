@@ -0,0 +1,104 @@
+// Package metrics defines the Prometheus collectors exported by ServerMaster
+// and SMClient. Each binary serves its own registry.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+const namespace = "servermaster"
+
+// Server is the registry served on ServerMaster's /metrics endpoint.
+var Server = prometheus.NewRegistry()
+
+var (
+	// SubRequests counts /sub requests by token name and HTTP status.
+	SubRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "subscription_requests_total",
+		Help:      "Subscription requests by token and HTTP status.",
+	}, []string{"token", "status"})
+
+	// GenerateDuration observes how long GenerateConfig takes.
+	GenerateDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "generate_config_duration_seconds",
+		Help:      "Time spent building a subscription, including addition fetches.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	// AdditionFetches counts addition fetches by group and result.
+	AdditionFetches = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "addition_fetches_total",
+		Help:      "Addition fetches by group and result (success or failure).",
+	}, []string{"group", "result"})
+
+	// AdditionProxies reports the proxy count of the last successful fetch.
+	AdditionProxies = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "addition_proxies",
+		Help:      "Proxies returned by the last successful fetch of each addition.",
+	}, []string{"group"})
+
+	// RulesetDownloadDuration observes rule source downloads by category.
+	RulesetDownloadDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "ruleset_download_duration_seconds",
+		Help:      "Time spent downloading a single rule source.",
+		Buckets:   []float64{0.1, 0.5, 1, 2.5, 5, 10, 30},
+	}, []string{"category"})
+
+	// RulesetRules reports the rule count of each written rule file.
+	RulesetRules = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "ruleset_rules",
+		Help:      "Rules in each generated rule file.",
+	}, []string{"file"})
+
+	// ProxyUp reports whether each proxy answered its last health check.
+	ProxyUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "proxy_up",
+		Help:      "Whether the proxy answered its last health check (1) or not (0).",
+	}, []string{"name"})
+
+	// ProxyLatency reports the connect latency of the last health check.
+	ProxyLatency = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "proxy_latency_seconds",
+		Help:      "Connect (and TLS handshake) latency of the last successful health check.",
+	}, []string{"name"})
+
+	// PortRotations counts dynamic port rotations by result.
+	PortRotations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "port_rotations_total",
+		Help:      "Dynamic port rotations by result (success or failure).",
+	}, []string{"result"})
+)
+
+func init() {
+	Server.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		SubRequests,
+		GenerateDuration,
+		AdditionFetches,
+		AdditionProxies,
+		RulesetDownloadDuration,
+		RulesetRules,
+		PortRotations,
+		ProxyUp,
+		ProxyLatency,
+	)
+}
+
+// Result maps an error to the "result" label value.
+func Result(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
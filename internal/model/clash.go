@@ -1,6 +1,9 @@
 package model
 
-import "maps"
+import (
+	"maps"
+	"slices"
+)
 
 type ClashConfig struct {
 	MixedPort          int                     `yaml:"mixed-port" json:"mixed_port"`
@@ -14,6 +17,8 @@ type ClashConfig struct {
 	ProxyGroups        []ClashProxyGroup       `yaml:"proxy-groups" json:"proxy_groups"`
 	Rules              []string                `yaml:"rules" json:"rules"`
 	RuleProviders      map[string]RuleProvider `yaml:"rule-providers" json:"rule_providers"`
+	// Extra preserves top-level keys not listed above (ipv6, tun, sniffer, ...)
+	Extra Extra `yaml:",inline" json:"extra,omitempty"`
 }
 
 type DNSConfig struct {
@@ -30,6 +35,18 @@ type DNSConfig struct {
 	EnhancedMode string   `yaml:"enhanced-mode" json:"enhanced_mode"`
 	FakeIPRange  string   `yaml:"fake-ip-range" json:"fake_ip_range"`
 	FakeIPFilter []string `yaml:"fake-ip-filter" json:"fake_ip_filter"`
+	Extra        Extra    `yaml:",inline" json:"extra,omitempty"`
+}
+
+func (d *DNSConfig) Clone() DNSConfig {
+	newD := *d
+	newD.DefaultNameserver = slices.Clone(d.DefaultNameserver)
+	newD.Nameserver = slices.Clone(d.Nameserver)
+	newD.Fallback = slices.Clone(d.Fallback)
+	newD.FallbackFilter.IPCidr = slices.Clone(d.FallbackFilter.IPCidr)
+	newD.FakeIPFilter = slices.Clone(d.FakeIPFilter)
+	newD.Extra = d.Extra.Clone()
+	return newD
 }
 
 type RuleProvider struct {
@@ -61,6 +78,17 @@ type ClashProxyGroup struct {
 	Name    string   `yaml:"name" json:"name"`
 	Type    string   `yaml:"type" json:"type"`
 	Proxies []string `yaml:"proxies" json:"proxies"`
+	// Health check options of url-test, fallback and load-balance groups
+	URL       string `yaml:"url,omitempty" json:"url,omitempty"`
+	Interval  int    `yaml:"interval,omitempty" json:"interval,omitempty"`
+	Tolerance int    `yaml:"tolerance,omitempty" json:"tolerance,omitempty"`
+	// Strategy of load-balance groups: consistent-hashing, round-robin or sticky-sessions
+	Strategy string `yaml:"strategy,omitempty" json:"strategy,omitempty"`
+	// Use lists proxy providers whose proxies join the group, narrowed by Filter
+	Use    []string `yaml:"use,omitempty" json:"use,omitempty"`
+	Filter string   `yaml:"filter,omitempty" json:"filter,omitempty"`
+	// Extra preserves group options not listed above (lazy, hidden, icon, ...)
+	Extra Extra `yaml:",inline" json:"extra,omitempty"`
 }
 
 func (g *ClashProxyGroup) Clone() ClashProxyGroup {
@@ -69,6 +97,8 @@ func (g *ClashProxyGroup) Clone() ClashProxyGroup {
 		newG.Proxies = make([]string, len(g.Proxies))
 		copy(newG.Proxies, g.Proxies)
 	}
+	newG.Use = slices.Clone(g.Use)
+	newG.Extra = g.Extra.Clone()
 	return newG
 }
 
@@ -129,6 +159,8 @@ type ClashProxy struct {
 	DialerProxy string `yaml:"dialer-proxy,omitempty" json:"dialer_proxy,omitempty"`
 	// smux 多路复用
 	Smux *SmuxConfig `yaml:"smux,omitempty" json:"smux,omitempty"`
+	// 其他协议相关字段 (uuid, tls, network, ws-opts, reality-opts, ...)
+	Extra Extra `yaml:",inline" json:"extra,omitempty"`
 }
 
 func (p *ClashProxy) Clone() ClashProxy {
@@ -140,12 +172,15 @@ func (p *ClashProxy) Clone() ClashProxy {
 	if p.Smux != nil {
 		newP.Smux = p.Smux.Clone()
 	}
+	newP.Extra = p.Extra.Clone()
 	return newP
 }
 
 func (c *ClashConfig) Clone() *ClashConfig {
 	newCfg := &ClashConfig{}
 	*newCfg = *c
+	newCfg.DNS = c.DNS.Clone()
+	newCfg.Extra = c.Extra.Clone()
 
 	if c.Proxies != nil {
 		newCfg.Proxies = make([]ClashProxy, len(c.Proxies))
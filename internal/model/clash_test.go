@@ -0,0 +1,89 @@
+package model
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestClashConfig_RoundTripUnknownKeys(t *testing.T) {
+	src := `
+mixed-port: 7890
+ipv6: false
+dns:
+  enable: true
+  listen: ":53"
+proxies:
+  - name: "vless"
+    type: vless
+    server: example.com
+    port: 443
+    uuid: "your-uuid"
+    tls: true
+    network: ws
+    ws-opts:
+      path: /ws
+      headers:
+        Host: example.com
+    alpn: [h2, http/1.1]
+proxy-groups:
+  - name: "auto"
+    type: url-test
+    proxies: ["vless"]
+    url: "https://www.gstatic.com/generate_204"
+    interval: 300
+`
+	var cfg ClashConfig
+	if err := yaml.Unmarshal([]byte(src), &cfg); err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+
+	p := cfg.Proxies[0]
+	if p.Extra.String("uuid") != "your-uuid" {
+		t.Errorf("uuid = %q, want your-uuid", p.Extra.String("uuid"))
+	}
+	if !p.Extra.Bool("tls") {
+		t.Error("expected tls to be true")
+	}
+	if got := p.Extra.Map("ws-opts").Map("headers").String("Host"); got != "example.com" {
+		t.Errorf("ws-opts.headers.Host = %q, want example.com", got)
+	}
+	if got := p.Extra.Strings("alpn"); len(got) != 2 || got[0] != "h2" {
+		t.Errorf("alpn = %v, want [h2 http/1.1]", got)
+	}
+	if cfg.ProxyGroups[0].Interval != 300 {
+		t.Errorf("group interval not decoded: %+v", cfg.ProxyGroups[0])
+	}
+
+	// Mutating the clone must not leak into the original.
+	clone := cfg.Clone()
+	clone.Proxies[0].Extra.Map("ws-opts")["path"] = "/changed"
+	if p.Extra.Map("ws-opts").String("path") != "/ws" {
+		t.Error("Clone() did not deep copy nested extra options")
+	}
+
+	out, err := yaml.Marshal(clone)
+	if err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+
+	var back ClashConfig
+	if err := yaml.Unmarshal(out, &back); err != nil {
+		t.Fatalf("re-unmarshal failed: %v", err)
+	}
+	if back.Proxies[0].Extra.String("uuid") != "your-uuid" {
+		t.Errorf("uuid lost after encode: %s", out)
+	}
+	if back.Proxies[0].Extra.Map("ws-opts").String("path") != "/changed" {
+		t.Errorf("ws-opts lost after encode: %s", out)
+	}
+	if _, ok := back.Extra.Get("ipv6"); !ok {
+		t.Errorf("top-level ipv6 lost after encode: %s", out)
+	}
+	if back.DNS.Extra.String("listen") != ":53" {
+		t.Errorf("dns.listen lost after encode: %s", out)
+	}
+	if back.ProxyGroups[0].URL == "" {
+		t.Errorf("group url lost after encode: %s", out)
+	}
+}
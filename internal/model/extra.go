@@ -0,0 +1,137 @@
+package model
+
+import (
+	"maps"
+	"strconv"
+)
+
+// Extra holds the keys of a Clash document that are not modelled by typed
+// struct fields. It is inlined into the owning struct so that unknown options
+// (uuid, ws-opts, reality-opts, ...) survive a decode/encode round-trip.
+//
+// Keys that collide with a typed field of the owning struct must not be stored
+// here; the YAML encoder refuses to emit such documents.
+type Extra map[string]any
+
+// Get returns the raw value stored under key.
+func (e Extra) Get(key string) (any, bool) {
+	v, ok := e[key]
+	return v, ok
+}
+
+// String returns the value under key as a string, or "" if absent.
+// Scalars of other kinds are formatted rather than dropped.
+func (e Extra) String(key string) string {
+	switch v := e[key].(type) {
+	case string:
+		return v
+	case int:
+		return strconv.Itoa(v)
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return ""
+	}
+}
+
+// Int returns the value under key as an int, or 0 if absent or not numeric.
+func (e Extra) Int(key string) int {
+	switch v := e[key].(type) {
+	case int:
+		return v
+	case int64:
+		return int(v)
+	case float64:
+		return int(v)
+	case string:
+		n, _ := strconv.Atoi(v)
+		return n
+	default:
+		return 0
+	}
+}
+
+// Bool returns the value under key as a bool, or false if absent.
+func (e Extra) Bool(key string) bool {
+	switch v := e[key].(type) {
+	case bool:
+		return v
+	case string:
+		b, _ := strconv.ParseBool(v)
+		return b
+	default:
+		return false
+	}
+}
+
+// Map returns the nested mapping under key, or nil if absent.
+func (e Extra) Map(key string) Extra {
+	if v, ok := e[key].(map[string]any); ok {
+		return v
+	}
+	return nil
+}
+
+// Strings returns the sequence under key as a string slice. A single scalar
+// is returned as a one-element slice.
+func (e Extra) Strings(key string) []string {
+	switch v := e[key].(type) {
+	case []any:
+		out := make([]string, 0, len(v))
+		for _, it := range v {
+			if s, ok := it.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	case []string:
+		return append([]string(nil), v...)
+	case string:
+		return []string{v}
+	default:
+		return nil
+	}
+}
+
+// Set stores val under key, allocating the map on first use.
+func (e *Extra) Set(key string, val any) {
+	if *e == nil {
+		*e = make(Extra)
+	}
+	(*e)[key] = val
+}
+
+// Clone returns a deep copy of the map and every nested map or sequence.
+func (e Extra) Clone() Extra {
+	if e == nil {
+		return nil
+	}
+	out := make(Extra, len(e))
+	for k, v := range e {
+		out[k] = cloneValue(v)
+	}
+	return out
+}
+
+func cloneValue(v any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		return map[string]any(Extra(t).Clone())
+	case Extra:
+		return t.Clone()
+	case []any:
+		out := make([]any, len(t))
+		for i := range t {
+			out[i] = cloneValue(t[i])
+		}
+		return out
+	case []string:
+		return append([]string(nil), t...)
+	case map[string]string:
+		return maps.Clone(t)
+	default:
+		return v
+	}
+}
@@ -0,0 +1,47 @@
+package model
+
+import (
+	"fmt"
+)
+
+func parseHysteria2Link(link string) (ClashProxy, error) {
+	u, port, err := parseServerURL(link)
+	if err != nil {
+		return ClashProxy{}, err
+	}
+	if u.User == nil {
+		return ClashProxy{}, fmt.Errorf("missing auth")
+	}
+
+	// Both "auth@" and "user:pass@" forms are in use.
+	auth := u.User.Username()
+	if pass, ok := u.User.Password(); ok {
+		auth += ":" + pass
+	}
+
+	q := u.Query()
+	p := ClashProxy{
+		Name:           linkName(u),
+		Type:           "hysteria2",
+		Server:         u.Hostname(),
+		Port:           port,
+		Password:       auth,
+		UDP:            true,
+		SNI:            q.Get("sni"),
+		SkipCertVerify: isTruthy(q.Get("insecure")),
+	}
+	if obfs := q.Get("obfs"); obfs != "" && obfs != "none" {
+		p.Extra.Set("obfs", obfs)
+		p.Extra.Set("obfs-password", q.Get("obfs-password"))
+	}
+	if mport := q.Get("mport"); mport != "" {
+		p.Extra.Set("ports", mport)
+	}
+	if pin := q.Get("pinSHA256"); pin != "" {
+		p.Extra.Set("fingerprint", pin)
+	}
+	if alpn := splitALPN(q.Get("alpn")); len(alpn) > 0 {
+		p.Extra.Set("alpn", alpn)
+	}
+	return p, nil
+}
@@ -0,0 +1,247 @@
+package model
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"maps"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ParseLink converts a single share link (ss://, trojan://, vless://,
+// vmess://, hysteria2://) into a ClashProxy.
+func ParseLink(link string) (ClashProxy, error) {
+	link = strings.TrimSpace(link)
+	scheme, _, ok := strings.Cut(link, "://")
+	if !ok {
+		return ClashProxy{}, fmt.Errorf("missing scheme in link")
+	}
+
+	switch strings.ToLower(scheme) {
+	case "ss":
+		var s SSLink
+		if err := s.ParseSSLink(link); err != nil {
+			return ClashProxy{}, err
+		}
+		return s.ToClashProxy()
+	case "trojan":
+		return parseTrojanLink(link)
+	case "vless":
+		return parseVlessLink(link)
+	case "vmess":
+		return parseVmessLink(link)
+	case "hysteria2", "hy2":
+		return parseHysteria2Link(link)
+	default:
+		return ClashProxy{}, fmt.Errorf("unsupported scheme %q", scheme)
+	}
+}
+
+// ParseLinks parses a share-link list, either raw or base64 encoded, one link
+// per line. Lines that fail to parse are skipped and reported in the error
+// slice so that one malformed node does not discard the whole subscription.
+func ParseLinks(data []byte) ([]ClashProxy, []error) {
+	text := string(bytes.TrimSpace(data))
+	if !strings.Contains(text, "://") {
+		if decoded, err := decodeBase64(text); err == nil {
+			text = string(decoded)
+		}
+	}
+
+	var proxies []ClashProxy
+	var errs []error
+	for line := range strings.Lines(text) {
+		line = strings.TrimSpace(line)
+		if line == "" || !strings.Contains(line, "://") {
+			continue
+		}
+		p, err := ParseLink(line)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", linkScheme(line), err))
+			continue
+		}
+		proxies = append(proxies, p)
+	}
+	return proxies, errs
+}
+
+// ParseSubscription decodes an upstream subscription body. Clash YAML
+// documents are decoded as-is; anything else is treated as a (possibly
+// base64 encoded) share-link list.
+func ParseSubscription(data []byte) (*ClashConfig, error) {
+	var cfg ClashConfig
+	yamlErr := yaml.Unmarshal(data, &cfg)
+	if yamlErr == nil && len(cfg.Proxies) > 0 {
+		return &cfg, nil
+	}
+
+	proxies, errs := ParseLinks(data)
+	if len(proxies) > 0 {
+		return &ClashConfig{Proxies: proxies}, nil
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("no valid share link found: %w", errs[0])
+	}
+	if yamlErr != nil {
+		return nil, fmt.Errorf("neither clash yaml nor share-link list: %w", yamlErr)
+	}
+	return &cfg, nil
+}
+
+func linkScheme(link string) string {
+	scheme, _, _ := strings.Cut(link, "://")
+	return scheme
+}
+
+// decodeBase64 accepts standard and URL-safe alphabets, with or without padding.
+func decodeBase64(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	s = strings.NewReplacer("\r", "", "\n", "").Replace(s)
+	s = strings.TrimRight(s, "=")
+	if strings.ContainsAny(s, "-_") {
+		return base64.RawURLEncoding.DecodeString(s)
+	}
+	return base64.RawStdEncoding.DecodeString(s)
+}
+
+// parseServerURL parses scheme://userinfo@host:port?query#name links shared by
+// trojan, vless and hysteria2.
+func parseServerURL(link string) (*url.URL, int, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid url: %w", err)
+	}
+	if u.Hostname() == "" {
+		return nil, 0, fmt.Errorf("missing server")
+	}
+	port, err := strconv.ParseUint(u.Port(), 10, 16)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid port number: %w", err)
+	}
+	return u, int(port), nil
+}
+
+func linkName(u *url.URL) string {
+	if u.Fragment != "" {
+		return u.Fragment
+	}
+	return u.Host
+}
+
+func isTruthy(v string) bool {
+	switch strings.ToLower(v) {
+	case "1", "true", "yes":
+		return true
+	}
+	return false
+}
+
+func splitALPN(v string) []any {
+	var out []any
+	for s := range strings.SplitSeq(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// EncodeLink converts a proxy back into its share-link form. It is the
+// inverse of ParseLink for the supported schemes.
+func EncodeLink(p *ClashProxy) (string, error) {
+	host := net.JoinHostPort(p.Server, strconv.Itoa(p.Port))
+	q := url.Values{}
+
+	switch p.Type {
+	case "ss":
+		userInfo := base64.RawURLEncoding.EncodeToString([]byte(p.Cipher + ":" + p.Password))
+		link := "ss://" + userInfo + "@" + host
+		if p.Plugin != "" {
+			plugin := p.Plugin
+			for _, k := range slices.Sorted(maps.Keys(p.PluginOpts)) {
+				plugin += ";" + k + "=" + p.PluginOpts[k]
+			}
+			q.Set("plugin", plugin)
+			link += "/?" + q.Encode()
+		}
+		return link + "#" + url.PathEscape(p.Name), nil
+
+	case "trojan":
+		setTLSQuery(p, q, "allowInsecure")
+		transportQuery(p.Transport(), q)
+		return buildServerURL("trojan", p.Password, host, q, p.Name), nil
+
+	case "vless":
+		uuid := p.Extra.String("uuid")
+		if uuid == "" {
+			return "", fmt.Errorf("vless proxy %q has no uuid", p.Name)
+		}
+		q.Set("encryption", "none")
+		if reality := p.Extra.Map("reality-opts"); reality != nil {
+			q.Set("security", "reality")
+			q.Set("pbk", reality.String("public-key"))
+			if sid := reality.String("short-id"); sid != "" {
+				q.Set("sid", sid)
+			}
+		} else if p.TLSEnabled() {
+			q.Set("security", "tls")
+		}
+		if p.TLSEnabled() {
+			setTLSQuery(p, q, "allowInsecure")
+		}
+		if flow := p.Extra.String("flow"); flow != "" {
+			q.Set("flow", flow)
+		}
+		transportQuery(p.Transport(), q)
+		return buildServerURL("vless", uuid, host, q, p.Name), nil
+
+	case "vmess":
+		return encodeVmessLink(p)
+
+	case "hysteria2":
+		setTLSQuery(p, q, "insecure")
+		if obfs := p.Extra.String("obfs"); obfs != "" {
+			q.Set("obfs", obfs)
+			q.Set("obfs-password", p.Extra.String("obfs-password"))
+		}
+		if ports := p.Extra.String("ports"); ports != "" {
+			q.Set("mport", ports)
+		}
+		return buildServerURL("hysteria2", p.Password, host, q, p.Name), nil
+
+	default:
+		return "", fmt.Errorf("proxy type %q has no share-link form", p.Type)
+	}
+}
+
+func setTLSQuery(p *ClashProxy, q url.Values, insecureKey string) {
+	if sni := p.ServerName(); sni != "" {
+		q.Set("sni", sni)
+	}
+	if p.SkipCertVerify {
+		q.Set(insecureKey, "1")
+	}
+	if alpn := p.Extra.Strings("alpn"); len(alpn) > 0 {
+		q.Set("alpn", strings.Join(alpn, ","))
+	}
+	if fp := p.Extra.String("client-fingerprint"); fp != "" {
+		q.Set("fp", fp)
+	}
+}
+
+func buildServerURL(scheme, user, host string, q url.Values, name string) string {
+	u := url.URL{
+		Scheme:   scheme,
+		User:     url.User(user),
+		Host:     host,
+		RawQuery: q.Encode(),
+		Fragment: name,
+	}
+	return u.String()
+}
@@ -0,0 +1,144 @@
+package model
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestParseLink(t *testing.T) {
+	vmessJSON := `{"v":"2","ps":"vm-node","add":"vm.example.com","port":"443","id":"vm-uuid","aid":0,"net":"ws","path":"/ray","host":"cdn.example.com","tls":"tls"}`
+	vmess := "vmess://" + base64.StdEncoding.EncodeToString([]byte(vmessJSON))
+
+	tests := []struct {
+		name    string
+		link    string
+		check   func(t *testing.T, p ClashProxy)
+		wantErr bool
+	}{
+		{
+			name: "ss sip002",
+			link: "ss://" + base64.RawURLEncoding.EncodeToString([]byte("aes-256-gcm:pass")) + "@1.2.3.4:8388#SS%20Node",
+			check: func(t *testing.T, p ClashProxy) {
+				if p.Type != "ss" || p.Name != "SS Node" || p.Port != 8388 || p.Cipher != "aes-256-gcm" || p.Password != "pass" {
+					t.Errorf("unexpected proxy: %+v", p)
+				}
+			},
+		},
+		{
+			name: "ss legacy full base64",
+			link: "ss://" + base64.StdEncoding.EncodeToString([]byte("chacha20-ietf-poly1305:pw@host.com:443")) + "#legacy",
+			check: func(t *testing.T, p ClashProxy) {
+				if p.Server != "host.com" || p.Port != 443 || p.Cipher != "chacha20-ietf-poly1305" {
+					t.Errorf("unexpected proxy: %+v", p)
+				}
+			},
+		},
+		{
+			name: "trojan ws",
+			link: "trojan://secret@tj.example.com:443?sni=sni.example.com&type=ws&path=%2Fws&host=h.example.com&allowInsecure=1#TJ",
+			check: func(t *testing.T, p ClashProxy) {
+				if p.Type != "trojan" || p.Password != "secret" || p.SNI != "sni.example.com" || !p.SkipCertVerify {
+					t.Errorf("unexpected proxy: %+v", p)
+				}
+				if p.Extra.String("network") != "ws" || p.Extra.Map("ws-opts").String("path") != "/ws" {
+					t.Errorf("unexpected transport: %+v", p.Extra)
+				}
+			},
+		},
+		{
+			name: "vless reality",
+			link: "vless://vl-uuid@vl.example.com:443?security=reality&sni=www.apple.com&pbk=PUBKEY&sid=ab&flow=xtls-rprx-vision&fp=chrome&type=tcp#VL",
+			check: func(t *testing.T, p ClashProxy) {
+				if p.Extra.String("uuid") != "vl-uuid" || !p.Extra.Bool("tls") || p.Extra.String("flow") != "xtls-rprx-vision" {
+					t.Errorf("unexpected proxy: %+v", p)
+				}
+				if p.Extra.Map("reality-opts").String("public-key") != "PUBKEY" {
+					t.Errorf("missing reality-opts: %+v", p.Extra)
+				}
+				if _, ok := p.Extra.Get("network"); ok {
+					t.Errorf("tcp should not set network: %+v", p.Extra)
+				}
+			},
+		},
+		{
+			name: "vmess ws tls",
+			link: vmess,
+			check: func(t *testing.T, p ClashProxy) {
+				if p.Name != "vm-node" || p.Port != 443 || p.Cipher != "auto" || p.Extra.String("uuid") != "vm-uuid" {
+					t.Errorf("unexpected proxy: %+v", p)
+				}
+				if p.Extra.String("servername") != "cdn.example.com" {
+					t.Errorf("servername = %q", p.Extra.String("servername"))
+				}
+			},
+		},
+		{
+			name: "hysteria2",
+			link: "hysteria2://auth@hy.example.com:8443/?sni=hy.example.com&obfs=salamander&obfs-password=ob&insecure=1#HY2",
+			check: func(t *testing.T, p ClashProxy) {
+				if p.Type != "hysteria2" || p.Password != "auth" || !p.SkipCertVerify || p.Extra.String("obfs-password") != "ob" {
+					t.Errorf("unexpected proxy: %+v", p)
+				}
+			},
+		},
+		{name: "unknown scheme", link: "socks://a@b:1", wantErr: true},
+		{name: "bad port", link: "trojan://pw@host:abc", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := ParseLink(tt.link)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseLink() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.check != nil {
+				tt.check(t, p)
+			}
+		})
+	}
+}
+
+func TestParseSubscription(t *testing.T) {
+	links := strings.Join([]string{
+		"trojan://pw@a.example.com:443#A",
+		"not-a-link",
+		"hy2://pw@b.example.com:443#B",
+	}, "\n")
+
+	t.Run("base64 link list", func(t *testing.T) {
+		cfg, err := ParseSubscription([]byte(base64.StdEncoding.EncodeToString([]byte(links))))
+		if err != nil {
+			t.Fatalf("ParseSubscription failed: %v", err)
+		}
+		if len(cfg.Proxies) != 2 || cfg.Proxies[0].Name != "A" || cfg.Proxies[1].Name != "B" {
+			t.Errorf("unexpected proxies: %+v", cfg.Proxies)
+		}
+	})
+
+	t.Run("plain link list", func(t *testing.T) {
+		cfg, err := ParseSubscription([]byte(links))
+		if err != nil {
+			t.Fatalf("ParseSubscription failed: %v", err)
+		}
+		if len(cfg.Proxies) != 2 {
+			t.Errorf("expected 2 proxies, got %d", len(cfg.Proxies))
+		}
+	})
+
+	t.Run("clash yaml", func(t *testing.T) {
+		cfg, err := ParseSubscription([]byte(`proxies: [{name: "y", type: "ss", uuid: "keep"}]`))
+		if err != nil {
+			t.Fatalf("ParseSubscription failed: %v", err)
+		}
+		if len(cfg.Proxies) != 1 || cfg.Proxies[0].Extra.String("uuid") != "keep" {
+			t.Errorf("unexpected proxies: %+v", cfg.Proxies)
+		}
+	})
+
+	t.Run("garbage", func(t *testing.T) {
+		if _, err := ParseSubscription([]byte("%%% not base64 %%%")); err == nil {
+			t.Error("expected error for garbage input")
+		}
+	})
+}
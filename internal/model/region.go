@@ -0,0 +1,73 @@
+package model
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Region is a location recognized in proxy names.
+type Region struct {
+	Code string // ISO 3166-1 alpha-2
+	Name string
+	Flag string
+}
+
+type regionPattern struct {
+	Region
+	re *regexp.Regexp
+}
+
+// asciiToken matches an ASCII keyword that is not part of a longer word, so
+// that "US" matches "US-01" but not "Russia".
+func asciiToken(words ...string) string {
+	return `(?i)(?:^|[^a-z])(?:` + strings.Join(words, "|") + `)(?:[^a-z]|$)`
+}
+
+// regions is ordered so that more specific names are tried first.
+var regions = []regionPattern{
+	{Region{"HK", "香港", "🇭🇰"}, regexp.MustCompile(`香港|港|` + asciiToken("hk", "hong ?kong"))},
+	{Region{"TW", "台湾", "🇹🇼"}, regexp.MustCompile(`台湾|台灣|台|` + asciiToken("tw", "taiwan"))},
+	{Region{"MO", "澳门", "🇲🇴"}, regexp.MustCompile(`澳门|澳門|` + asciiToken("mo", "macao", "macau"))},
+	{Region{"JP", "日本", "🇯🇵"}, regexp.MustCompile(`日本|东京|大阪|` + asciiToken("jp", "japan", "tokyo", "osaka"))},
+	{Region{"KR", "韩国", "🇰🇷"}, regexp.MustCompile(`韩国|韓國|首尔|` + asciiToken("kr", "korea", "seoul"))},
+	{Region{"SG", "新加坡", "🇸🇬"}, regexp.MustCompile(`新加坡|狮城|` + asciiToken("sg", "singapore"))},
+	{Region{"US", "美国", "🇺🇸"}, regexp.MustCompile(`美国|美國|洛杉矶|硅谷|纽约|` + asciiToken("us", "usa", "united states", "america", "los angeles", "san jose", "new york"))},
+	{Region{"CA", "加拿大", "🇨🇦"}, regexp.MustCompile(`加拿大|` + asciiToken("ca", "canada"))},
+	{Region{"GB", "英国", "🇬🇧"}, regexp.MustCompile(`英国|英國|伦敦|` + asciiToken("uk", "gb", "united kingdom", "britain", "london"))},
+	{Region{"DE", "德国", "🇩🇪"}, regexp.MustCompile(`德国|德國|法兰克福|` + asciiToken("de", "germany", "frankfurt"))},
+	{Region{"FR", "法国", "🇫🇷"}, regexp.MustCompile(`法国|法國|巴黎|` + asciiToken("fr", "france", "paris"))},
+	{Region{"NL", "荷兰", "🇳🇱"}, regexp.MustCompile(`荷兰|荷蘭|阿姆斯特丹|` + asciiToken("nl", "netherlands", "amsterdam"))},
+	{Region{"RU", "俄罗斯", "🇷🇺"}, regexp.MustCompile(`俄罗斯|俄羅斯|莫斯科|` + asciiToken("ru", "russia", "moscow"))},
+	{Region{"IN", "印度", "🇮🇳"}, regexp.MustCompile(`印度|` + asciiToken("india", "mumbai"))},
+	{Region{"TR", "土耳其", "🇹🇷"}, regexp.MustCompile(`土耳其|` + asciiToken("tr", "turkey", "istanbul"))},
+	{Region{"AU", "澳大利亚", "🇦🇺"}, regexp.MustCompile(`澳大利亚|澳洲|悉尼|` + asciiToken("au", "australia", "sydney"))},
+}
+
+// DetectRegion returns the first region whose keywords appear in name.
+func DetectRegion(name string) (Region, bool) {
+	for _, r := range regions {
+		if r.re.MatchString(name) {
+			return r.Region, true
+		}
+	}
+	return Region{}, false
+}
+
+// LookupRegion returns the region with the given ISO code.
+func LookupRegion(code string) (Region, bool) {
+	for _, r := range regions {
+		if strings.EqualFold(r.Code, code) {
+			return r.Region, true
+		}
+	}
+	return Region{}, false
+}
+
+// HasFlag reports whether name starts with an emoji flag (a pair of
+// regional indicator symbols).
+func HasFlag(name string) bool {
+	for _, r := range name {
+		return r >= 0x1F1E6 && r <= 0x1F1FF
+	}
+	return false
+}
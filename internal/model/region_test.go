@@ -0,0 +1,31 @@
+package model
+
+import "testing"
+
+func TestDetectRegion(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"香港 01", "HK"},
+		{"HK-IPLC", "HK"},
+		{"🇯🇵 Tokyo 02", "JP"},
+		{"US West", "US"},
+		{"Russia Moscow", "RU"},
+		{"Plus Node", ""},
+		{"剩余流量", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, ok := DetectRegion(tt.name)
+			if ok != (tt.want != "") || r.Code != tt.want {
+				t.Errorf("DetectRegion(%q) = %q, %v; want %q", tt.name, r.Code, ok, tt.want)
+			}
+		})
+	}
+
+	if !HasFlag("🇭🇰 HK") || HasFlag("HK") {
+		t.Error("HasFlag misdetects leading flags")
+	}
+}
@@ -1,8 +1,8 @@
 package model
 
 import (
-	"encoding/base64"
 	"fmt"
+	"maps"
 	"net/url"
 	"strconv"
 	"strings"
@@ -37,11 +37,17 @@ func (s *SSLink) ParseSSLink(ssURL string) error {
 	}
 
 	// Parse plugin params
-	if idx := strings.Index(ssURL, "/?plugin="); idx != -1 {
-		if err := s.parsePluginParams(ssURL[idx+len("/?plugin="):]); err != nil {
-			return fmt.Errorf("plugin params error: %w", err)
+	if idx := strings.Index(ssURL, "?"); idx != -1 {
+		query, err := url.ParseQuery(ssURL[idx+1:])
+		if err != nil {
+			return fmt.Errorf("query params error: %w", err)
+		}
+		if plugin := query.Get("plugin"); plugin != "" {
+			if err := s.parsePluginParams(plugin); err != nil {
+				return fmt.Errorf("plugin params error: %w", err)
+			}
 		}
-		ssURL = ssURL[:idx]
+		ssURL = strings.TrimSuffix(ssURL[:idx], "/")
 	}
 
 	// Parse core params
@@ -56,12 +62,7 @@ func (s *SSLink) ParseSSLink(ssURL string) error {
 	return nil
 }
 
-func (s *SSLink) parsePluginParams(encodedParams string) error {
-	pluginStr, err := url.QueryUnescape(encodedParams)
-	if err != nil {
-		return fmt.Errorf("URL decode failed: %w", err)
-	}
-
+func (s *SSLink) parsePluginParams(pluginStr string) error {
 	parts := strings.Split(pluginStr, ";")
 	if len(parts) < 1 {
 		return fmt.Errorf("empty plugin parameters")
@@ -86,18 +87,26 @@ func (s *SSLink) parsePluginParams(encodedParams string) error {
 func (s *SSLink) parseCoreParams(encoded string) error {
 	parts := strings.SplitN(encoded, "@", 2)
 	if len(parts) == 1 {
-		// Handle full base64 encoding format
-		decoded, err := base64.RawURLEncoding.DecodeString(encoded)
+		// Handle full base64 encoding format: base64(method:password@host:port)
+		decoded, err := decodeBase64(encoded)
 		if err != nil {
 			return fmt.Errorf("base64 decode error: %w", err)
 		}
-		return s.parseHostPort(string(decoded))
+		if !strings.Contains(string(decoded), "@") {
+			return fmt.Errorf("invalid legacy format: %q", decoded)
+		}
+		return s.parseCoreParams(string(decoded))
 	}
 
-	// Handle method:password@host:port format
-	methodPass, err := base64.RawURLEncoding.DecodeString(parts[0])
-	if err != nil {
-		return fmt.Errorf("method-pass decode error: %w", err)
+	// Handle method:password@host:port format. SIP002 base64-encodes the
+	// userinfo, but 2022 ciphers ship it percent-encoded in plain text.
+	methodPass, err := decodeBase64(parts[0])
+	if err != nil || !strings.Contains(string(methodPass), ":") {
+		plain, uerr := url.PathUnescape(parts[0])
+		if uerr != nil {
+			return fmt.Errorf("method-pass decode error: %w", uerr)
+		}
+		methodPass = []byte(plain)
 	}
 
 	mp := strings.SplitN(string(methodPass), ":", 2)
@@ -110,16 +119,44 @@ func (s *SSLink) parseCoreParams(encoded string) error {
 }
 
 func (s *SSLink) parseHostPort(hostPort string) error {
-	hp := strings.SplitN(hostPort, ":", 2)
-	if len(hp) != 2 {
+	hostPort = strings.TrimSuffix(hostPort, "/")
+	idx := strings.LastIndex(hostPort, ":")
+	if idx <= 0 {
 		return fmt.Errorf("invalid host:port format: %q", hostPort)
 	}
+	host, port := hostPort[:idx], hostPort[idx+1:]
 
-	if _, err := strconv.ParseUint(hp[1], 10, 16); err != nil {
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
 		return fmt.Errorf("invalid port number: %w", err)
 	}
 
-	s.Server = hp[0]
-	s.Port = hp[1]
+	s.Server = strings.Trim(host, "[]")
+	s.Port = port
 	return nil
 }
+
+// ToClashProxy converts the parsed link into a Clash proxy entry.
+func (s *SSLink) ToClashProxy() (ClashProxy, error) {
+	port, err := strconv.Atoi(s.Port)
+	if err != nil {
+		return ClashProxy{}, fmt.Errorf("invalid port number: %w", err)
+	}
+	name := s.Name
+	if name == "" {
+		name = s.Server + ":" + s.Port
+	}
+	p := ClashProxy{
+		Name:     name,
+		Type:     s.Type,
+		Server:   s.Server,
+		Port:     port,
+		Cipher:   s.Cipher,
+		Password: s.Password,
+		UDP:      s.UDP,
+		Plugin:   s.Plugin,
+	}
+	if len(s.PluginOpts) > 0 {
+		p.PluginOpts = maps.Clone(s.PluginOpts)
+	}
+	return p, nil
+}
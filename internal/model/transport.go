@@ -0,0 +1,110 @@
+package model
+
+// Transport is the v2ray style transport layer of a proxy, flattened from
+// mihomo's network and *-opts fields.
+type Transport struct {
+	Network     string // tcp, ws, grpc or h2
+	Path        string
+	Host        string
+	ServiceName string
+	HTTPUpgrade bool
+}
+
+// Transport returns the transport settings stored in the proxy's extra options.
+func (p *ClashProxy) Transport() Transport {
+	t := Transport{Network: p.Extra.String("network")}
+	switch t.Network {
+	case "ws":
+		opts := p.Extra.Map("ws-opts")
+		t.Path = opts.String("path")
+		t.Host = opts.Map("headers").String("Host")
+		t.HTTPUpgrade = opts.Bool("v2ray-http-upgrade")
+	case "grpc":
+		t.ServiceName = p.Extra.Map("grpc-opts").String("grpc-service-name")
+	case "h2", "http":
+		opts := p.Extra.Map("h2-opts")
+		t.Path = opts.String("path")
+		if hosts := opts.Strings("host"); len(hosts) > 0 {
+			t.Host = hosts[0]
+		}
+		t.Network = "h2"
+	default:
+		t.Network = "tcp"
+	}
+	return t
+}
+
+// TLSEnabled reports whether the proxy dials with TLS. Trojan and hysteria2
+// always do; vless and vmess opt in through the tls key.
+func (p *ClashProxy) TLSEnabled() bool {
+	switch p.Type {
+	case "trojan", "hysteria2":
+		return true
+	}
+	return p.Extra.Bool("tls")
+}
+
+// ServerName returns the TLS server name, which mihomo stores as sni for
+// trojan/hysteria2 and as servername for vless/vmess.
+func (p *ClashProxy) ServerName() string {
+	if p.SNI != "" {
+		return p.SNI
+	}
+	return p.Extra.String("servername")
+}
+
+// applyTransport maps the v2ray style type/path/host/serviceName query
+// parameters onto mihomo's network and *-opts fields.
+func applyTransport(p *ClashProxy, network, path, host, serviceName string) {
+	switch network {
+	case "", "tcp":
+		return
+	case "ws", "httpupgrade":
+		opts := map[string]any{}
+		if path != "" {
+			opts["path"] = path
+		}
+		if host != "" {
+			opts["headers"] = map[string]any{"Host": host}
+		}
+		if network == "httpupgrade" {
+			opts["v2ray-http-upgrade"] = true
+			network = "ws"
+		}
+		p.Extra.Set("ws-opts", opts)
+	case "grpc":
+		p.Extra.Set("grpc-opts", map[string]any{"grpc-service-name": serviceName})
+	case "h2", "http":
+		opts := map[string]any{}
+		if path != "" {
+			opts["path"] = path
+		}
+		if host != "" {
+			opts["host"] = []any{host}
+		}
+		p.Extra.Set("h2-opts", opts)
+		network = "h2"
+	}
+	p.Extra.Set("network", network)
+}
+
+// transportQuery writes the transport back as v2ray style query parameters.
+func transportQuery(t Transport, q map[string][]string) {
+	if t.Network == "tcp" {
+		return
+	}
+	network := t.Network
+	if t.HTTPUpgrade {
+		network = "httpupgrade"
+	}
+	q["type"] = []string{network}
+	if t.Path != "" {
+		q["path"] = []string{t.Path}
+	}
+	if t.Host != "" {
+		q["host"] = []string{t.Host}
+	}
+	if t.ServiceName != "" {
+		q["serviceName"] = []string{t.ServiceName}
+	}
+}
@@ -0,0 +1,44 @@
+package model
+
+import (
+	"fmt"
+)
+
+func parseTrojanLink(link string) (ClashProxy, error) {
+	u, port, err := parseServerURL(link)
+	if err != nil {
+		return ClashProxy{}, err
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return ClashProxy{}, fmt.Errorf("missing password")
+	}
+
+	q := u.Query()
+	p := ClashProxy{
+		Name:           linkName(u),
+		Type:           "trojan",
+		Server:         u.Hostname(),
+		Port:           port,
+		Password:       u.User.Username(),
+		UDP:            true,
+		SNI:            firstNonEmpty(q.Get("sni"), q.Get("peer")),
+		SkipCertVerify: isTruthy(q.Get("allowInsecure")),
+	}
+	if alpn := splitALPN(q.Get("alpn")); len(alpn) > 0 {
+		p.Extra.Set("alpn", alpn)
+	}
+	if fp := q.Get("fp"); fp != "" {
+		p.Extra.Set("client-fingerprint", fp)
+	}
+	applyTransport(&p, q.Get("type"), q.Get("path"), q.Get("host"), q.Get("serviceName"))
+	return p, nil
+}
+
+func firstNonEmpty(vals ...string) string {
+	for _, v := range vals {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,56 @@
+package model
+
+import (
+	"fmt"
+)
+
+func parseVlessLink(link string) (ClashProxy, error) {
+	u, port, err := parseServerURL(link)
+	if err != nil {
+		return ClashProxy{}, err
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return ClashProxy{}, fmt.Errorf("missing uuid")
+	}
+
+	q := u.Query()
+	p := ClashProxy{
+		Name:           linkName(u),
+		Type:           "vless",
+		Server:         u.Hostname(),
+		Port:           port,
+		UDP:            true,
+		SkipCertVerify: isTruthy(q.Get("allowInsecure")),
+	}
+	p.Extra.Set("uuid", u.User.Username())
+
+	switch security := q.Get("security"); security {
+	case "tls", "reality":
+		p.Extra.Set("tls", true)
+		if sni := q.Get("sni"); sni != "" {
+			p.Extra.Set("servername", sni)
+		}
+		if security == "reality" {
+			opts := map[string]any{"public-key": q.Get("pbk")}
+			if sid := q.Get("sid"); sid != "" {
+				opts["short-id"] = sid
+			}
+			p.Extra.Set("reality-opts", opts)
+		}
+	case "", "none":
+	default:
+		return ClashProxy{}, fmt.Errorf("unsupported security %q", security)
+	}
+
+	if flow := q.Get("flow"); flow != "" {
+		p.Extra.Set("flow", flow)
+	}
+	if fp := q.Get("fp"); fp != "" {
+		p.Extra.Set("client-fingerprint", fp)
+	}
+	if alpn := splitALPN(q.Get("alpn")); len(alpn) > 0 {
+		p.Extra.Set("alpn", alpn)
+	}
+	applyTransport(&p, q.Get("type"), q.Get("path"), q.Get("host"), q.Get("serviceName"))
+	return p, nil
+}
@@ -0,0 +1,135 @@
+package model
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// vmessLink is the JSON payload of a v2rayN style vmess:// link.
+type vmessLink struct {
+	Ps   string          `json:"ps"`
+	Add  string          `json:"add"`
+	Port json.RawMessage `json:"port"`
+	ID   string          `json:"id"`
+	Aid  json.RawMessage `json:"aid"`
+	Scy  string          `json:"scy"`
+	Net  string          `json:"net"`
+	Type string          `json:"type"`
+	Host string          `json:"host"`
+	Path string          `json:"path"`
+	TLS  string          `json:"tls"`
+	SNI  string          `json:"sni"`
+	ALPN string          `json:"alpn"`
+	FP   string          `json:"fp"`
+}
+
+func parseVmessLink(link string) (ClashProxy, error) {
+	const prefix = "vmess://"
+	if !strings.HasPrefix(link, prefix) {
+		return ClashProxy{}, fmt.Errorf("invalid scheme prefix")
+	}
+
+	decoded, err := decodeBase64(strings.TrimPrefix(link, prefix))
+	if err != nil {
+		return ClashProxy{}, fmt.Errorf("base64 decode error: %w", err)
+	}
+
+	var v vmessLink
+	if err := json.Unmarshal(decoded, &v); err != nil {
+		return ClashProxy{}, fmt.Errorf("json decode error: %w", err)
+	}
+	if v.Add == "" || v.ID == "" {
+		return ClashProxy{}, fmt.Errorf("missing server or id")
+	}
+
+	port, err := jsonInt(v.Port)
+	if err != nil || port <= 0 || port > 65535 {
+		return ClashProxy{}, fmt.Errorf("invalid port number: %s", v.Port)
+	}
+	aid, _ := jsonInt(v.Aid)
+
+	cipher := v.Scy
+	if cipher == "" {
+		cipher = "auto"
+	}
+
+	p := ClashProxy{
+		Name:   v.Ps,
+		Type:   "vmess",
+		Server: v.Add,
+		Port:   port,
+		Cipher: cipher,
+		UDP:    true,
+	}
+	if p.Name == "" {
+		p.Name = fmt.Sprintf("%s:%d", v.Add, port)
+	}
+	p.Extra.Set("uuid", v.ID)
+	p.Extra.Set("alterId", aid)
+
+	if v.TLS == "tls" {
+		p.Extra.Set("tls", true)
+		if sni := firstNonEmpty(v.SNI, v.Host); sni != "" {
+			p.Extra.Set("servername", sni)
+		}
+	}
+	if alpn := splitALPN(v.ALPN); len(alpn) > 0 {
+		p.Extra.Set("alpn", alpn)
+	}
+	if v.FP != "" {
+		p.Extra.Set("client-fingerprint", v.FP)
+	}
+	// In vmess links the gRPC service name travels in the path field.
+	applyTransport(&p, v.Net, v.Path, v.Host, v.Path)
+	return p, nil
+}
+
+// jsonInt accepts both 443 and "443", which vmess generators mix freely.
+func jsonInt(raw json.RawMessage) (int, error) {
+	if len(raw) == 0 {
+		return 0, nil
+	}
+	s := strings.Trim(string(raw), `"`)
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(s)
+}
+
+func encodeVmessLink(p *ClashProxy) (string, error) {
+	uuid := p.Extra.String("uuid")
+	if uuid == "" {
+		return "", fmt.Errorf("vmess proxy %q has no uuid", p.Name)
+	}
+
+	t := p.Transport()
+	v := map[string]any{
+		"v":    "2",
+		"ps":   p.Name,
+		"add":  p.Server,
+		"port": strconv.Itoa(p.Port),
+		"id":   uuid,
+		"aid":  strconv.Itoa(p.Extra.Int("alterId")),
+		"scy":  p.Cipher,
+		"net":  t.Network,
+		"type": "none",
+		"host": t.Host,
+		"path": t.Path,
+	}
+	if t.Network == "grpc" {
+		v["path"] = t.ServiceName
+	}
+	if p.TLSEnabled() {
+		v["tls"] = "tls"
+		v["sni"] = p.ServerName()
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return "vmess://" + base64.StdEncoding.EncodeToString(data), nil
+}
@@ -0,0 +1,19 @@
+package render
+
+import (
+	"io"
+	"server-master/internal/model"
+
+	"gopkg.in/yaml.v3"
+)
+
+// clashRenderer emits the configuration unchanged as Clash/mihomo YAML.
+type clashRenderer struct{}
+
+func (clashRenderer) Name() string        { return "clash" }
+func (clashRenderer) ContentType() string { return "application/yaml; charset=utf-8" }
+func (clashRenderer) FileExt() string     { return ".yaml" }
+
+func (clashRenderer) Render(w io.Writer, cfg *model.ClashConfig) ([]string, error) {
+	return nil, yaml.NewEncoder(w).Encode(cfg)
+}
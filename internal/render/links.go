@@ -0,0 +1,33 @@
+package render
+
+import (
+	"encoding/base64"
+	"io"
+	"server-master/internal/model"
+	"strings"
+)
+
+// linksRenderer emits a base64 encoded share-link list, as consumed by
+// Shadowrocket, v2rayN and most generic subscription clients.
+type linksRenderer struct{}
+
+func (linksRenderer) Name() string        { return "links" }
+func (linksRenderer) ContentType() string { return "text/plain; charset=utf-8" }
+func (linksRenderer) FileExt() string     { return ".txt" }
+
+func (linksRenderer) Render(w io.Writer, cfg *model.ClashConfig) ([]string, error) {
+	var warn warnings
+	links := make([]string, 0, len(cfg.Proxies))
+	for i := range cfg.Proxies {
+		link, err := model.EncodeLink(&cfg.Proxies[i])
+		if err != nil {
+			warn.addf("proxy %q skipped: %v", cfg.Proxies[i].Name, err)
+			continue
+		}
+		links = append(links, link)
+	}
+
+	encoded := base64.StdEncoding.EncodeToString([]byte(strings.Join(links, "\n")))
+	_, err := io.WriteString(w, encoded)
+	return warn, err
+}
@@ -0,0 +1,200 @@
+package render
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"server-master/internal/model"
+	"strconv"
+	"strings"
+)
+
+// quanXRenderer emits a Quantumult X profile with [server_local], [policy]
+// and [filter_local] sections.
+type quanXRenderer struct{}
+
+func (quanXRenderer) Name() string        { return "quanx" }
+func (quanXRenderer) ContentType() string { return "text/plain; charset=utf-8" }
+func (quanXRenderer) FileExt() string     { return ".conf" }
+
+func (quanXRenderer) Render(w io.Writer, cfg *model.ClashConfig) ([]string, error) {
+	var warn warnings
+	var body strings.Builder
+	filter := newPolicyFilter("DIRECT", "REJECT")
+
+	body.WriteString("[server_local]\n")
+	for i := range cfg.Proxies {
+		p := &cfg.Proxies[i]
+		line, err := quanXServer(p)
+		if err != nil {
+			warn.addf("proxy %q skipped: %v", p.Name, err)
+			continue
+		}
+		body.WriteString(line + "\n")
+		filter.add(p.Name)
+	}
+
+	body.WriteString("\n[policy]\n")
+	for _, g := range filter.resolveGroups(cfg.ProxyGroups, &warn) {
+		body.WriteString(quanXPolicy(g, &warn) + "\n")
+	}
+
+	body.WriteString("\n[filter_local]\n")
+	for _, line := range cfg.Rules {
+		rule, err := quanXFilter(line, filter)
+		if err != nil {
+			warn.addf("rule %q skipped: %v", line, err)
+			continue
+		}
+		body.WriteString(rule + "\n")
+	}
+
+	return warn, writeWithWarnings(w, "#", warn, body.String())
+}
+
+func quanXServer(p *model.ClashProxy) (string, error) {
+	var typ string
+	fields := []string{}
+	add := func(k, v string) { fields = append(fields, k+"="+v) }
+
+	switch p.Type {
+	case "ss":
+		typ = "shadowsocks"
+		add("method", p.Cipher)
+		add("password", p.Password)
+		if p.Plugin != "" {
+			if p.Plugin != "obfs" {
+				return "", fmt.Errorf("ss plugin %q is not supported by quantumult x", p.Plugin)
+			}
+			add("obfs", p.PluginOpts["mode"])
+			if host := p.PluginOpts["host"]; host != "" {
+				add("obfs-host", host)
+			}
+		}
+	case "trojan":
+		typ = "trojan"
+		add("password", p.Password)
+	case "vmess":
+		typ = "vmess"
+		cipher := p.Cipher
+		if cipher == "" || cipher == "auto" {
+			cipher = "chacha20-ietf-poly1305"
+		}
+		add("method", cipher)
+		add("password", p.Extra.String("uuid"))
+		if p.Extra.Int("alterId") != 0 {
+			add("aead", "false")
+		}
+	case "vless":
+		if p.Extra.Map("reality-opts") != nil || p.Extra.String("flow") != "" {
+			return "", fmt.Errorf("vless reality/flow is not supported by quantumult x")
+		}
+		typ = "vless"
+		add("method", "none")
+		add("password", p.Extra.String("uuid"))
+	default:
+		return "", fmt.Errorf("type %q is not supported by quantumult x", p.Type)
+	}
+
+	tls := p.TLSEnabled()
+	switch t := p.Transport(); t.Network {
+	case "tcp":
+		if tls {
+			add("over-tls", "true")
+		}
+	case "ws":
+		if tls {
+			add("obfs", "wss")
+		} else {
+			add("obfs", "ws")
+		}
+		if t.Host != "" {
+			add("obfs-host", t.Host)
+		}
+		if t.Path != "" {
+			add("obfs-uri", t.Path)
+		}
+	default:
+		return "", fmt.Errorf("transport %q is not supported by quantumult x", t.Network)
+	}
+	if tls {
+		if sni := p.ServerName(); sni != "" {
+			add("tls-host", sni)
+		}
+		add("tls-verification", strconv.FormatBool(!p.SkipCertVerify))
+	}
+	if p.UDP {
+		add("udp-relay", "true")
+	}
+	add("tag", p.Name)
+
+	addr := net.JoinHostPort(p.Server, strconv.Itoa(p.Port))
+	return typ + "=" + addr + ", " + strings.Join(fields, ", "), nil
+}
+
+var quanXPolicyTypes = map[string]string{
+	"select":       "static",
+	"url-test":     "url-latency-benchmark",
+	"fallback":     "available",
+	"load-balance": "round-robin",
+}
+
+func quanXPolicy(g model.ClashProxyGroup, warn *warnings) string {
+	typ, ok := quanXPolicyTypes[g.Type]
+	if !ok {
+		warn.addf("group %q: type %s approximated as static", g.Name, g.Type)
+		typ = "static"
+	}
+
+	fields := []string{g.Name}
+	for _, m := range g.Proxies {
+		fields = append(fields, quanXPolicyName(m))
+	}
+	if typ == "url-latency-benchmark" {
+		if interval := g.Interval; interval > 0 {
+			fields = append(fields, "check-interval="+strconv.Itoa(interval))
+		}
+		if tolerance := g.Tolerance; tolerance > 0 {
+			fields = append(fields, "tolerance="+strconv.Itoa(tolerance))
+		}
+	}
+	return typ + "=" + strings.Join(fields, ", ")
+}
+
+// quanXPolicyName lowercases the built-in policies, which Quantumult X spells
+// direct and reject.
+func quanXPolicyName(name string) string {
+	switch name {
+	case "DIRECT", "REJECT":
+		return strings.ToLower(name)
+	}
+	return name
+}
+
+var quanXFilterTypes = map[string]string{
+	"DOMAIN":         "host",
+	"DOMAIN-SUFFIX":  "host-suffix",
+	"DOMAIN-KEYWORD": "host-keyword",
+	"IP-CIDR":        "ip-cidr",
+	"IP-CIDR6":       "ip6-cidr",
+	"GEOIP":          "geoip",
+}
+
+func quanXFilter(line string, filter *policyFilter) (string, error) {
+	r, ok := parseRule(line)
+	if !ok {
+		return "", fmt.Errorf("malformed rule")
+	}
+	if !filter.has(r.Policy) {
+		return "", fmt.Errorf("unknown policy %q", r.Policy)
+	}
+	policy := quanXPolicyName(r.Policy)
+	if r.Type == "MATCH" {
+		return "final, " + policy, nil
+	}
+	typ, ok := quanXFilterTypes[r.Type]
+	if !ok {
+		return "", fmt.Errorf("%s is not supported by quantumult x", r.Type)
+	}
+	return typ + ", " + r.Value + ", " + policy, nil
+}
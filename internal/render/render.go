@@ -0,0 +1,161 @@
+package render
+
+import (
+	"fmt"
+	"io"
+	"server-master/internal/model"
+	"strings"
+)
+
+// Renderer converts a generated Clash configuration into a client-specific format.
+type Renderer interface {
+	Name() string
+	ContentType() string
+	// FileExt is the extension used for the download filename (e.g. ".yaml").
+	FileExt() string
+	// Render writes the converted configuration to w and returns human-readable
+	// warnings for every proxy, group or rule that could not be represented.
+	Render(w io.Writer, cfg *model.ClashConfig) ([]string, error)
+}
+
+const DefaultTarget = "clash"
+
+var renderers = map[string]Renderer{}
+
+func register(r Renderer, aliases ...string) {
+	renderers[r.Name()] = r
+	for _, alias := range aliases {
+		renderers[alias] = r
+	}
+}
+
+func init() {
+	register(clashRenderer{}, "mihomo", "meta", "stash")
+	register(singBoxRenderer{}, "sing-box", "sfa", "sfi")
+	register(surgeRenderer{})
+	register(quanXRenderer{}, "quantumultx", "quantumult-x", "qx")
+	register(linksRenderer{}, "base64", "v2ray", "shadowrocket")
+}
+
+// Get returns the renderer registered for the given target name.
+func Get(target string) (Renderer, error) {
+	r, ok := renderers[strings.ToLower(target)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported target %q", target)
+	}
+	return r, nil
+}
+
+// uaTargets maps User-Agent fragments to render targets, checked in order.
+var uaTargets = []struct {
+	fragment string
+	target   string
+}{
+	{"sing-box", "singbox"},
+	{"sfa/", "singbox"},
+	{"sfi/", "singbox"},
+	{"sfm/", "singbox"},
+	{"surge", "surge"},
+	{"quantumult", "quanx"},
+	{"shadowrocket", "links"},
+	{"v2rayn", "links"},
+	{"v2rayng", "links"},
+}
+
+// Detect guesses the render target from a client's User-Agent, falling back
+// to Clash YAML which mihomo, Clash Verge and Stash all understand.
+func Detect(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+	for _, it := range uaTargets {
+		if strings.Contains(ua, it.fragment) {
+			return it.target
+		}
+	}
+	return DefaultTarget
+}
+
+// warnings collects conversion problems while rendering.
+type warnings []string
+
+func (w *warnings) addf(format string, args ...any) {
+	*w = append(*w, fmt.Sprintf(format, args...))
+}
+
+// policyFilter tracks which outbound names survived conversion so that groups
+// and rules never reference a dropped proxy.
+type policyFilter struct {
+	known map[string]bool
+}
+
+func newPolicyFilter(builtins ...string) *policyFilter {
+	f := &policyFilter{known: map[string]bool{}}
+	for _, b := range builtins {
+		f.known[b] = true
+	}
+	return f
+}
+
+func (f *policyFilter) add(name string) { f.known[name] = true }
+
+func (f *policyFilter) has(name string) bool { return f.known[name] }
+
+// resolveGroups drops members that do not exist and then drops groups that
+// end up empty, repeating until the set is stable since groups nest.
+func (f *policyFilter) resolveGroups(groups []model.ClashProxyGroup, warn *warnings) []model.ClashProxyGroup {
+	for _, g := range groups {
+		f.add(g.Name)
+		if len(g.Use) > 0 {
+			warn.addf("group %q: proxy providers %v not supported, ignored", g.Name, g.Use)
+		}
+	}
+
+	out := groups
+	for changed := true; changed; {
+		changed = false
+		next := make([]model.ClashProxyGroup, 0, len(out))
+		for _, g := range out {
+			members := make([]string, 0, len(g.Proxies))
+			for _, m := range g.Proxies {
+				if f.has(m) {
+					members = append(members, m)
+				}
+			}
+			if len(members) == 0 {
+				warn.addf("group %q dropped: no usable members", g.Name)
+				delete(f.known, g.Name)
+				changed = true
+				continue
+			}
+			g.Proxies = members
+			next = append(next, g)
+		}
+		out = next
+	}
+	return out
+}
+
+// clashRule is a parsed Clash rule line: TYPE,VALUE,POLICY[,OPTIONS].
+type clashRule struct {
+	Type    string
+	Value   string
+	Policy  string
+	Options []string
+}
+
+func parseRule(line string) (clashRule, bool) {
+	parts := strings.Split(line, ",")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	typ := strings.ToUpper(parts[0])
+	if typ == "MATCH" || typ == "FINAL" {
+		if len(parts) < 2 {
+			return clashRule{}, false
+		}
+		return clashRule{Type: "MATCH", Policy: parts[1]}, true
+	}
+	if len(parts) < 3 {
+		return clashRule{}, false
+	}
+	return clashRule{Type: typ, Value: parts[1], Policy: parts[2], Options: parts[3:]}, true
+}
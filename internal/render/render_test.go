@@ -0,0 +1,203 @@
+package render
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"server-master/internal/model"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+const testConfig = `
+proxies:
+  - {name: "ss-node", type: ss, server: ss.example.com, port: 8388, cipher: aes-256-gcm, password: pw, udp: true}
+  - {name: "trojan-node", type: trojan, server: tj.example.com, port: 443, password: pw, sni: tj.example.com, network: ws, ws-opts: {path: /ws}}
+  - {name: "vless-node", type: vless, server: vl.example.com, port: 443, uuid: id, tls: true, reality-opts: {public-key: pk}}
+  - {name: "tuic-node", type: tuic, server: tu.example.com, port: 443}
+proxy-groups:
+  - {name: "Proxy", type: select, proxies: ["ss-node", "trojan-node", "vless-node", "tuic-node", "DIRECT"]}
+  - {name: "Auto", type: url-test, proxies: ["tuic-node"], url: "https://cp.cloudflare.com", interval: 300}
+rules:
+  - DOMAIN-SUFFIX,google.com,Proxy
+  - DOMAIN-SUFFIX,youtube.com,Proxy
+  - GEOSITE,cn,DIRECT
+  - IP-CIDR,10.0.0.0/8,DIRECT,no-resolve
+  - DOMAIN,ads.example.com,REJECT
+  - DOMAIN,auto.example.com,Auto
+  - MATCH,Proxy
+`
+
+func loadTestConfig(t *testing.T) *model.ClashConfig {
+	t.Helper()
+	var cfg model.ClashConfig
+	if err := yaml.Unmarshal([]byte(testConfig), &cfg); err != nil {
+		t.Fatalf("failed to parse test config: %v", err)
+	}
+	return &cfg
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		ua   string
+		want string
+	}{
+		{"clash-verge/v1.3.8", "clash"},
+		{"mihomo/1.18", "clash"},
+		{"sing-box 1.10.0", "singbox"},
+		{"SFA/1.9.0 (Android)", "singbox"},
+		{"Surge iOS/2920", "surge"},
+		{"Quantumult%20X/1.0.30", "quanx"},
+		{"Shadowrocket/2070", "links"},
+		{"", "clash"},
+	}
+	for _, tt := range tests {
+		if got := Detect(tt.ua); got != tt.want {
+			t.Errorf("Detect(%q) = %q, want %q", tt.ua, got, tt.want)
+		}
+	}
+}
+
+func TestGet(t *testing.T) {
+	if r, err := Get("sing-box"); err != nil || r.Name() != "singbox" {
+		t.Errorf("Get(sing-box) = %v, %v", r, err)
+	}
+	if _, err := Get("unknown"); err == nil {
+		t.Error("expected error for unknown target")
+	}
+	// Loon's proxy syntax differs from Surge's; it has no renderer.
+	if _, err := Get("loon"); err == nil {
+		t.Error("expected error for loon")
+	}
+}
+
+func TestSingBoxRenderer(t *testing.T) {
+	var buf bytes.Buffer
+	warnings, err := singBoxRenderer{}.Render(&buf, loadTestConfig(t))
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+
+	var doc struct {
+		Outbounds []map[string]any `json:"outbounds"`
+		Route     struct {
+			Rules []map[string]any `json:"rules"`
+			Final string           `json:"final"`
+		} `json:"route"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid JSON output: %v", err)
+	}
+
+	tags := map[string]map[string]any{}
+	for _, ob := range doc.Outbounds {
+		tags[ob["tag"].(string)] = ob
+	}
+	if _, ok := tags["tuic-node"]; ok {
+		t.Error("unsupported proxy should be dropped")
+	}
+	if _, ok := tags["Auto"]; ok {
+		t.Error("group with no usable members should be dropped")
+	}
+	if tags["trojan-node"]["transport"].(map[string]any)["path"] != "/ws" {
+		t.Errorf("trojan transport not converted: %v", tags["trojan-node"])
+	}
+	reality := tags["vless-node"]["tls"].(map[string]any)["reality"].(map[string]any)
+	if reality["public_key"] != "pk" {
+		t.Errorf("reality not converted: %v", reality)
+	}
+	if members := tags["Proxy"]["outbounds"].([]any); len(members) != 4 {
+		t.Errorf("Proxy members = %v, want 4 entries", members)
+	}
+
+	if doc.Route.Final != "Proxy" {
+		t.Errorf("final = %q, want Proxy", doc.Route.Final)
+	}
+	if got := doc.Route.Rules[0]["domain_suffix"].([]any); len(got) != 2 {
+		t.Errorf("adjacent rules not merged: %v", doc.Route.Rules[0])
+	}
+	if doc.Route.Rules[2]["action"] != "reject" {
+		t.Errorf("REJECT rule not converted to action: %v", doc.Route.Rules[2])
+	}
+
+	joined := strings.Join(warnings, "\n")
+	for _, want := range []string{"tuic-node", "GEOSITE", "Auto"} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("expected warning mentioning %q, got:\n%s", want, joined)
+		}
+	}
+}
+
+func TestSurgeRenderer(t *testing.T) {
+	var buf bytes.Buffer
+	warnings, err := surgeRenderer{}.Render(&buf, loadTestConfig(t))
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	out := buf.String()
+
+	for _, want := range []string{
+		"ss-node = ss, ss.example.com, 8388, encrypt-method=aes-256-gcm, password=pw, udp-relay=true",
+		"trojan-node = trojan, tj.example.com, 443, password=pw, sni=tj.example.com, ws=true, ws-path=/ws",
+		"Proxy = select, ss-node, trojan-node, DIRECT",
+		"IP-CIDR,10.0.0.0/8,DIRECT,no-resolve",
+		"FINAL,Proxy",
+		"# WARNING: proxy \"vless-node\" skipped",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "\nDOMAIN,auto.example.com") {
+		t.Errorf("rule referencing dropped group should be skipped:\n%s", out)
+	}
+	if len(warnings) == 0 {
+		t.Error("expected warnings")
+	}
+}
+
+func TestQuanXRenderer(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := (quanXRenderer{}).Render(&buf, loadTestConfig(t)); err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	out := buf.String()
+
+	for _, want := range []string{
+		"shadowsocks=ss.example.com:8388, method=aes-256-gcm, password=pw, udp-relay=true, tag=ss-node",
+		"trojan=tj.example.com:443, password=pw, obfs=wss, obfs-uri=/ws, tls-host=tj.example.com, tls-verification=true, tag=trojan-node",
+		"static=Proxy, ss-node, trojan-node, direct",
+		"host-suffix, google.com, Proxy",
+		"host, ads.example.com, reject",
+		"final, Proxy",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestLinksRenderer(t *testing.T) {
+	var buf bytes.Buffer
+	warnings, err := linksRenderer{}.Render(&buf, loadTestConfig(t))
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(buf.String())
+	if err != nil {
+		t.Fatalf("output is not base64: %v", err)
+	}
+	proxies, errs := model.ParseLinks(decoded)
+	if len(errs) > 0 {
+		t.Fatalf("rendered links do not parse: %v", errs)
+	}
+	if len(proxies) != 3 {
+		t.Errorf("expected 3 links, got %d", len(proxies))
+	}
+	if len(warnings) != 1 {
+		t.Errorf("expected 1 warning for tuic, got %v", warnings)
+	}
+}
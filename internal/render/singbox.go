@@ -0,0 +1,271 @@
+package render
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"server-master/internal/model"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// singBoxRenderer emits the outbounds and route sections of a sing-box
+// configuration. Inbounds, DNS and experimental settings are client specific
+// and left to the client's own template.
+type singBoxRenderer struct{}
+
+func (singBoxRenderer) Name() string        { return "singbox" }
+func (singBoxRenderer) ContentType() string { return "application/json; charset=utf-8" }
+func (singBoxRenderer) FileExt() string     { return ".json" }
+
+func (singBoxRenderer) Render(w io.Writer, cfg *model.ClashConfig) ([]string, error) {
+	var warn warnings
+	filter := newPolicyFilter("DIRECT")
+
+	outbounds := make([]map[string]any, 0, len(cfg.Proxies)+len(cfg.ProxyGroups)+1)
+	for i := range cfg.Proxies {
+		p := &cfg.Proxies[i]
+		ob, err := singBoxOutbound(p)
+		if err != nil {
+			warn.addf("proxy %q skipped: %v", p.Name, err)
+			continue
+		}
+		outbounds = append(outbounds, ob)
+		filter.add(p.Name)
+	}
+
+	for _, g := range filter.resolveGroups(cfg.ProxyGroups, &warn) {
+		outbounds = append(outbounds, singBoxGroup(g, &warn))
+	}
+	outbounds = append(outbounds, map[string]any{"type": "direct", "tag": "DIRECT"})
+
+	doc := map[string]any{
+		"outbounds": outbounds,
+		"route":     singBoxRoute(cfg.Rules, filter, &warn),
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return warn, enc.Encode(doc)
+}
+
+func singBoxOutbound(p *model.ClashProxy) (map[string]any, error) {
+	ob := map[string]any{
+		"tag":         p.Name,
+		"server":      p.Server,
+		"server_port": p.Port,
+	}
+
+	switch p.Type {
+	case "ss":
+		ob["type"] = "shadowsocks"
+		ob["method"] = p.Cipher
+		ob["password"] = p.Password
+		if p.Plugin != "" {
+			plugin, opts := singBoxSSPlugin(p)
+			ob["plugin"] = plugin
+			ob["plugin_opts"] = opts
+		}
+	case "trojan":
+		ob["type"] = "trojan"
+		ob["password"] = p.Password
+	case "vless":
+		ob["type"] = "vless"
+		ob["uuid"] = p.Extra.String("uuid")
+		if flow := p.Extra.String("flow"); flow != "" {
+			ob["flow"] = flow
+		}
+		ob["packet_encoding"] = "xudp"
+	case "vmess":
+		ob["type"] = "vmess"
+		ob["uuid"] = p.Extra.String("uuid")
+		ob["security"] = p.Cipher
+		ob["alter_id"] = p.Extra.Int("alterId")
+	case "hysteria2":
+		ob["type"] = "hysteria2"
+		ob["password"] = p.Password
+		if obfs := p.Extra.String("obfs"); obfs != "" {
+			ob["obfs"] = map[string]any{"type": obfs, "password": p.Extra.String("obfs-password")}
+		}
+	default:
+		return nil, fmt.Errorf("type %q is not supported by sing-box output", p.Type)
+	}
+
+	if p.TLSEnabled() {
+		ob["tls"] = singBoxTLS(p)
+	}
+	if transport := singBoxTransport(p.Transport()); transport != nil {
+		ob["transport"] = transport
+	}
+	return ob, nil
+}
+
+func singBoxSSPlugin(p *model.ClashProxy) (string, string) {
+	opts := p.PluginOpts
+	if p.Plugin == "obfs" {
+		// mihomo's obfs plugin is simple-obfs, which sing-box calls obfs-local.
+		s := "obfs=" + opts["mode"]
+		if host := opts["host"]; host != "" {
+			s += ";obfs-host=" + host
+		}
+		return "obfs-local", s
+	}
+	parts := make([]string, 0, len(opts))
+	for _, k := range slices.Sorted(maps.Keys(opts)) {
+		parts = append(parts, k+"="+opts[k])
+	}
+	return p.Plugin, strings.Join(parts, ";")
+}
+
+func singBoxTLS(p *model.ClashProxy) map[string]any {
+	tls := map[string]any{"enabled": true}
+	if sni := p.ServerName(); sni != "" {
+		tls["server_name"] = sni
+	}
+	if p.SkipCertVerify {
+		tls["insecure"] = true
+	}
+	if alpn := p.Extra.Strings("alpn"); len(alpn) > 0 {
+		tls["alpn"] = alpn
+	}
+	if fp := p.Extra.String("client-fingerprint"); fp != "" {
+		tls["utls"] = map[string]any{"enabled": true, "fingerprint": fp}
+	}
+	if reality := p.Extra.Map("reality-opts"); reality != nil {
+		tls["reality"] = map[string]any{
+			"enabled":    true,
+			"public_key": reality.String("public-key"),
+			"short_id":   reality.String("short-id"),
+		}
+	}
+	return tls
+}
+
+func singBoxTransport(t model.Transport) map[string]any {
+	switch t.Network {
+	case "ws":
+		if t.HTTPUpgrade {
+			return map[string]any{"type": "httpupgrade", "path": t.Path, "host": t.Host}
+		}
+		tr := map[string]any{"type": "ws", "path": t.Path}
+		if t.Host != "" {
+			tr["headers"] = map[string]any{"Host": t.Host}
+		}
+		return tr
+	case "grpc":
+		return map[string]any{"type": "grpc", "service_name": t.ServiceName}
+	case "h2":
+		tr := map[string]any{"type": "http", "path": t.Path}
+		if t.Host != "" {
+			tr["host"] = []string{t.Host}
+		}
+		return tr
+	}
+	return nil
+}
+
+func singBoxGroup(g model.ClashProxyGroup, warn *warnings) map[string]any {
+	ob := map[string]any{"tag": g.Name, "outbounds": g.Proxies}
+	switch g.Type {
+	case "select", "":
+		ob["type"] = "selector"
+		return ob
+	case "fallback", "load-balance":
+		warn.addf("group %q: %s approximated as urltest", g.Name, g.Type)
+	case "url-test":
+	default:
+		warn.addf("group %q: type %s approximated as selector", g.Name, g.Type)
+		ob["type"] = "selector"
+		return ob
+	}
+
+	ob["type"] = "urltest"
+	if url := g.URL; url != "" {
+		ob["url"] = url
+	}
+	if interval := g.Interval; interval > 0 {
+		ob["interval"] = strconv.Itoa(interval) + "s"
+	}
+	if tolerance := g.Tolerance; tolerance > 0 {
+		ob["tolerance"] = tolerance
+	}
+	return ob
+}
+
+// singBoxRuleKeys maps Clash rule types onto sing-box route rule fields.
+var singBoxRuleKeys = map[string]string{
+	"DOMAIN":         "domain",
+	"DOMAIN-SUFFIX":  "domain_suffix",
+	"DOMAIN-KEYWORD": "domain_keyword",
+	"DOMAIN-REGEX":   "domain_regex",
+	"IP-CIDR":        "ip_cidr",
+	"IP-CIDR6":       "ip_cidr",
+	"SRC-IP-CIDR":    "source_ip_cidr",
+	"DST-PORT":       "port",
+	"SRC-PORT":       "source_port",
+	"PROCESS-NAME":   "process_name",
+}
+
+func singBoxRoute(lines []string, filter *policyFilter, warn *warnings) map[string]any {
+	route := map[string]any{}
+	var rules []map[string]any
+	var lastKey, lastPolicy string
+
+	for _, line := range lines {
+		r, ok := parseRule(line)
+		if !ok {
+			warn.addf("rule %q skipped: malformed", line)
+			continue
+		}
+		if r.Policy != "REJECT" && !filter.has(r.Policy) {
+			warn.addf("rule %q skipped: unknown policy %q", line, r.Policy)
+			continue
+		}
+		if r.Type == "MATCH" {
+			if r.Policy == "REJECT" {
+				warn.addf("rule %q skipped: sing-box final must be an outbound", line)
+				continue
+			}
+			route["final"] = r.Policy
+			break
+		}
+
+		key, ok := singBoxRuleKeys[r.Type]
+		if !ok {
+			warn.addf("rule %q skipped: %s is not supported by sing-box output", line, r.Type)
+			continue
+		}
+		var value any = r.Value
+		if key == "port" || key == "source_port" {
+			port, err := strconv.Atoi(r.Value)
+			if err != nil {
+				warn.addf("rule %q skipped: invalid port", line)
+				continue
+			}
+			value = port
+		}
+
+		// Merge runs of the same matcher and policy into one rule.
+		if key == lastKey && r.Policy == lastPolicy {
+			last := rules[len(rules)-1]
+			last[key] = append(last[key].([]any), value)
+			continue
+		}
+		rule := map[string]any{key: []any{value}}
+		if r.Policy == "REJECT" {
+			rule["action"] = "reject"
+		} else {
+			rule["outbound"] = r.Policy
+		}
+		rules = append(rules, rule)
+		lastKey, lastPolicy = key, r.Policy
+	}
+
+	if rules != nil {
+		route["rules"] = rules
+	}
+	return route
+}
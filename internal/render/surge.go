@@ -0,0 +1,182 @@
+package render
+
+import (
+	"fmt"
+	"io"
+	"server-master/internal/model"
+	"strconv"
+	"strings"
+)
+
+// surgeRenderer emits a Surge profile with [Proxy], [Proxy Group] and [Rule]
+// sections.
+type surgeRenderer struct{}
+
+func (surgeRenderer) Name() string        { return "surge" }
+func (surgeRenderer) ContentType() string { return "text/plain; charset=utf-8" }
+func (surgeRenderer) FileExt() string     { return ".conf" }
+
+func (surgeRenderer) Render(w io.Writer, cfg *model.ClashConfig) ([]string, error) {
+	var warn warnings
+	var body strings.Builder
+	filter := newPolicyFilter("DIRECT", "REJECT")
+
+	body.WriteString("[Proxy]\n")
+	for i := range cfg.Proxies {
+		p := &cfg.Proxies[i]
+		line, err := surgeProxy(p)
+		if err != nil {
+			warn.addf("proxy %q skipped: %v", p.Name, err)
+			continue
+		}
+		fmt.Fprintf(&body, "%s = %s\n", p.Name, line)
+		filter.add(p.Name)
+	}
+
+	body.WriteString("\n[Proxy Group]\n")
+	for _, g := range filter.resolveGroups(cfg.ProxyGroups, &warn) {
+		fmt.Fprintf(&body, "%s = %s\n", g.Name, surgeGroup(g, &warn))
+	}
+
+	body.WriteString("\n[Rule]\n")
+	for _, line := range cfg.Rules {
+		rule, err := surgeRule(line, filter)
+		if err != nil {
+			warn.addf("rule %q skipped: %v", line, err)
+			continue
+		}
+		body.WriteString(rule + "\n")
+	}
+
+	return warn, writeWithWarnings(w, "#", warn, body.String())
+}
+
+func surgeProxy(p *model.ClashProxy) (string, error) {
+	fields := []string{p.Type, p.Server, strconv.Itoa(p.Port)}
+	add := func(k, v string) { fields = append(fields, k+"="+v) }
+
+	switch p.Type {
+	case "ss":
+		add("encrypt-method", p.Cipher)
+		add("password", p.Password)
+		if p.Plugin != "" {
+			if p.Plugin != "obfs" {
+				return "", fmt.Errorf("ss plugin %q is not supported by surge", p.Plugin)
+			}
+			add("obfs", p.PluginOpts["mode"])
+			if host := p.PluginOpts["host"]; host != "" {
+				add("obfs-host", host)
+			}
+		}
+	case "trojan", "hysteria2":
+		add("password", p.Password)
+	case "vmess":
+		add("username", p.Extra.String("uuid"))
+		if p.Extra.Int("alterId") == 0 {
+			add("vmess-aead", "true")
+		}
+		if p.TLSEnabled() {
+			add("tls", "true")
+		}
+	default:
+		return "", fmt.Errorf("type %q is not supported by surge", p.Type)
+	}
+
+	if p.TLSEnabled() {
+		if sni := p.ServerName(); sni != "" {
+			add("sni", sni)
+		}
+		if p.SkipCertVerify {
+			add("skip-cert-verify", "true")
+		}
+	}
+
+	switch t := p.Transport(); t.Network {
+	case "tcp":
+	case "ws":
+		add("ws", "true")
+		if t.Path != "" {
+			add("ws-path", t.Path)
+		}
+		if t.Host != "" {
+			add("ws-headers", "Host:"+t.Host)
+		}
+	default:
+		return "", fmt.Errorf("transport %q is not supported by surge", t.Network)
+	}
+
+	if p.UDP && p.Type != "hysteria2" {
+		add("udp-relay", "true")
+	}
+	return strings.Join(fields, ", "), nil
+}
+
+func surgeGroup(g model.ClashProxyGroup, warn *warnings) string {
+	typ := g.Type
+	switch typ {
+	case "select", "url-test", "fallback", "load-balance":
+	default:
+		warn.addf("group %q: type %s approximated as select", g.Name, g.Type)
+		typ = "select"
+	}
+
+	fields := append([]string{typ}, g.Proxies...)
+	if typ != "select" {
+		if url := g.URL; url != "" {
+			fields = append(fields, "url="+url)
+		}
+		if interval := g.Interval; interval > 0 {
+			fields = append(fields, "interval="+strconv.Itoa(interval))
+		}
+		if tolerance := g.Tolerance; tolerance > 0 && typ == "url-test" {
+			fields = append(fields, "tolerance="+strconv.Itoa(tolerance))
+		}
+	}
+	return strings.Join(fields, ", ")
+}
+
+// surgeRuleTypes lists the Clash rule types Surge understands, with renames.
+var surgeRuleTypes = map[string]string{
+	"DOMAIN":         "DOMAIN",
+	"DOMAIN-SUFFIX":  "DOMAIN-SUFFIX",
+	"DOMAIN-KEYWORD": "DOMAIN-KEYWORD",
+	"IP-CIDR":        "IP-CIDR",
+	"IP-CIDR6":       "IP-CIDR6",
+	"GEOIP":          "GEOIP",
+	"PROCESS-NAME":   "PROCESS-NAME",
+	"DST-PORT":       "DEST-PORT",
+	"SRC-IP-CIDR":    "SRC-IP",
+}
+
+func surgeRule(line string, filter *policyFilter) (string, error) {
+	r, ok := parseRule(line)
+	if !ok {
+		return "", fmt.Errorf("malformed rule")
+	}
+	if !filter.has(r.Policy) {
+		return "", fmt.Errorf("unknown policy %q", r.Policy)
+	}
+	if r.Type == "MATCH" {
+		return "FINAL," + r.Policy, nil
+	}
+	typ, ok := surgeRuleTypes[r.Type]
+	if !ok {
+		return "", fmt.Errorf("%s is not supported by surge", r.Type)
+	}
+	return strings.Join(append([]string{typ, r.Value, r.Policy}, r.Options...), ","), nil
+}
+
+// writeWithWarnings prefixes body with one comment line per warning so that
+// users inspecting the profile can see what was left out.
+func writeWithWarnings(w io.Writer, comment string, warn warnings, body string) error {
+	var sb strings.Builder
+	for _, msg := range warn {
+		fmt.Fprintf(&sb, "%s WARNING: %s\n", comment, msg)
+	}
+	if sb.Len() > 0 {
+		sb.WriteString("\n")
+	}
+	sb.WriteString(body)
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
@@ -0,0 +1,191 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"server-master/internal/config"
+	"server-master/internal/metrics"
+	"server-master/internal/model"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// depCacheEntry is the cache state of one addition. data is the last good
+// result and is kept when a later refetch fails.
+type depCacheEntry struct {
+	data      *additionResult
+	err       error     // error of the last attempt
+	fetchedAt time.Time // when data was fetched
+	checkedAt time.Time // when the last attempt finished
+}
+
+// additionSnapshot is the unfiltered upstream content of an addition as
+// persisted in the cache directory. Filters are applied when it is loaded so
+// that configuration changes take effect without a refetch.
+type additionSnapshot struct {
+	GroupName string             `yaml:"group-name"`
+	URL       string             `yaml:"url"`
+	FetchedAt time.Time          `yaml:"fetched-at"`
+	UserInfo  string             `yaml:"user-info,omitempty"`
+	Proxies   []model.ClashProxy `yaml:"proxies"`
+}
+
+func depCacheKey(it config.Addition) string {
+	return "dep:" + it.GroupName + "|" + it.URL
+}
+
+func (s *SubscriptionService) cacheTimes() (ttl, maxStale time.Duration) {
+	c := s.cfg.Load().Cache
+	ttl = time.Duration(c.TTL) * time.Second
+	if ttl <= 0 {
+		ttl = 5 * time.Minute
+	}
+	return ttl, time.Duration(c.MaxStale) * time.Second
+}
+
+// usable reports whether the entry holds a result that is not too old to be
+// served. A non-positive maxStale means no limit.
+func (e *depCacheEntry) usable(now time.Time, maxStale time.Duration) bool {
+	return e.data != nil && (maxStale <= 0 || now.Sub(e.fetchedAt) < maxStale)
+}
+
+// lookupAddition returns the cached entry of an addition, falling back to
+// the snapshot on disk after a restart or reload.
+func (s *SubscriptionService) lookupAddition(it config.Addition) (depCacheEntry, bool) {
+	key := depCacheKey(it)
+	if val, ok := s.cache.Get(key); ok {
+		return val.(depCacheEntry), true
+	}
+
+	snap, err := s.loadSnapshot(it)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			slog.Warn("Failed to load addition snapshot", "group", it.GroupName, "error", err)
+		}
+		return depCacheEntry{}, false
+	}
+	data, err := buildAddition(it, snap)
+	if err != nil {
+		slog.Warn("Failed to filter addition snapshot", "group", it.GroupName, "error", err)
+		return depCacheEntry{}, false
+	}
+	entry := depCacheEntry{
+		data:      data,
+		fetchedAt: snap.FetchedAt,
+		checkedAt: snap.FetchedAt,
+	}
+	s.cache.Set(key, entry)
+	slog.Info("Loaded addition snapshot", "group", it.GroupName, "fetched_at", snap.FetchedAt)
+	return entry, true
+}
+
+// refreshAddition fetches an addition and updates its cache entry. On
+// failure the previous result is kept so that it can be served stale.
+func (s *SubscriptionService) refreshAddition(ctx context.Context, it config.Addition) depCacheEntry {
+	snap, err := s.fetchAddition(ctx, it)
+	var data *additionResult
+	if err == nil {
+		data, err = buildAddition(it, snap)
+	}
+	metrics.AdditionFetches.WithLabelValues(it.GroupName, metrics.Result(err)).Inc()
+
+	key := depCacheKey(it)
+	var entry depCacheEntry
+	if val, ok := s.cache.Get(key); ok {
+		entry = val.(depCacheEntry)
+	}
+	entry.err = err
+	entry.checkedAt = time.Now()
+
+	if err != nil {
+		if entry.data != nil {
+			slog.Warn("Failed to refresh addition, keeping last good copy", "group", it.GroupName, "url", it.URL,
+				"age", time.Since(entry.fetchedAt).Round(time.Second), "error", err)
+		} else {
+			slog.Error("Failed to fetch addition", "group", it.GroupName, "url", it.URL, "error", err)
+		}
+	} else {
+		entry.data = data
+		entry.fetchedAt = snap.FetchedAt
+		metrics.AdditionProxies.WithLabelValues(it.GroupName).Set(float64(len(data.Proxies)))
+		if err := s.saveSnapshot(it, snap); err != nil {
+			slog.Warn("Failed to save addition snapshot", "group", it.GroupName, "error", err)
+		}
+	}
+	s.cache.Set(key, entry)
+	return entry
+}
+
+// refreshInBackground refreshes an addition off the request path. Only one
+// refresh per addition runs at a time.
+func (s *SubscriptionService) refreshInBackground(it config.Addition) {
+	key := depCacheKey(it)
+	s.mu.Lock()
+	if s.refreshing[key] {
+		s.mu.Unlock()
+		return
+	}
+	s.refreshing[key] = true
+	s.mu.Unlock()
+
+	go func() {
+		defer func() {
+			s.mu.Lock()
+			delete(s.refreshing, key)
+			s.mu.Unlock()
+		}()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+		s.refreshAddition(ctx, it)
+	}()
+}
+
+func (s *SubscriptionService) snapshotPath(it config.Addition) string {
+	sum := sha256.Sum256([]byte(depCacheKey(it)))
+	return filepath.Join(s.cfg.Load().Cache.Dir, hex.EncodeToString(sum[:8])+".yaml")
+}
+
+func (s *SubscriptionService) loadSnapshot(it config.Addition) (*additionSnapshot, error) {
+	if s.cfg.Load().Cache.Dir == "" {
+		return nil, os.ErrNotExist
+	}
+	data, err := os.ReadFile(s.snapshotPath(it))
+	if err != nil {
+		return nil, err
+	}
+	var snap additionSnapshot
+	if err := yaml.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("failed to decode snapshot: %w", err)
+	}
+	if snap.GroupName != it.GroupName || snap.URL != it.URL {
+		return nil, os.ErrNotExist
+	}
+	return &snap, nil
+}
+
+func (s *SubscriptionService) saveSnapshot(it config.Addition, snap *additionSnapshot) error {
+	if s.cfg.Load().Cache.Dir == "" {
+		return nil
+	}
+	data, err := yaml.Marshal(snap)
+	if err != nil {
+		return err
+	}
+
+	path := s.snapshotPath(it)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"crypto/subtle"
+	"server-master/internal/config"
+	"sync/atomic"
+)
+
+// AdminService authorizes access to the admin API and the metrics endpoint.
+type AdminService struct {
+	cfg atomic.Pointer[config.Config]
+}
+
+func NewAdminService(cfg *config.Config) *AdminService {
+	s := &AdminService{}
+	s.cfg.Store(cfg)
+	return s
+}
+
+// Reload swaps in a new configuration.
+func (s *AdminService) Reload(cfg *config.Config) {
+	s.cfg.Store(cfg)
+}
+
+// Enabled reports whether the admin API is turned on.
+func (s *AdminService) Enabled() bool {
+	return s.cfg.Load().Admin.Enable
+}
+
+// ValidateToken checks the admin token in constant time.
+func (s *AdminService) ValidateToken(token string) bool {
+	c := s.cfg.Load().Admin
+	if !c.Enable || c.Token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(c.Token)) == 1
+}
+
+// MetricsEnabled reports whether the metrics endpoint is turned on.
+func (s *AdminService) MetricsEnabled() bool {
+	return s.cfg.Load().Metrics.Enable
+}
+
+// ValidateMetricsToken checks the metrics token. No request is accepted when
+// no token is configured.
+func (s *AdminService) ValidateMetricsToken(token string) bool {
+	c := s.cfg.Load().Metrics
+	if c.Token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(c.Token)) == 1
+}
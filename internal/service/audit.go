@@ -0,0 +1,155 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"server-master/internal/config"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxTokenIPs bounds the client addresses remembered per token.
+const maxTokenIPs = 10
+
+// TokenFingerprint identifies a token without revealing it: a short prefix
+// of its SHA-256 hash.
+func TokenFingerprint(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "sha256:" + hex.EncodeToString(sum[:6])
+}
+
+// AccessEvent describes one authenticated subscription request.
+type AccessEvent struct {
+	IP        string
+	UserAgent string
+	Target    string
+	Status    int
+}
+
+// TokenActivity is the access history of one token.
+type TokenActivity struct {
+	Fingerprint   string       `json:"fingerprint"`
+	Name          string       `json:"name,omitempty"`
+	Profile       string       `json:"profile,omitempty"`
+	Requests      int64        `json:"requests"`
+	LastSeen      time.Time    `json:"last_seen,omitzero"`
+	LastIP        string       `json:"last_ip,omitempty"`
+	LastUserAgent string       `json:"last_user_agent,omitempty"`
+	LastTarget    string       `json:"last_target,omitempty"`
+	LastStatus    int          `json:"last_status,omitempty"`
+	IPs           []ClientSeen `json:"ips,omitempty"`
+}
+
+// ClientSeen counts the requests of a token from one client address.
+type ClientSeen struct {
+	IP       string    `json:"ip"`
+	Requests int64     `json:"requests"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// AuditService keeps the last-seen state of every subscription token, so
+// that tokens used from unexpected clients stand out. Tokens are stored by
+// fingerprint only.
+type AuditService struct {
+	cfg atomic.Pointer[config.Config]
+
+	mu       sync.Mutex
+	activity map[string]*TokenActivity // by fingerprint
+}
+
+func NewAuditService(cfg *config.Config) *AuditService {
+	s := &AuditService{activity: make(map[string]*TokenActivity)}
+	s.cfg.Store(cfg)
+	return s
+}
+
+// Reload swaps in a new configuration. Activity of removed tokens is kept
+// until restart.
+func (s *AuditService) Reload(cfg *config.Config) {
+	s.cfg.Store(cfg)
+}
+
+// Identity returns the name to log for token: its configured name, or its
+// fingerprint for unnamed and unknown tokens.
+func (s *AuditService) Identity(token string) string {
+	if t, ok := s.cfg.Load().Token(token); ok && t.Name != "" {
+		return t.Name
+	}
+	return s.fingerprint(token)
+}
+
+// fingerprint identifies a presented token. Configured tokens are
+// fingerprinted by their stored value so that hashed tokens match the
+// entries listed by Tokens.
+func (s *AuditService) fingerprint(token string) string {
+	if t, ok := s.cfg.Load().Token(token); ok {
+		return TokenFingerprint(t.Value)
+	}
+	return TokenFingerprint(token)
+}
+
+// Record adds a request to the token's history.
+func (s *AuditService) Record(token string, ev AccessEvent) {
+	fp := s.fingerprint(token)
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.activity[fp]
+	if !ok {
+		a = &TokenActivity{Fingerprint: fp}
+		s.activity[fp] = a
+	}
+	a.Requests++
+	a.LastSeen = now
+	a.LastIP = ev.IP
+	a.LastUserAgent = ev.UserAgent
+	a.LastTarget = ev.Target
+	a.LastStatus = ev.Status
+
+	for i := range a.IPs {
+		if a.IPs[i].IP == ev.IP {
+			a.IPs[i].Requests++
+			a.IPs[i].LastSeen = now
+			return
+		}
+	}
+	if len(a.IPs) >= maxTokenIPs {
+		oldest := 0
+		for i := range a.IPs {
+			if a.IPs[i].LastSeen.Before(a.IPs[oldest].LastSeen) {
+				oldest = i
+			}
+		}
+		a.IPs = append(a.IPs[:oldest], a.IPs[oldest+1:]...)
+	}
+	a.IPs = append(a.IPs, ClientSeen{IP: ev.IP, Requests: 1, LastSeen: now})
+}
+
+// Tokens returns the activity of every configured token, including tokens
+// that were never used, most recently seen first.
+func (s *AuditService) Tokens() []TokenActivity {
+	tokens := s.cfg.Load().Tokens
+
+	s.mu.Lock()
+	out := make([]TokenActivity, 0, len(tokens))
+	for _, t := range tokens {
+		a := TokenActivity{Fingerprint: TokenFingerprint(t.Value)}
+		if prev, ok := s.activity[a.Fingerprint]; ok {
+			a = *prev
+			a.IPs = append([]ClientSeen(nil), prev.IPs...)
+		}
+		a.Name = t.Name
+		a.Profile = t.Profile
+		out = append(out, a)
+	}
+	s.mu.Unlock()
+
+	for i := range out {
+		sort.Slice(out[i].IPs, func(a, b int) bool { return out[i].IPs[a].LastSeen.After(out[i].IPs[b].LastSeen) })
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].LastSeen.After(out[j].LastSeen) })
+	return out
+}
@@ -0,0 +1,54 @@
+package service
+
+import (
+	"fmt"
+	"server-master/internal/config"
+	"strings"
+	"testing"
+)
+
+func TestAuditService(t *testing.T) {
+	cfg := &config.Config{
+		Tokens: []config.Token{
+			{Value: "named-secret", Name: "alice", Profile: "ci"},
+			{Value: "anon-secret"},
+		},
+	}
+	s := NewAuditService(cfg)
+
+	if got := s.Identity("named-secret"); got != "alice" {
+		t.Errorf("Identity(named) = %q, want alice", got)
+	}
+	for _, token := range []string{"anon-secret", "unknown-secret"} {
+		got := s.Identity(token)
+		if !strings.HasPrefix(got, "sha256:") || strings.Contains(got, token) {
+			t.Errorf("Identity(%q) = %q, want fingerprint", token, got)
+		}
+	}
+
+	s.Record("anon-secret", AccessEvent{IP: "10.0.0.1", UserAgent: "mihomo", Target: "clash", Status: 200})
+	s.Record("anon-secret", AccessEvent{IP: "10.0.0.1", UserAgent: "mihomo", Target: "clash", Status: 200})
+	for i := range maxTokenIPs + 2 {
+		s.Record("anon-secret", AccessEvent{IP: fmt.Sprintf("10.0.1.%d", i), Status: 200})
+	}
+
+	tokens := s.Tokens()
+	if len(tokens) != 2 {
+		t.Fatalf("expected all configured tokens, got %d", len(tokens))
+	}
+	anon, alice := tokens[0], tokens[1]
+	if alice.Name != "alice" || alice.Profile != "ci" || alice.Requests != 0 || !alice.LastSeen.IsZero() {
+		t.Errorf("unused token should be listed without activity: %+v", alice)
+	}
+	if anon.Fingerprint != TokenFingerprint("anon-secret") || anon.Requests != maxTokenIPs+4 {
+		t.Errorf("unexpected activity: %+v", anon)
+	}
+	if len(anon.IPs) != maxTokenIPs || anon.LastIP != fmt.Sprintf("10.0.1.%d", maxTokenIPs+1) {
+		t.Errorf("expected %d most recent IPs, got %+v", maxTokenIPs, anon.IPs)
+	}
+	for _, ip := range anon.IPs {
+		if ip.IP == "10.0.0.1" {
+			t.Error("oldest IP should have been evicted")
+		}
+	}
+}
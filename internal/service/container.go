@@ -11,14 +11,46 @@ type Container struct {
 	File         *FileService
 	Port         *PortService
 	Ruleset      *RulesetService
+	Quota        *QuotaService
+	Health       *HealthService
+	Admin        *AdminService
+	Audit        *AuditService
+	Guard        *GuardService
+	Tokens       *TokenStore
+	Cron         *CronService
 }
 
 // NewContainer initializes and returns all business services.
 func NewContainer(cfg *config.Config, queue *utils.Queue[string]) *Container {
+	sub := NewSubscriptionService(cfg, queue)
+	health := NewHealthService(cfg, sub)
+	sub.health = health
+
 	return &Container{
-		Subscription: NewSubscriptionService(cfg, queue),
+		Subscription: sub,
 		File:         NewFileService(cfg),
 		Port:         NewPortService(cfg, queue),
 		Ruleset:      NewRulesetService(cfg),
+		Quota:        NewQuotaService(cfg),
+		Health:       health,
+		Admin:        NewAdminService(cfg),
+		Audit:        NewAuditService(cfg),
+		Guard:        NewGuardService(cfg),
+		Tokens:       NewTokenStore(cfg),
+		Cron:         NewCronService(),
 	}
 }
+
+// Reload atomically swaps a new configuration into every service.
+func (c *Container) Reload(cfg *config.Config) {
+	c.Subscription.Reload(cfg)
+	c.File.Reload(cfg)
+	c.Port.Reload(cfg)
+	c.Ruleset.Reload(cfg)
+	c.Quota.Reload(cfg)
+	c.Health.Reload(cfg)
+	c.Admin.Reload(cfg)
+	c.Audit.Reload(cfg)
+	c.Guard.Reload(cfg)
+	c.Tokens.Reload(cfg)
+}
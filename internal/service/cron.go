@@ -1,8 +1,13 @@
 package service
 
 import (
+	"errors"
+	"fmt"
 	"log/slog"
 	"server-master/pkg/utils"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/robfig/cron/v3"
 )
@@ -24,11 +29,38 @@ type Cleaner interface {
 	Cleanup()
 }
 
+// Failer defines the optional interface for tasks whose runs can fail.
+// If implemented, RunErr is scheduled instead of Run and its error is recorded.
+type Failer interface {
+	RunErr() error
+}
+
+// TaskStatus describes a scheduled task and the outcome of its last run.
+type TaskStatus struct {
+	Name      string        `json:"name"`
+	Spec      string        `json:"spec"`
+	Running   bool          `json:"running"`
+	Runs      int           `json:"runs"`
+	LastRun   time.Time     `json:"last_run,omitzero"`
+	Duration  time.Duration `json:"duration"`
+	LastError string        `json:"last_error,omitempty"`
+	NextRun   time.Time     `json:"next_run,omitzero"`
+}
+
+// ErrTaskNotFound is returned when triggering a task that is not scheduled.
+var ErrTaskNotFound = errors.New("task not found")
+
+// ErrTaskRunning is returned when triggering a task that is already running.
+var ErrTaskRunning = errors.New("task is already running")
+
 // CronService is a generic background task scheduler.
 type CronService struct {
 	cron    *cron.Cron
 	taskIDs *utils.SafeMap[string, cron.EntryID]
 	tasks   *utils.SafeMap[string, Task]
+
+	mu     sync.Mutex
+	status map[string]*TaskStatus
 }
 
 // NewCronService creates a new instance of CronService.
@@ -37,6 +69,7 @@ func NewCronService() *CronService {
 		cron:    cron.New(),
 		taskIDs: utils.NewSafeMap[string, cron.EntryID](),
 		tasks:   utils.NewSafeMap[string, Task](),
+		status:  make(map[string]*TaskStatus),
 	}
 }
 
@@ -49,11 +82,15 @@ func (s *CronService) AddTask(t Task) error {
 		}
 	}
 
-	id, err := s.cron.AddFunc(t.Spec(), t.Run)
+	id, err := s.cron.AddFunc(t.Spec(), func() { _ = s.run(t) })
 	if err != nil {
 		return err
 	}
 
+	s.mu.Lock()
+	s.status[t.Name()] = &TaskStatus{Name: t.Name(), Spec: t.Spec()}
+	s.mu.Unlock()
+
 	s.taskIDs.Set(t.Name(), id)
 	s.tasks.Set(t.Name(), t)
 	slog.Info("Task scheduled", "name", t.Name(), "spec", t.Spec())
@@ -74,10 +111,97 @@ func (s *CronService) RemoveTask(name string) {
 		}
 
 		s.taskIDs.Remove(name)
+		s.mu.Lock()
+		delete(s.status, name)
+		s.mu.Unlock()
 		slog.Info("Task removed", "name", name)
 	}
 }
 
+// run executes t once, skipping the run if the previous one is still going,
+// and records its outcome.
+func (s *CronService) run(t Task) error {
+	name := t.Name()
+	s.mu.Lock()
+	st, ok := s.status[name]
+	if !ok {
+		s.mu.Unlock()
+		return ErrTaskNotFound
+	}
+	if st.Running {
+		s.mu.Unlock()
+		return ErrTaskRunning
+	}
+	st.Running = true
+	s.mu.Unlock()
+
+	start := time.Now()
+	var err error
+	if f, ok := t.(Failer); ok {
+		err = f.RunErr()
+	} else {
+		t.Run()
+	}
+
+	s.mu.Lock()
+	st.Running = false
+	st.Runs++
+	st.LastRun = start
+	st.Duration = time.Since(start)
+	st.LastError = ""
+	if err != nil {
+		st.LastError = err.Error()
+	}
+	s.mu.Unlock()
+
+	if err != nil {
+		slog.Error("Task failed", "name", name, "error", err)
+	}
+	return err
+}
+
+// Trigger runs the named task immediately in the background, outside its
+// schedule. It fails if the task is not scheduled or is already running.
+func (s *CronService) Trigger(name string) error {
+	t, ok := s.tasks.Get(name)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrTaskNotFound, name)
+	}
+	s.mu.Lock()
+	running := s.status[name] != nil && s.status[name].Running
+	s.mu.Unlock()
+	if running {
+		return fmt.Errorf("%w: %s", ErrTaskRunning, name)
+	}
+
+	slog.Info("Task triggered manually", "name", name)
+	go func() { _ = s.run(t) }()
+	return nil
+}
+
+// Tasks returns the status of all scheduled tasks, sorted by name.
+func (s *CronService) Tasks() []TaskStatus {
+	s.mu.Lock()
+	out := make([]TaskStatus, 0, len(s.status))
+	for _, st := range s.status {
+		out = append(out, *st)
+	}
+	s.mu.Unlock()
+
+	for i := range out {
+		if id, ok := s.taskIDs.Get(out[i].Name); ok {
+			out[i].NextRun = s.cron.Entry(id).Next
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// HasTask reports whether a task with the given name is scheduled.
+func (s *CronService) HasTask(name string) bool {
+	return s.taskIDs.Has(name)
+}
+
 // Start begins the cron scheduler.
 func (s *CronService) Start() {
 	s.cron.Start()
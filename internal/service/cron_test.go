@@ -0,0 +1,64 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type fakeTask struct {
+	err  error
+	done chan struct{}
+}
+
+func (t *fakeTask) Name() string { return "Fake" }
+func (t *fakeTask) Spec() string { return "@every 1h" }
+func (t *fakeTask) Run()         {}
+func (t *fakeTask) RunErr() error {
+	defer func() { t.done <- struct{}{} }()
+	return t.err
+}
+
+func TestCronService_TriggerRecordsStatus(t *testing.T) {
+	s := NewCronService()
+	task := &fakeTask{err: errors.New("boom"), done: make(chan struct{}, 1)}
+	if err := s.AddTask(task); err != nil {
+		t.Fatalf("AddTask failed: %v", err)
+	}
+	s.Start()
+	defer s.Stop()
+
+	if err := s.Trigger("Missing"); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("Trigger(Missing) = %v, want ErrTaskNotFound", err)
+	}
+	if err := s.Trigger("Fake"); err != nil {
+		t.Fatalf("Trigger failed: %v", err)
+	}
+
+	select {
+	case <-task.done:
+	case <-time.After(time.Second):
+		t.Fatal("task was not run")
+	}
+
+	// The status is recorded right after RunErr returns.
+	var st TaskStatus
+	for range 100 {
+		st = s.Tasks()[0]
+		if st.Runs == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if st.Runs != 1 || st.LastError != "boom" || st.LastRun.IsZero() {
+		t.Errorf("unexpected status after run: %+v", st)
+	}
+	if st.NextRun.IsZero() {
+		t.Error("expected next run to be scheduled")
+	}
+
+	s.RemoveTask("Fake")
+	if len(s.Tasks()) != 0 {
+		t.Error("expected status to be dropped with the task")
+	}
+}
@@ -1,24 +1,55 @@
 package service
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"net/url"
 	"os"
 	"path/filepath"
 	"server-master/internal/config"
+	"server-master/internal/model"
+	"server-master/pkg/compress"
+	"server-master/pkg/ruleset"
+	"server-master/pkg/utils"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type FileService struct {
-	cfg *config.Config
+	cfg atomic.Pointer[config.Config]
+
+	mu    sync.Mutex
+	etags map[string]fileETag // by path
+}
+
+// fileETag is the entity tag of a file's content at the given version.
+type fileETag struct {
+	mod  time.Time
+	size int64
+	etag string
 }
 
 func NewFileService(cfg *config.Config) *FileService {
-	return &FileService{cfg: cfg}
+	s := &FileService{etags: make(map[string]fileETag)}
+	s.cfg.Store(cfg)
+	return s
+}
+
+// Reload swaps in a new configuration.
+func (s *FileService) Reload(cfg *config.Config) {
+	s.cfg.Store(cfg)
 }
 
 func (s *FileService) GetFilePath(filename string) (string, error) {
 	// Security check: ensure the filename is just a filename and doesn't contain path traversal
 	cleanName := filepath.Base(filename)
-	path := filepath.Join(s.cfg.RulePath, cleanName)
+	path := filepath.Join(s.cfg.Load().RulePath, cleanName)
 
 	// Check if file exists and is a regular file
 	info, err := os.Stat(path)
@@ -35,3 +66,149 @@ func (s *FileService) GetFilePath(filename string) (string, error) {
 
 	return path, nil
 }
+
+// ETag returns a strong entity tag derived from the content of the file at
+// path. It is recomputed only when the file's size or modification time
+// changes.
+func (s *FileService) ETag(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	cached, ok := s.etags[path]
+	s.mu.Unlock()
+	if ok && cached.mod.Equal(info.ModTime()) && cached.size == info.Size() {
+		return cached.etag, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	etag := fmt.Sprintf(`"%x"`, h.Sum(nil)[:16])
+
+	s.mu.Lock()
+	s.etags[path] = fileETag{mod: info.ModTime(), size: info.Size(), etag: etag}
+	s.mu.Unlock()
+	return etag, nil
+}
+
+// Encoded returns the pre-compressed sidecar of path in encoding, if one
+// exists that is not older than path itself.
+func (s *FileService) Encoded(path, encoding string) (string, bool) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", false
+	}
+	sidecar := path + compress.Ext(encoding)
+	sInfo, err := os.Stat(sidecar)
+	if err != nil || !sInfo.Mode().IsRegular() || sInfo.ModTime().Before(info.ModTime()) {
+		return "", false
+	}
+	return sidecar, true
+}
+
+// Visible reports whether token may download the rule file filename.
+func (s *FileService) Visible(token, filename string) bool {
+	cfg := s.cfg.Load()
+	t, ok := cfg.Token(token)
+	return ok && ruleFileVisible(cfg.Profile(t.Profile), filepath.Base(filename))
+}
+
+// VerifySignature checks a /file URL signed by signRuleProviders: the
+// token identified by tokenID must still be valid, allow the client ip and
+// be allowed to download filename. It returns the fingerprint of the
+// token's stored value, which GuardService.AllowTokenID accepts.
+func (s *FileService) VerifySignature(tokenID, filename, sig, ip string) (string, bool) {
+	cfg := s.cfg.Load()
+	filename = filepath.Base(filename)
+	for _, t := range cfg.Tokens {
+		if fileTokenID(t) != tokenID {
+			continue
+		}
+		ok := hmac.Equal([]byte(sig), []byte(fileSignature(t, filename))) &&
+			!t.Expired(time.Now()) && tokenAllowsIP(t, ip) && ruleFileVisible(cfg.Profile(t.Profile), filename)
+		return TokenFingerprint(t.Value), ok
+	}
+	return "", false
+}
+
+// ruleFileVisible reports whether p may download filename. Compiled copies
+// of a rule file are also visible when the YAML file is.
+func ruleFileVisible(p config.Profile, filename string) bool {
+	if p.FileVisible(filename) {
+		return true
+	}
+	for _, format := range ruleset.Formats {
+		if name, ok := strings.CutSuffix(filename, ruleset.Ext(format)); ok {
+			return p.FileVisible(name + ".yaml")
+		}
+	}
+	return false
+}
+
+// fileTokenID identifies a token in signed URLs without revealing it.
+func fileTokenID(t config.Token) string {
+	return strings.TrimPrefix(TokenFingerprint(t.Value), "sha256:")
+}
+
+// fileSignature signs filename for t. The stored token value is the key, so
+// signatures cannot be forged without the config and stop working when the
+// token is rotated or removed.
+func fileSignature(t config.Token, filename string) string {
+	mac := hmac.New(sha256.New, []byte(t.Value))
+	mac.Write([]byte(filename))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// signRuleProviders adds the signature of t to the rule providers served by
+// /file of this server, whose external URL is base, so that clients can
+// download them without the token. Providers the profile may not download
+// are removed together with their rules. Providers on other hosts are left
+// alone: they must never see a signature.
+func signRuleProviders(cfg *model.ClashConfig, base string, t config.Token, p config.Profile) {
+	b, err := url.Parse(base)
+	if base == "" || err != nil {
+		return
+	}
+	prefix := strings.TrimSuffix(b.Path, "/") + "/file/"
+
+	hidden := utils.NewSet[string]()
+	for name, rp := range cfg.RuleProviders {
+		u, err := url.Parse(rp.URL)
+		if err != nil || u.Scheme != b.Scheme || !strings.EqualFold(u.Host, b.Host) {
+			continue
+		}
+		filename, ok := strings.CutPrefix(u.Path, prefix)
+		if !ok || filename == "" || strings.Contains(filename, "/") {
+			continue
+		}
+		if !ruleFileVisible(p, filename) {
+			hidden.Add(name)
+			delete(cfg.RuleProviders, name)
+			continue
+		}
+		q := u.Query()
+		q.Del("token")
+		q.Set("tid", fileTokenID(t))
+		q.Set("sig", fileSignature(t, filename))
+		u.RawQuery = q.Encode()
+		rp.URL = u.String()
+		cfg.RuleProviders[name] = rp
+	}
+	if hidden.Size() == 0 {
+		return
+	}
+	cfg.Rules = slices.DeleteFunc(cfg.Rules, func(rule string) bool {
+		kind, rest, _ := strings.Cut(rule, ",")
+		provider, _, _ := strings.Cut(rest, ",")
+		return strings.EqualFold(strings.TrimSpace(kind), "RULE-SET") && hidden.Has(strings.TrimSpace(provider))
+	})
+}
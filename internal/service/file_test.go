@@ -1,10 +1,15 @@
 package service
 
 import (
+	"net/url"
 	"os"
 	"path/filepath"
 	"server-master/internal/config"
+	"server-master/internal/model"
+	"server-master/pkg/compress"
+	"slices"
 	"testing"
+	"time"
 )
 
 func TestFileService_GetFilePath(t *testing.T) {
@@ -71,3 +76,123 @@ func TestFileService_GetFilePath(t *testing.T) {
 		})
 	}
 }
+
+func TestFileService_ETag(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "direct.yaml")
+	if err := os.WriteFile(path, []byte("payload: [a]"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := NewFileService(&config.Config{})
+
+	first, err := s.ETag(path)
+	if err != nil {
+		t.Fatalf("ETag failed: %v", err)
+	}
+	if again, _ := s.ETag(path); again != first {
+		t.Errorf("ETag of unchanged file = %s, want %s", again, first)
+	}
+
+	if err := os.WriteFile(path, []byte("payload: [a, b]"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if changed, _ := s.ETag(path); changed == first {
+		t.Error("ETag did not change with the content")
+	}
+}
+
+func TestFileService_Encoded(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proxy.yaml")
+	if err := os.WriteFile(path, []byte("payload: [a]"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := NewFileService(&config.Config{})
+
+	if _, ok := s.Encoded(path, compress.Gzip); ok {
+		t.Error("expected no sidecar before one is written")
+	}
+	if err := compress.WriteSidecars(path, []byte("payload: [a]")); err != nil {
+		t.Fatal(err)
+	}
+	if sidecar, ok := s.Encoded(path, compress.Gzip); !ok || sidecar != path+".gz" {
+		t.Errorf("Encoded = %q, %v; want the gzip sidecar", sidecar, ok)
+	}
+
+	// A sidecar older than its file is stale.
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.Encoded(path, compress.Zstd); ok {
+		t.Error("expected a stale sidecar to be ignored")
+	}
+}
+
+func TestFileService_SignedAccess(t *testing.T) {
+	family := config.Token{Value: "t-family", Profile: "family"}
+	cfg := &config.Config{
+		Tokens: []config.Token{
+			{Value: "t-all"},
+			family,
+			{Value: "t-office", AllowIPs: []string{"10.0.0.0/8"}},
+		},
+		Profiles: map[string]config.Profile{"family": {Files: []string{"direct-*"}}},
+	}
+	s := NewFileService(cfg)
+
+	if !s.Visible("t-all", "reject.yaml") || s.Visible("t-family", "reject.yaml") || !s.Visible("t-family", "direct-domain.yaml") {
+		t.Error("Visible does not follow the profiles' files patterns")
+	}
+	domainOnly := config.Profile{Files: []string{"direct-domain.yaml"}}
+	if !ruleFileVisible(domainOnly, "direct-domain.mrs") || !ruleFileVisible(domainOnly, "direct-domain.srs") || ruleFileVisible(domainOnly, "direct-ip.mrs") {
+		t.Error("compiled copies do not follow the visibility of their YAML file")
+	}
+
+	clash := &model.ClashConfig{
+		RuleProviders: map[string]model.RuleProvider{
+			"direct": {URL: "https://sm.example.com/file/direct-domain.yaml?token=t-family"},
+			"reject": {URL: "https://sm.example.com/file/reject.yaml"},
+			"remote": {URL: "https://cdn.example.com/rules/ads.yaml"},
+			"foreign": {URL: "https://cdn.example.com/file/reject.yaml"},
+		},
+		Rules: []string{"RULE-SET,direct,DIRECT", "RULE-SET,reject,REJECT", "RULE-SET,foreign,REJECT", "MATCH,Proxy"},
+	}
+	signRuleProviders(clash, "https://sm.example.com", family, cfg.Profile(family.Profile))
+
+	if _, ok := clash.RuleProviders["reject"]; ok {
+		t.Error("provider of a hidden file was kept")
+	}
+	if want := []string{"RULE-SET,direct,DIRECT", "RULE-SET,foreign,REJECT", "MATCH,Proxy"}; !slices.Equal(clash.Rules, want) {
+		t.Errorf("Rules = %v, want %v", clash.Rules, want)
+	}
+	if got := clash.RuleProviders["remote"].URL; got != "https://cdn.example.com/rules/ads.yaml" {
+		t.Errorf("foreign provider URL rewritten to %s", got)
+	}
+	if got := clash.RuleProviders["foreign"].URL; got != "https://cdn.example.com/file/reject.yaml" {
+		t.Errorf("provider on another host rewritten to %s", got)
+	}
+
+	u, err := url.Parse(clash.RuleProviders["direct"].URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Has("token") {
+		t.Error("signed URL still carries the token")
+	}
+	tid, sig := q.Get("tid"), q.Get("sig")
+	if token, ok := s.VerifySignature(tid, "direct-domain.yaml", sig, "192.0.2.1"); !ok || token != TokenFingerprint(family.Value) {
+		t.Errorf("valid signature: got %q, %v", token, ok)
+	}
+	if _, ok := s.VerifySignature(tid, "direct-ip.yaml", sig, "192.0.2.1"); ok {
+		t.Error("signature accepted for another file")
+	}
+
+	office := cfg.Tokens[2]
+	officeSig := fileSignature(office, "reject.yaml")
+	if _, ok := s.VerifySignature(fileTokenID(office), "reject.yaml", officeSig, "10.1.2.3"); !ok {
+		t.Error("signature rejected from an allowed address")
+	}
+	if _, ok := s.VerifySignature(fileTokenID(office), "reject.yaml", officeSig, "192.0.2.1"); ok {
+		t.Error("signature accepted outside the token's allow-ips")
+	}
+}
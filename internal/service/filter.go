@@ -0,0 +1,119 @@
+package service
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"server-master/internal/config"
+	"server-master/internal/model"
+	"server-master/pkg/utils"
+	"strconv"
+)
+
+// filterProxies applies an addition's include/exclude regexes and rename
+// rules to the fetched proxies. The regexes were checked by config.Validate.
+func filterProxies(proxies []model.ClashProxy, it config.Addition) ([]model.ClashProxy, error) {
+	var include, exclude *regexp.Regexp
+	var err error
+	if it.Include != "" {
+		if include, err = regexp.Compile(it.Include); err != nil {
+			return nil, fmt.Errorf("invalid include regex: %w", err)
+		}
+	}
+	if it.Exclude != "" {
+		if exclude, err = regexp.Compile(it.Exclude); err != nil {
+			return nil, fmt.Errorf("invalid exclude regex: %w", err)
+		}
+	}
+	renames := make([]*regexp.Regexp, len(it.Rename))
+	for i, r := range it.Rename {
+		if renames[i], err = regexp.Compile(r.Match); err != nil {
+			return nil, fmt.Errorf("invalid rename regex: %w", err)
+		}
+	}
+
+	out := make([]model.ClashProxy, 0, len(proxies))
+	for _, p := range proxies {
+		if include != nil && !include.MatchString(p.Name) {
+			continue
+		}
+		if exclude != nil && exclude.MatchString(p.Name) {
+			continue
+		}
+
+		name := p.Name
+		for i, re := range renames {
+			name = re.ReplaceAllString(name, it.Rename[i].Replace)
+		}
+		if it.Flag && !model.HasFlag(name) {
+			if r, ok := model.DetectRegion(name); ok {
+				name = r.Flag + " " + name
+			}
+		}
+		p.Name = it.Prefix + name + it.Suffix
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+// endpoint identifies a proxy server for deduplication.
+func endpoint(p *model.ClashProxy) string {
+	return net.JoinHostPort(p.Server, strconv.Itoa(p.Port))
+}
+
+// mergeAdditions appends the additions' proxies and groups to cfg without
+// modifying the (cached) results. Proxies whose name is already taken are
+// renamed with a numeric suffix, since Clash rejects duplicate names. With
+// dedup, proxies whose server:port is already present are dropped and their
+// group refers to the proxy that was kept instead. Each group lists its
+// proxies by their final names, so that upstream proxies sharing a name
+// stay distinct.
+func mergeAdditions(cfg *model.ClashConfig, results []*additionResult, dedup bool) {
+	names := utils.NewSet[string]()
+	owners := make(map[string]string) // server:port -> proxy name
+	for i := range cfg.Proxies {
+		names.Add(cfg.Proxies[i].Name)
+		if _, ok := owners[endpoint(&cfg.Proxies[i])]; !ok {
+			owners[endpoint(&cfg.Proxies[i])] = cfg.Proxies[i].Name
+		}
+	}
+	for _, g := range cfg.ProxyGroups {
+		names.Add(g.Name)
+	}
+
+	for _, res := range results {
+		// The group holds the addition's proxies in order, under their
+		// final names.
+		group := res.Group.Clone()
+		group.Proxies = make([]string, 0, len(res.Proxies))
+		seen := utils.NewSet[string]()
+		member := func(name string) {
+			if !seen.Has(name) {
+				seen.Add(name)
+				group.Proxies = append(group.Proxies, name)
+			}
+		}
+
+		for _, p := range res.Proxies {
+			ep := endpoint(&p)
+			if owner, ok := owners[ep]; ok && dedup {
+				member(owner)
+				continue
+			}
+			name := p.Name
+			for n := 2; names.Has(name); n++ {
+				name = p.Name + " " + strconv.Itoa(n)
+			}
+			names.Add(name)
+			if _, ok := owners[ep]; !ok {
+				owners[ep] = name
+			}
+			member(name)
+
+			p.Name = name
+			cfg.Proxies = append(cfg.Proxies, p)
+		}
+		names.Add(group.Name)
+		cfg.ProxyGroups = append(cfg.ProxyGroups, group)
+	}
+}
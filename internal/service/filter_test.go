@@ -0,0 +1,131 @@
+package service
+
+import (
+	"reflect"
+	"server-master/internal/config"
+	"server-master/internal/model"
+	"testing"
+)
+
+func names(proxies []model.ClashProxy) []string {
+	out := make([]string, len(proxies))
+	for i, p := range proxies {
+		out[i] = p.Name
+	}
+	return out
+}
+
+func TestFilterProxies(t *testing.T) {
+	proxies := []model.ClashProxy{
+		{Name: "剩余流量：100GB"},
+		{Name: "官网 example.com"},
+		{Name: "[Pro] 香港 01"},
+		{Name: "[Pro] Japan 02"},
+		{Name: "[Pro] 🇸🇬 SG 03"},
+	}
+
+	tests := []struct {
+		name string
+		it   config.Addition
+		want []string
+	}{
+		{
+			name: "no rules",
+			it:   config.Addition{},
+			want: names(proxies),
+		},
+		{
+			name: "exclude info nodes",
+			it:   config.Addition{Exclude: "剩余|官网"},
+			want: []string{"[Pro] 香港 01", "[Pro] Japan 02", "[Pro] 🇸🇬 SG 03"},
+		},
+		{
+			name: "include and rename",
+			it: config.Addition{
+				Include: "香港|Japan",
+				Rename:  []config.RenameRule{{Match: `^\[Pro\]\s*`}, {Match: `(\d+)$`, Replace: "#$1"}},
+			},
+			want: []string{"香港 #01", "Japan #02"},
+		},
+		{
+			name: "flag, prefix and suffix",
+			it: config.Addition{
+				Exclude: "剩余|官网",
+				Rename:  []config.RenameRule{{Match: `^\[Pro\]\s*`}},
+				Flag:    true,
+				Prefix:  "A|",
+				Suffix:  "|x",
+			},
+			want: []string{"A|🇭🇰 香港 01|x", "A|🇯🇵 Japan 02|x", "A|🇸🇬 SG 03|x"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := filterProxies(proxies, tt.it)
+			if err != nil {
+				t.Fatalf("filterProxies failed: %v", err)
+			}
+			if !reflect.DeepEqual(names(got), tt.want) {
+				t.Errorf("got %v, want %v", names(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeAdditions(t *testing.T) {
+	base := func() *model.ClashConfig {
+		return &model.ClashConfig{
+			Proxies:     []model.ClashProxy{{Name: "HK", Server: "a.example.com", Port: 443}},
+			ProxyGroups: []model.ClashProxyGroup{{Name: "Proxy", Proxies: []string{"HK"}}},
+		}
+	}
+	res := &additionResult{
+		Proxies: []model.ClashProxy{
+			{Name: "HK", Server: "b.example.com", Port: 443},
+			{Name: "Same", Server: "a.example.com", Port: 443},
+			{Name: "Proxy", Server: "c.example.com", Port: 443},
+		},
+		Group: model.ClashProxyGroup{Name: "Remote", Proxies: []string{"HK", "Same", "Proxy"}},
+	}
+
+	cfg := base()
+	mergeAdditions(cfg, []*additionResult{res}, false)
+	if want := []string{"HK", "HK 2", "Same", "Proxy 2"}; !reflect.DeepEqual(names(cfg.Proxies), want) {
+		t.Errorf("proxies = %v, want %v", names(cfg.Proxies), want)
+	}
+	if want := []string{"HK 2", "Same", "Proxy 2"}; !reflect.DeepEqual(cfg.ProxyGroups[1].Proxies, want) {
+		t.Errorf("group = %v, want %v", cfg.ProxyGroups[1].Proxies, want)
+	}
+
+	cfg = base()
+	mergeAdditions(cfg, []*additionResult{res}, true)
+	if want := []string{"HK", "HK 2", "Proxy 2"}; !reflect.DeepEqual(names(cfg.Proxies), want) {
+		t.Errorf("dedup proxies = %v, want %v", names(cfg.Proxies), want)
+	}
+	if want := []string{"HK 2", "HK", "Proxy 2"}; !reflect.DeepEqual(cfg.ProxyGroups[1].Proxies, want) {
+		t.Errorf("dedup group = %v, want %v", cfg.ProxyGroups[1].Proxies, want)
+	}
+
+	if res.Proxies[0].Name != "HK" || res.Group.Proxies[0] != "HK" {
+		t.Error("mergeAdditions must not modify the cached result")
+	}
+
+	// Upstream proxies sharing a name are renamed and all stay in the group.
+	dup := &additionResult{
+		Proxies: []model.ClashProxy{
+			{Name: "JP", Server: "d.example.com", Port: 443},
+			{Name: "JP", Server: "e.example.com", Port: 443},
+			{Name: "JP", Server: "f.example.com", Port: 443},
+		},
+		Group: model.ClashProxyGroup{Name: "Remote", Proxies: []string{"JP", "JP", "JP"}},
+	}
+	cfg = base()
+	mergeAdditions(cfg, []*additionResult{dup}, false)
+	if want := []string{"HK", "JP", "JP 2", "JP 3"}; !reflect.DeepEqual(names(cfg.Proxies), want) {
+		t.Errorf("duplicate names: proxies = %v, want %v", names(cfg.Proxies), want)
+	}
+	if want := []string{"JP", "JP 2", "JP 3"}; !reflect.DeepEqual(cfg.ProxyGroups[1].Proxies, want) {
+		t.Errorf("duplicate names: group = %v, want %v", cfg.ProxyGroups[1].Proxies, want)
+	}
+}
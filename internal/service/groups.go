@@ -0,0 +1,105 @@
+package service
+
+import (
+	"log/slog"
+	"regexp"
+	"server-master/internal/config"
+	"server-master/internal/model"
+	"server-master/pkg/utils"
+	"strings"
+)
+
+// generateGroups appends the configured proxy groups to cfg, matching the
+// proxies already merged from the base file and the additions. Groups that
+// end up empty are dropped along with references to them, since Clash
+// refuses empty groups.
+func generateGroups(cfg *model.ClashConfig, gens []config.ProxyGroup) {
+	if len(gens) == 0 {
+		return
+	}
+
+	taken := utils.NewSet[string]()
+	for _, p := range cfg.Proxies {
+		taken.Add(p.Name)
+	}
+	for _, g := range cfg.ProxyGroups {
+		taken.Add(g.Name)
+	}
+
+	generated := utils.NewSet[string]()
+	for _, gen := range gens {
+		if taken.Has(gen.Name) {
+			slog.Warn("Generated proxy group skipped: name already in use", "group", gen.Name)
+			continue
+		}
+		taken.Add(gen.Name)
+
+		group := model.ClashProxyGroup{
+			Name:      gen.Name,
+			Type:      gen.Type,
+			Proxies:   append([]string(nil), gen.Proxies...),
+			URL:       gen.URL,
+			Interval:  gen.Interval,
+			Tolerance: gen.Tolerance,
+			Strategy:  gen.Strategy,
+		}
+		if gen.Filter != "" || gen.Region != "" {
+			matched, err := matchProxies(cfg.Proxies, gen)
+			if err != nil {
+				slog.Warn("Generated proxy group skipped", "group", gen.Name, "error", err)
+				continue
+			}
+			group.Proxies = append(group.Proxies, matched...)
+		}
+		generated.Add(gen.Name)
+		cfg.ProxyGroups = append(cfg.ProxyGroups, group)
+	}
+
+	// Removing a group may empty a group that chains it, so repeat.
+	for {
+		empty := utils.NewSet[string]()
+		for _, g := range cfg.ProxyGroups {
+			if generated.Has(g.Name) && len(g.Proxies) == 0 {
+				empty.Add(g.Name)
+			}
+		}
+		if empty.Size() == 0 {
+			return
+		}
+		slog.Debug("Dropping empty generated proxy groups", "groups", empty.ToSlice())
+		removeGroups(cfg, empty.Has)
+	}
+}
+
+// matchProxies returns the names of the proxies selected by gen.
+func matchProxies(proxies []model.ClashProxy, gen config.ProxyGroup) ([]string, error) {
+	var filter, exclude *regexp.Regexp
+	var err error
+	if gen.Filter != "" {
+		if filter, err = regexp.Compile(gen.Filter); err != nil {
+			return nil, err
+		}
+	}
+	if gen.Exclude != "" {
+		if exclude, err = regexp.Compile(gen.Exclude); err != nil {
+			return nil, err
+		}
+	}
+
+	var out []string
+	for _, p := range proxies {
+		if filter != nil && !filter.MatchString(p.Name) {
+			continue
+		}
+		if exclude != nil && exclude.MatchString(p.Name) {
+			continue
+		}
+		if gen.Region != "" {
+			if r, ok := model.DetectRegion(p.Name); !ok || !strings.EqualFold(r.Code, gen.Region) {
+				continue
+			}
+		}
+		out = append(out, p.Name)
+	}
+	return out, nil
+}
@@ -0,0 +1,58 @@
+package service
+
+import (
+	"reflect"
+	"server-master/internal/config"
+	"server-master/internal/model"
+	"testing"
+)
+
+func TestGenerateGroups(t *testing.T) {
+	cfg := &model.ClashConfig{
+		Proxies: []model.ClashProxy{
+			{Name: "🇭🇰 香港 01"}, {Name: "HK-02 IPLC"}, {Name: "日本 01"}, {Name: "Hong Kong 剩余流量"},
+		},
+		ProxyGroups: []model.ClashProxyGroup{
+			{Name: "Proxy", Type: "select", Proxies: []string{"Auto", "US Auto"}},
+		},
+		Rules: []string{"GEOIP,US,US Auto", "MATCH,Proxy"},
+	}
+	gens := []config.ProxyGroup{
+		{Name: "HK Auto", Type: "url-test", Region: "hk", Exclude: "剩余", URL: "http://cp", Interval: 300, Tolerance: 50},
+		{Name: "JP Auto", Type: "load-balance", Filter: "日本|JP", Strategy: "round-robin"},
+		{Name: "US Auto", Type: "url-test", Region: "US"},
+		{Name: "Auto", Type: "fallback", Proxies: []string{"HK Auto", "JP Auto", "US Auto"}},
+	}
+
+	generateGroups(cfg, gens)
+
+	byName := map[string]model.ClashProxyGroup{}
+	for _, g := range cfg.ProxyGroups {
+		byName[g.Name] = g
+	}
+
+	hk := byName["HK Auto"]
+	if want := []string{"🇭🇰 香港 01", "HK-02 IPLC"}; !reflect.DeepEqual(hk.Proxies, want) {
+		t.Errorf("HK Auto proxies = %v, want %v", hk.Proxies, want)
+	}
+	if hk.URL != "http://cp" || hk.Interval != 300 || hk.Tolerance != 50 {
+		t.Errorf("HK Auto options not carried over: %+v", hk)
+	}
+	if jp := byName["JP Auto"]; jp.Strategy != "round-robin" || len(jp.Proxies) != 1 {
+		t.Errorf("unexpected JP Auto: %+v", jp)
+	}
+
+	// US Auto matches nothing: it is dropped together with its references.
+	if _, ok := byName["US Auto"]; ok {
+		t.Error("expected empty group US Auto to be dropped")
+	}
+	if want := []string{"HK Auto", "JP Auto"}; !reflect.DeepEqual(byName["Auto"].Proxies, want) {
+		t.Errorf("Auto proxies = %v, want %v", byName["Auto"].Proxies, want)
+	}
+	if want := []string{"Auto"}; !reflect.DeepEqual(byName["Proxy"].Proxies, want) {
+		t.Errorf("Proxy proxies = %v, want %v", byName["Proxy"].Proxies, want)
+	}
+	if want := []string{"MATCH,Proxy"}; !reflect.DeepEqual(cfg.Rules, want) {
+		t.Errorf("rules = %v, want %v", cfg.Rules, want)
+	}
+}
@@ -0,0 +1,256 @@
+package service
+
+import (
+	"log/slog"
+	"net/netip"
+	"server-master/internal/config"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// limiterIdle is how long an unused rate limiter is kept. By then its bucket
+// is full again, so dropping it changes nothing.
+const limiterIdle = 10 * time.Minute
+
+// Ban is a client IP blocked after repeated authentication failures.
+type Ban struct {
+	IP       string    `json:"ip"`
+	Failures int       `json:"failures"`
+	Since    time.Time `json:"since"`
+	Until    time.Time `json:"until"`
+}
+
+type failureRecord struct {
+	count int
+	first time.Time
+}
+
+type limiterEntry struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// GuardService rate-limits subscription requests per client IP and per
+// token, and bans IPs that keep failing authentication.
+type GuardService struct {
+	cfg atomic.Pointer[config.Config]
+
+	mu        sync.Mutex
+	limiters  map[string]*limiterEntry // "ip:" / "token:" prefixed
+	failures  map[string]*failureRecord
+	bans      map[string]*Ban
+	lastSweep time.Time
+}
+
+func NewGuardService(cfg *config.Config) *GuardService {
+	s := &GuardService{
+		limiters: make(map[string]*limiterEntry),
+		failures: make(map[string]*failureRecord),
+		bans:     make(map[string]*Ban),
+	}
+	s.cfg.Store(cfg)
+	return s
+}
+
+// Reload swaps in a new configuration. Reloads happen on every token store
+// change, so limiters are only recreated when their rate changed; bans and
+// failure counts are kept.
+func (s *GuardService) Reload(cfg *config.Config) {
+	old := s.cfg.Swap(cfg).RateLimit
+	c := cfg.RateLimit
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key := range s.limiters {
+		if strings.HasPrefix(key, "ip:") && old.IPRate != c.IPRate ||
+			strings.HasPrefix(key, "token:") && old.TokenRate != c.TokenRate {
+			delete(s.limiters, key)
+		}
+	}
+}
+
+// Banned returns the time at which the ban of ip ends, if it is banned.
+func (s *GuardService) Banned(ip string) (time.Time, bool) {
+	if !s.cfg.Load().RateLimit.Enable {
+		return time.Time{}, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.bans[ip]
+	if !ok {
+		return time.Time{}, false
+	}
+	if time.Now().After(b.Until) {
+		delete(s.bans, ip)
+		return time.Time{}, false
+	}
+	return b.Until, true
+}
+
+// AllowIP consumes one request from the client IP's budget.
+func (s *GuardService) AllowIP(ip string) bool {
+	c := s.cfg.Load().RateLimit
+	return !c.Enable || s.allow("ip:"+ip, c.IPRate)
+}
+
+// AllowToken consumes one request from the budget of the token presented
+// by a client. The token must have been validated, so that looking it up
+// again hits the verification cache of hashed tokens.
+func (s *GuardService) AllowToken(token string) bool {
+	cfg := s.cfg.Load()
+	if !cfg.RateLimit.Enable {
+		return true
+	}
+	if t, ok := cfg.Token(token); ok {
+		token = t.Value
+	}
+	return s.AllowTokenID(TokenFingerprint(token))
+}
+
+// AllowTokenID consumes one request from the budget of the configured
+// token whose stored value has fingerprint id, as returned by
+// FileService.VerifySignature. It shares the budget of AllowToken.
+func (s *GuardService) AllowTokenID(id string) bool {
+	c := s.cfg.Load().RateLimit
+	return !c.Enable || s.allow("token:"+id, c.TokenRate)
+}
+
+func (s *GuardService) allow(key string, perMinute int) bool {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSweep) > time.Minute {
+		s.sweep(now)
+	}
+	e, ok := s.limiters[key]
+	if !ok {
+		e = &limiterEntry{limiter: rate.NewLimiter(rate.Limit(float64(perMinute)/60), perMinute)}
+		s.limiters[key] = e
+	}
+	e.lastSeen = now
+	return e.limiter.AllowN(now, 1)
+}
+
+// sweep drops idle limiters and expired failure records and bans.
+func (s *GuardService) sweep(now time.Time) {
+	s.lastSweep = now
+	window := time.Duration(s.cfg.Load().RateLimit.BanWindow) * time.Second
+	for k, e := range s.limiters {
+		if now.Sub(e.lastSeen) > limiterIdle {
+			delete(s.limiters, k)
+		}
+	}
+	for ip, f := range s.failures {
+		if now.Sub(f.first) > window {
+			delete(s.failures, ip)
+		}
+	}
+	for ip, b := range s.bans {
+		if now.After(b.Until) {
+			delete(s.bans, ip)
+		}
+	}
+}
+
+// RecordFailure counts a failed authentication from ip and bans it once the
+// threshold is reached within the window.
+func (s *GuardService) RecordFailure(ip string) {
+	c := s.cfg.Load().RateLimit
+	if !c.Enable {
+		return
+	}
+	now := time.Now()
+	window := time.Duration(c.BanWindow) * time.Second
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.failures[ip]
+	if !ok || now.Sub(f.first) > window {
+		f = &failureRecord{first: now}
+		s.failures[ip] = f
+	}
+	f.count++
+	if f.count < c.BanThreshold {
+		return
+	}
+
+	delete(s.failures, ip)
+	s.bans[ip] = &Ban{
+		IP:       ip,
+		Failures: f.count,
+		Since:    now,
+		Until:    now.Add(time.Duration(c.BanDuration) * time.Second),
+	}
+	slog.Warn("Client banned after repeated authentication failures", "ip", ip, "failures", f.count, "duration", time.Duration(c.BanDuration)*time.Second)
+}
+
+// Bans lists the active bans, newest first.
+func (s *GuardService) Bans() []Ban {
+	now := time.Now()
+	s.mu.Lock()
+	out := make([]Ban, 0, len(s.bans))
+	for _, b := range s.bans {
+		if now.Before(b.Until) {
+			out = append(out, *b)
+		}
+	}
+	s.mu.Unlock()
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Since.After(out[j].Since) })
+	return out
+}
+
+// Unban lifts the ban of ip and resets its failure count. It reports whether
+// ip was banned.
+func (s *GuardService) Unban(ip string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.bans[ip]
+	delete(s.bans, ip)
+	delete(s.failures, ip)
+	if ok {
+		slog.Info("Client unbanned", "ip", ip)
+	}
+	return ok
+}
+
+// ClearBans lifts all bans and returns how many there were.
+func (s *GuardService) ClearBans() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := len(s.bans)
+	s.bans = make(map[string]*Ban)
+	s.failures = make(map[string]*failureRecord)
+	slog.Info("All client bans cleared", "count", n)
+	return n
+}
+
+// ClientAllowed checks the token's IP allowlist. Tokens without one may be
+// used from anywhere.
+func (s *GuardService) ClientAllowed(token, ip string) bool {
+	t, ok := s.cfg.Load().Token(token)
+	return !ok || tokenAllowsIP(t, ip)
+}
+
+// tokenAllowsIP checks ip against the allowlist of t.
+func tokenAllowsIP(t config.Token, ip string) bool {
+	if len(t.AllowIPs) == 0 {
+		return true
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, allowed := range t.AllowIPs {
+		if p, err := config.ParsePrefix(allowed); err == nil && p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
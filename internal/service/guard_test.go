@@ -0,0 +1,173 @@
+package service
+
+import (
+	"runtime"
+	"server-master/internal/config"
+	"testing"
+)
+
+func TestGuardService_RateLimitAndBan(t *testing.T) {
+	cfg := &config.Config{
+		RateLimit: config.RateLimitConfig{
+			Enable:       true,
+			IPRate:       2,
+			TokenRate:    3,
+			BanThreshold: 3,
+			BanWindow:    600,
+			BanDuration:  3600,
+		},
+	}
+	s := NewGuardService(cfg)
+
+	if !s.AllowIP("1.1.1.1") || !s.AllowIP("1.1.1.1") || s.AllowIP("1.1.1.1") {
+		t.Error("expected the third request within the burst to be limited")
+	}
+	if !s.AllowIP("2.2.2.2") {
+		t.Error("limits must be tracked per IP")
+	}
+	for range 3 {
+		if !s.AllowToken("t") {
+			t.Fatal("token limited too early")
+		}
+	}
+	if s.AllowToken("t") {
+		t.Error("expected token to be limited")
+	}
+
+	s.RecordFailure("3.3.3.3")
+	s.RecordFailure("3.3.3.3")
+	if _, banned := s.Banned("3.3.3.3"); banned {
+		t.Fatal("banned before reaching the threshold")
+	}
+	s.RecordFailure("3.3.3.3")
+	if _, banned := s.Banned("3.3.3.3"); !banned {
+		t.Fatal("expected ban after threshold")
+	}
+	if bans := s.Bans(); len(bans) != 1 || bans[0].IP != "3.3.3.3" || bans[0].Failures != 3 {
+		t.Errorf("unexpected bans: %+v", bans)
+	}
+
+	if !s.Unban("3.3.3.3") || s.Unban("3.3.3.3") {
+		t.Error("Unban should succeed exactly once")
+	}
+	if _, banned := s.Banned("3.3.3.3"); banned {
+		t.Error("still banned after Unban")
+	}
+
+	for range 3 {
+		s.RecordFailure("4.4.4.4")
+	}
+	if n := s.ClearBans(); n != 1 || len(s.Bans()) != 0 {
+		t.Errorf("ClearBans() = %d, remaining %v", n, s.Bans())
+	}
+
+	cfg.RateLimit.Enable = false
+	for range 5 {
+		s.RecordFailure("5.5.5.5")
+	}
+	if _, banned := s.Banned("5.5.5.5"); banned || !s.AllowIP("1.1.1.1") {
+		t.Error("guard must be inactive when disabled")
+	}
+}
+
+func TestGuardService_ReloadKeepsLimiters(t *testing.T) {
+	limits := config.RateLimitConfig{Enable: true, IPRate: 1, TokenRate: 1, BanThreshold: 1, BanWindow: 600, BanDuration: 3600}
+	s := NewGuardService(&config.Config{RateLimit: limits})
+	if !s.AllowIP("1.1.1.1") || !s.AllowTokenID("t") {
+		t.Fatal("first requests limited")
+	}
+	s.RecordFailure("2.2.2.2")
+
+	// A reload with the same limits, e.g. after a token store change.
+	s.Reload(&config.Config{RateLimit: limits})
+	if s.AllowIP("1.1.1.1") || s.AllowTokenID("t") {
+		t.Error("reload reset unchanged rate limiters")
+	}
+	if _, banned := s.Banned("2.2.2.2"); !banned {
+		t.Error("reload lifted a ban")
+	}
+
+	// Only the limiters whose rate changed are recreated.
+	limits.TokenRate = 5
+	s.Reload(&config.Config{RateLimit: limits})
+	if s.AllowIP("1.1.1.1") {
+		t.Error("IP limiter reset although its rate is unchanged")
+	}
+	if !s.AllowTokenID("t") {
+		t.Error("token limiter kept its old rate")
+	}
+}
+
+func TestGuardService_TokenBudgetShared(t *testing.T) {
+	for _, algo := range []string{"sha256", "argon2id"} {
+		t.Run(algo, func(t *testing.T) {
+			hash, err := config.HashToken("s3cret", algo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg := &config.Config{
+				Listen:    ":8080",
+				ProxyPath: "p.yaml",
+				RulePath:  "r/",
+				Tokens:    []config.Token{{Value: hash}},
+				RateLimit: config.RateLimitConfig{Enable: true, IPRate: 100, TokenRate: 3},
+			}
+			if err := cfg.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			s := NewGuardService(cfg)
+			files := NewFileService(cfg)
+			tid, sig := fileTokenID(cfg.Tokens[0]), fileSignature(cfg.Tokens[0], "direct-domain.yaml")
+
+			// The signed /file path must not verify the hash again: each
+			// argon2id verification allocates its whole memory cost.
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+			for range 2 {
+				id, ok := files.VerifySignature(tid, "direct-domain.yaml", sig, "192.0.2.1")
+				if !ok || !s.AllowTokenID(id) {
+					t.Fatal("signed request rejected")
+				}
+			}
+			runtime.ReadMemStats(&after)
+			if grown := after.TotalAlloc - before.TotalAlloc; grown > 1<<20 {
+				t.Errorf("signed requests allocated %d bytes; the token hash was verified", grown)
+			}
+
+			// /sub presents the token itself and shares the budget.
+			if !s.AllowToken("s3cret") {
+				t.Fatal("token limited too early")
+			}
+			if s.AllowToken("s3cret") {
+				t.Error("signed requests must share the budget of the token")
+			}
+		})
+	}
+}
+
+func TestGuardService_ClientAllowed(t *testing.T) {
+	cfg := &config.Config{
+		Tokens: []config.Token{
+			{Value: "office", AllowIPs: []string{"10.0.0.0/8", "203.0.113.7"}},
+			{Value: "open"},
+		},
+	}
+	s := NewGuardService(cfg)
+
+	tests := []struct {
+		token, ip string
+		want      bool
+	}{
+		{"office", "10.1.2.3", true},
+		{"office", "203.0.113.7", true},
+		{"office", "::ffff:10.1.2.3", true},
+		{"office", "203.0.113.8", false},
+		{"office", "not-an-ip", false},
+		{"open", "198.51.100.1", true},
+	}
+	for _, tt := range tests {
+		if got := s.ClientAllowed(tt.token, tt.ip); got != tt.want {
+			t.Errorf("ClientAllowed(%q, %q) = %v, want %v", tt.token, tt.ip, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"net"
+	"server-master/internal/config"
+	"server-master/internal/metrics"
+	"server-master/internal/model"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// ProxySource lists the proxies that may end up in a subscription.
+type ProxySource interface {
+	ProbeTargets(ctx context.Context) []model.ClashProxy
+}
+
+// NodeHealth is the probe history of a single proxy endpoint.
+type NodeHealth struct {
+	Name      string    `json:"name"`
+	Type      string    `json:"type"`
+	Server    string    `json:"server"`
+	Port      int       `json:"port"`
+	Alive     bool      `json:"alive"`
+	LatencyMS int64     `json:"latency_ms"`
+	Failures  int       `json:"failures"`
+	Dead      bool      `json:"dead"`
+	LastCheck time.Time `json:"last_check,omitzero"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// HealthService probes every proxy's server and excludes or demotes the ones
+// that failed too many consecutive checks.
+type HealthService struct {
+	cfg    atomic.Pointer[config.Config]
+	source ProxySource
+
+	mu    sync.RWMutex
+	nodes map[string]*NodeHealth // by healthKey
+}
+
+func NewHealthService(cfg *config.Config, source ProxySource) *HealthService {
+	s := &HealthService{
+		source: source,
+		nodes:  make(map[string]*NodeHealth),
+	}
+	s.cfg.Store(cfg)
+	return s
+}
+
+// Reload swaps in a new configuration. It takes effect on the next check.
+func (s *HealthService) Reload(cfg *config.Config) {
+	s.cfg.Store(cfg)
+}
+
+// healthKey identifies the endpoint of a proxy. Proxy names are not unique
+// across profiles: additions are renamed differently depending on what else
+// a profile merges, so results are kept per endpoint instead.
+func healthKey(p *model.ClashProxy) string {
+	return p.Type + "|" + endpoint(p)
+}
+
+// udpOnly lists proxy types that cannot be probed with a TCP connect.
+var udpOnly = map[string]bool{
+	"hysteria":  true,
+	"hysteria2": true,
+	"tuic":      true,
+	"wireguard": true,
+}
+
+// CheckAll probes every proxy endpoint once and updates the failure
+// counters. Endpoints that are no longer listed are forgotten.
+func (s *HealthService) CheckAll(ctx context.Context) {
+	c := s.cfg.Load().Cron.HealthCheck
+	var targets []model.ClashProxy
+	seen := make(map[string]bool)
+	for _, p := range s.source.ProbeTargets(ctx) {
+		if key := healthKey(&p); !seen[key] {
+			seen[key] = true
+			targets = append(targets, p)
+		}
+	}
+
+	results := make([]NodeHealth, len(targets))
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(c.Concurrency)
+	for i, p := range targets {
+		if udpOnly[p.Type] || p.Server == "" || p.Port == 0 {
+			results[i] = NodeHealth{Name: p.Name, Type: p.Type, Server: p.Server, Port: p.Port, Alive: true}
+			continue
+		}
+		g.Go(func() error {
+			results[i] = probe(ctx, &p, time.Duration(c.Timeout)*time.Second, c.TLS)
+			return nil
+		})
+	}
+	_ = g.Wait()
+
+	s.mu.Lock()
+	next := make(map[string]*NodeHealth, len(results))
+	for i := range results {
+		r := &results[i]
+		key := healthKey(&targets[i])
+		if prev, ok := s.nodes[key]; ok && !r.Alive {
+			r.Failures = prev.Failures + 1
+		} else if !r.Alive {
+			r.Failures = 1
+		}
+		r.Dead = r.Failures >= c.Threshold
+		next[key] = r
+	}
+	s.nodes = next
+	s.mu.Unlock()
+
+	metrics.ProxyUp.Reset()
+	metrics.ProxyLatency.Reset()
+	dead := 0
+	for _, r := range results {
+		if r.Dead {
+			dead++
+		}
+		up := 0.0
+		if r.Alive {
+			up = 1
+			metrics.ProxyLatency.WithLabelValues(r.Name).Set(float64(r.LatencyMS) / 1000)
+		}
+		metrics.ProxyUp.WithLabelValues(r.Name).Set(up)
+	}
+	slog.Info("Proxy health check completed", "nodes", len(results), "dead", dead)
+}
+
+// probe dials the proxy's server and, if requested, completes a TLS
+// handshake. Certificates are not verified: only reachability matters here.
+func probe(ctx context.Context, p *model.ClashProxy, timeout time.Duration, withTLS bool) NodeHealth {
+	h := NodeHealth{Name: p.Name, Type: p.Type, Server: p.Server, Port: p.Port, LastCheck: time.Now()}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(p.Server, strconv.Itoa(p.Port)))
+	if err != nil {
+		h.LastError = err.Error()
+		return h
+	}
+	defer conn.Close()
+
+	if withTLS && p.TLSEnabled() {
+		sni := p.ServerName()
+		if sni == "" {
+			sni = p.Server
+		}
+		tc := tls.Client(conn, &tls.Config{ServerName: sni, InsecureSkipVerify: true})
+		if err := tc.HandshakeContext(ctx); err != nil {
+			h.LastError = "tls: " + err.Error()
+			return h
+		}
+	}
+
+	h.Alive = true
+	h.LatencyMS = time.Since(start).Milliseconds()
+	return h
+}
+
+// Nodes returns the latest probe results, sorted by name.
+func (s *HealthService) Nodes() []NodeHealth {
+	s.mu.RLock()
+	out := make([]NodeHealth, 0, len(s.nodes))
+	for _, n := range s.nodes {
+		out = append(out, *n)
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// dead returns the names of the proxies in cfg whose endpoint reached the
+// failure threshold.
+func (s *HealthService) dead(cfg *model.ClashConfig) map[string]bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make(map[string]bool)
+	for i := range cfg.Proxies {
+		if n, ok := s.nodes[healthKey(&cfg.Proxies[i])]; ok && n.Dead {
+			out[cfg.Proxies[i].Name] = true
+		}
+	}
+	return out
+}
+
+// Apply drops or demotes dead nodes in cfg according to the configured
+// action. A group is never emptied: if all of its members are dead they are
+// kept so that rules targeting it stay valid. Rules targeting a dropped
+// proxy directly are removed.
+func (s *HealthService) Apply(cfg *model.ClashConfig) {
+	c := s.cfg.Load().Cron.HealthCheck
+	if !c.Enable {
+		return
+	}
+	dead := s.dead(cfg)
+	if len(dead) == 0 {
+		return
+	}
+
+	isDead := func(name string) bool { return dead[name] }
+
+	if c.Action == "demote" {
+		cfg.Proxies = demote(cfg.Proxies, func(p model.ClashProxy) bool { return dead[p.Name] })
+		for i := range cfg.ProxyGroups {
+			cfg.ProxyGroups[i].Proxies = demote(cfg.ProxyGroups[i].Proxies, isDead)
+		}
+		return
+	}
+
+	for _, g := range cfg.ProxyGroups {
+		if len(g.Proxies) > 0 && !slices.ContainsFunc(g.Proxies, func(n string) bool { return !dead[n] }) {
+			for _, n := range g.Proxies {
+				delete(dead, n)
+			}
+		}
+	}
+	cfg.Proxies = slices.DeleteFunc(cfg.Proxies, func(p model.ClashProxy) bool { return dead[p.Name] })
+	for i := range cfg.ProxyGroups {
+		cfg.ProxyGroups[i].Proxies = slices.DeleteFunc(cfg.ProxyGroups[i].Proxies, isDead)
+	}
+	cfg.Rules = slices.DeleteFunc(cfg.Rules, func(rule string) bool { return dead[rulePolicy(rule)] })
+}
+
+// demote moves the elements matching dead to the end, keeping relative order.
+func demote[T any](items []T, dead func(T) bool) []T {
+	out := make([]T, 0, len(items))
+	var tail []T
+	for _, it := range items {
+		if dead(it) {
+			tail = append(tail, it)
+		} else {
+			out = append(out, it)
+		}
+	}
+	return append(out, tail...)
+}
+
+// Task interface implementation
+
+func (s *HealthService) Name() string {
+	return "ProxyHealthCheck"
+}
+
+func (s *HealthService) Spec() string {
+	return s.cfg.Load().Cron.HealthCheck.Cycle
+}
+
+func (s *HealthService) Run() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	s.CheckAll(ctx)
+}
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"context"
+	"net"
+	"server-master/internal/config"
+	"server-master/internal/model"
+	"slices"
+	"testing"
+)
+
+type staticProxies []model.ClashProxy
+
+func (s staticProxies) ProbeTargets(context.Context) []model.ClashProxy { return s }
+
+func TestHealthService_CheckAndApply(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	alivePort := ln.Addr().(*net.TCPAddr).Port
+
+	// Grab a free port and close it so that connects are refused.
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadPort := closed.Addr().(*net.TCPAddr).Port
+	closed.Close()
+
+	source := staticProxies{
+		{Name: "alive", Type: "ss", Server: "127.0.0.1", Port: alivePort},
+		{Name: "dead", Type: "ss", Server: "127.0.0.1", Port: deadPort},
+		{Name: "hy2", Type: "hysteria2", Server: "127.0.0.1", Port: deadPort},
+	}
+	cfg := &config.Config{Cron: config.CronConfig{HealthCheck: config.HealthCheckConfig{
+		Enable: true, Timeout: 1, Threshold: 2, Action: "drop", Concurrency: 4,
+	}}}
+	s := NewHealthService(cfg, source)
+
+	newConfig := func() *model.ClashConfig {
+		return &model.ClashConfig{
+			Proxies: []model.ClashProxy{source[1], source[0], source[2]},
+			ProxyGroups: []model.ClashProxyGroup{
+				{Name: "all", Proxies: []string{"dead", "alive", "hy2"}},
+				{Name: "only-dead", Proxies: []string{"dead"}},
+			},
+		}
+	}
+
+	// One failure is below the threshold: nothing is excluded yet.
+	s.CheckAll(context.Background())
+	out := newConfig()
+	s.Apply(out)
+	if len(out.Proxies) != 3 {
+		t.Fatalf("expected no exclusions after one failure, got %+v", out.Proxies)
+	}
+
+	s.CheckAll(context.Background())
+	nodes := s.Nodes()
+	if len(nodes) != 3 || nodes[0].Name != "alive" || !nodes[0].Alive || nodes[1].Failures != 2 || !nodes[1].Dead {
+		t.Fatalf("unexpected node health: %+v", nodes)
+	}
+	if !nodes[2].Alive {
+		t.Error("UDP-only proxies must not be probed over TCP")
+	}
+
+	// The dead node is the only member of a group, so it must be kept.
+	out = newConfig()
+	s.Apply(out)
+	if len(out.Proxies) != 3 || len(out.ProxyGroups[1].Proxies) != 1 {
+		t.Errorf("group must not be emptied: %+v", out)
+	}
+
+	out = newConfig()
+	out.ProxyGroups = out.ProxyGroups[:1]
+	out.Rules = []string{"DOMAIN,a.example.com,dead", "DOMAIN,b.example.com,alive", "MATCH,all"}
+	s.Apply(out)
+	if len(out.Proxies) != 2 || len(out.ProxyGroups[0].Proxies) != 2 {
+		t.Errorf("expected dead node to be dropped: %+v", out)
+	}
+	if want := []string{"DOMAIN,b.example.com,alive", "MATCH,all"}; !slices.Equal(out.Rules, want) {
+		t.Errorf("Rules = %v, want %v", out.Rules, want)
+	}
+
+	// Health follows the endpoint, not the name: in another profile the
+	// names may belong to other servers.
+	out = &model.ClashConfig{
+		Proxies: []model.ClashProxy{
+			{Name: "dead", Type: "ss", Server: "127.0.0.1", Port: alivePort},
+			{Name: "alive 2", Type: "ss", Server: "127.0.0.1", Port: deadPort},
+			{Name: "other", Type: "ss", Server: "127.0.0.1", Port: alivePort},
+		},
+		ProxyGroups: []model.ClashProxyGroup{{Name: "all", Proxies: []string{"dead", "alive 2", "other"}}},
+	}
+	s.Apply(out)
+	if got := out.ProxyGroups[0].Proxies; !slices.Equal(got, []string{"dead", "other"}) {
+		t.Errorf("renamed proxies: group = %v, want [dead other]", got)
+	}
+
+	cfg.Cron.HealthCheck.Action = "demote"
+	out = newConfig()
+	s.Apply(out)
+	if got := out.ProxyGroups[0].Proxies; got[0] != "alive" || got[2] != "dead" {
+		t.Errorf("expected dead node to be demoted, got %v", got)
+	}
+}
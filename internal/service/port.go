@@ -6,7 +6,9 @@ import (
 	"math/rand"
 	"os/exec"
 	"server-master/internal/config"
+	"server-master/internal/metrics"
 	"server-master/pkg/utils"
+	"sync/atomic"
 )
 
 // iptablesRunner specializes in executing iptables-related commands.
@@ -16,18 +18,31 @@ func (r *iptablesRunner) Run(args ...string) error {
 	return exec.Command("iptables", args...).Run()
 }
 
+// Output runs an iptables command and returns its standard output.
+func (r *iptablesRunner) Output(args ...string) ([]byte, error) {
+	return exec.Command("iptables", args...).Output()
+}
+
 type PortService struct {
-	cfg   *config.Config
+	cfg   atomic.Pointer[config.Config]
 	queue *utils.Queue[string]
 	ipt   *iptablesRunner
 }
 
 func NewPortService(cfg *config.Config, queue *utils.Queue[string]) *PortService {
-	return &PortService{
-		cfg:   cfg,
+	s := &PortService{
 		queue: queue,
 		ipt:   &iptablesRunner{},
 	}
+	s.cfg.Store(cfg)
+	return s
+}
+
+// Reload swaps in a new configuration. Callers must remove the task (which
+// cleans up iptables with the old range) before swapping in a changed
+// dynamic-port section, and re-add it afterwards.
+func (s *PortService) Reload(cfg *config.Config) {
+	s.cfg.Store(cfg)
 }
 
 const (
@@ -37,7 +52,7 @@ const (
 
 // InitIptables prepares the iptables rules for dynamic port forwarding.
 func (s *PortService) InitIptables() error {
-	c := s.cfg.Cron.DynamicPort
+	c := s.cfg.Load().Cron.DynamicPort
 	portRange := fmt.Sprintf("%d:%d", c.Min, c.Max)
 
 	slog.Info("Initializing iptables for dynamic ports", "range", portRange)
@@ -67,7 +82,7 @@ func (s *PortService) ensureCustomChain() error {
 
 // InitialSetup fills the queue with initial random ports and sets up iptables rules.
 func (s *PortService) InitialSetup() {
-	targetPort := fmt.Sprintf("%d", s.cfg.Cron.DynamicPort.TrojanPort)
+	targetPort := fmt.Sprintf("%d", s.cfg.Load().Cron.DynamicPort.TrojanPort)
 
 	s.queue.Clear()
 	for !s.queue.IsFull() {
@@ -82,8 +97,10 @@ func (s *PortService) InitialSetup() {
 }
 
 // RotatePort replaces one old port with a new random port.
-func (s *PortService) RotatePort() {
-	targetPort := fmt.Sprintf("%d", s.cfg.Cron.DynamicPort.TrojanPort)
+func (s *PortService) RotatePort() (err error) {
+	defer func() { metrics.PortRotations.WithLabelValues(metrics.Result(err)).Inc() }()
+
+	targetPort := fmt.Sprintf("%d", s.cfg.Load().Cron.DynamicPort.TrojanPort)
 
 	// Remove the oldest port from both the queue and iptables.
 	if oldPort := s.queue.Dequeue(); oldPort != "" {
@@ -94,17 +111,25 @@ func (s *PortService) RotatePort() {
 
 	// Generate a new unique port and add its redirect rule.
 	newPort := s.generateUniquePort()
+	if newPort == "" {
+		return fmt.Errorf("no free port left in range")
+	}
 	if err := s.modifyRedirect("-A", newPort, targetPort); err != nil {
-		slog.Error("Failed to add new redirect", "port", newPort, "error", err)
-		return
+		return fmt.Errorf("failed to add redirect for port %s: %w", newPort, err)
 	}
 	s.queue.Enqueue(newPort)
 
 	slog.Info("Dynamic port rotated", "new_port", newPort)
+	return nil
+}
+
+// ActivePorts returns the ports currently redirected, oldest first.
+func (s *PortService) ActivePorts() []string {
+	return s.queue.Items()
 }
 
 func (s *PortService) generateUniquePort() string {
-	c := s.cfg.Cron.DynamicPort
+	c := s.cfg.Load().Cron.DynamicPort
 	for range 100 { // Limit attempts to prevent hanging if range is too small.
 		p := rand.Intn(c.Max-c.Min+1) + c.Min
 		port := fmt.Sprintf("%d", p)
@@ -126,11 +151,17 @@ func (s *PortService) Name() string {
 }
 
 func (s *PortService) Spec() string {
-	return s.cfg.Cron.DynamicPort.Cycle
+	return s.cfg.Load().Cron.DynamicPort.Cycle
 }
 
 func (s *PortService) Run() {
-	s.RotatePort()
+	if err := s.RotatePort(); err != nil {
+		slog.Error("Dynamic port rotation failed", "error", err)
+	}
+}
+
+func (s *PortService) RunErr() error {
+	return s.RotatePort()
 }
 
 func (s *PortService) Init() error {
@@ -151,7 +182,7 @@ func (s *PortService) Cleanup() {
 }
 
 func (s *PortService) CleanupIptables() error {
-	c := s.cfg.Cron.DynamicPort
+	c := s.cfg.Load().Cron.DynamicPort
 	portRange := fmt.Sprintf("%d:%d", c.Min, c.Max)
 
 	slog.Info("Cleaning up iptables for dynamic ports", "range", portRange)
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"server-master/internal/config"
+	"server-master/internal/model"
+	"server-master/pkg/ruleset"
+	"strings"
+)
+
+type baseURLKey struct{}
+
+// WithBaseURL returns a context carrying the external URL of this server as
+// seen by the client. It is used for the generated rule-provider URLs when
+// rule-providers.base-url is not set.
+func WithBaseURL(ctx context.Context, baseURL string) context.Context {
+	return context.WithValue(ctx, baseURLKey{}, baseURL)
+}
+
+func baseURLFrom(ctx context.Context, c config.RuleProvidersConfig) string {
+	if c.BaseURL != "" {
+		return c.BaseURL
+	}
+	base, _ := ctx.Value(baseURLKey{}).(string)
+	return strings.TrimSuffix(base, "/")
+}
+
+// ruleFileBehavior returns the rule-provider behavior of a rule file named
+// after its kind, see config.RuleFile.
+func ruleFileBehavior(name string) string {
+	switch {
+	case strings.HasSuffix(name, "-domain"):
+		return "domain"
+	case strings.HasSuffix(name, "-ip"):
+		return "ipcidr"
+	default:
+		return "classical"
+	}
+}
+
+// ruleFileFormat picks the format and extension under which the rule file
+// name is referenced. Compiled mrs copies are preferred when configured and
+// present; otherwise the YAML file is used. It returns "" if neither exists.
+func ruleFileFormat(rulePath, preferred, name, behavior string) (string, string) {
+	if preferred == ruleset.MRS && ruleset.Compilable(behavior) {
+		ext := ruleset.Ext(ruleset.MRS)
+		if _, err := os.Stat(filepath.Join(rulePath, name+ext)); err == nil {
+			return ruleset.MRS, ext
+		}
+	}
+	if _, err := os.Stat(filepath.Join(rulePath, name+".yaml")); err == nil {
+		return "yaml", ".yaml"
+	}
+	return "", ""
+}
+
+// addRuleProviders adds a rule provider for every rule file present under
+// rule-path and returns the matching RULE-SET rules in the configured
+// order. Providers already defined in the base config are left alone. The
+// URLs are signed for the token later on.
+func addRuleProviders(ctx context.Context, cfg *config.Config, proxy *model.ClashConfig) []string {
+	c := cfg.RuleProviders
+	base := baseURLFrom(ctx, c)
+	if base == "" {
+		slog.Warn("Skipping rule providers: rule-providers.base-url is not set and the request URL is unknown")
+		return nil
+	}
+
+	groups := make(map[string]bool, len(proxy.ProxyGroups))
+	for _, g := range proxy.ProxyGroups {
+		groups[g.Name] = true
+	}
+
+	var rules []string
+	for _, name := range c.Order {
+		if _, ok := proxy.RuleProviders[name]; ok {
+			continue
+		}
+		behavior := ruleFileBehavior(name)
+		format, ext := ruleFileFormat(cfg.RulePath, c.Format, name, behavior)
+		if format == "" {
+			continue
+		}
+		category, _, ok := cfg.Cron.RuleSet.RuleFileCategory(name)
+		if !ok {
+			continue
+		}
+		policy := c.Policy(category)
+		if policy != "DIRECT" && policy != "REJECT" && !groups[policy] {
+			slog.Warn("Skipping rule provider with unknown policy", "file", name, "policy", policy)
+			continue
+		}
+
+		if proxy.RuleProviders == nil {
+			proxy.RuleProviders = make(map[string]model.RuleProvider)
+		}
+		proxy.RuleProviders[name] = model.RuleProvider{
+			Type:     "http",
+			Behavior: behavior,
+			Format:   format,
+			URL:      base + "/file/" + name + ext,
+			Path:     "./ruleset/" + name + ext,
+			Interval: c.Interval,
+		}
+		rule := "RULE-SET," + name + "," + policy
+		if behavior == "ipcidr" {
+			rule += ",no-resolve"
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
@@ -0,0 +1,224 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"server-master/internal/config"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// usageState is the persisted usage of one token. Raw holds the source's
+// last reported counters so that deltas survive counter resets.
+type usageState struct {
+	Total Traffic `json:"total"`
+	Raw   Traffic `json:"raw"`
+}
+
+// QuotaService tracks per-token traffic and expiry and renders the
+// Subscription-Userinfo header for tokens with a configured quota.
+type QuotaService struct {
+	cfg    atomic.Pointer[config.Config]
+	source TrafficSource
+
+	mu    sync.RWMutex
+	usage map[string]*usageState
+}
+
+func NewQuotaService(cfg *config.Config) *QuotaService {
+	s := &QuotaService{
+		source: newTrafficSource(cfg),
+		usage:  make(map[string]*usageState),
+	}
+	s.cfg.Store(cfg)
+	return s
+}
+
+// Reload swaps in a new configuration. Quota limits apply immediately; the
+// traffic source is rebuilt the next time the task is initialized.
+func (s *QuotaService) Reload(cfg *config.Config) {
+	s.cfg.Store(cfg)
+}
+
+// UserInfo returns the Subscription-Userinfo header for token, or false if
+// the token has no quota and the upstream header should be used instead.
+func (s *QuotaService) UserInfo(token string) (string, bool) {
+	t, ok := s.cfg.Load().Token(token)
+	if !ok || t.Quota == nil {
+		return "", false
+	}
+
+	used := s.Usage(t.Name)
+	var expire int64
+	if !t.Quota.Expire.IsZero() {
+		expire = t.Quota.Expire.Unix()
+	}
+	return fmt.Sprintf("upload=%d; download=%d; total=%d; expire=%d",
+		used.Upload, used.Download, int64(t.Quota.Total), expire), true
+}
+
+// Expired reports whether token or its quota has passed its expiry date.
+func (s *QuotaService) Expired(token string) bool {
+	t, ok := s.cfg.Load().Token(token)
+	return ok && t.Expired(time.Now())
+}
+
+// Usage returns the accumulated traffic of the named token.
+func (s *QuotaService) Usage(name string) Traffic {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if st, ok := s.usage[name]; ok {
+		return st.Total
+	}
+	return Traffic{}
+}
+
+// Collect pulls counters from the source and folds them into the totals.
+func (s *QuotaService) Collect(ctx context.Context) error {
+	s.mu.RLock()
+	source := s.source
+	s.mu.RUnlock()
+
+	counters, err := source.Collect(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, raw := range counters {
+		st, ok := s.usage[name]
+		if !ok {
+			st = &usageState{}
+			s.usage[name] = st
+		}
+		st.Total.Upload += counterDelta(st.Raw.Upload, raw.Upload)
+		st.Total.Download += counterDelta(st.Raw.Download, raw.Download)
+		st.Raw = raw
+	}
+	return nil
+}
+
+// counterDelta treats a counter that went backwards as reset to zero.
+func counterDelta(prev, cur int64) int64 {
+	if cur < prev {
+		return cur
+	}
+	return cur - prev
+}
+
+func (s *QuotaService) load() error {
+	data, err := os.ReadFile(s.cfg.Load().Cron.Traffic.StatePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read traffic state: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := json.Unmarshal(data, &s.usage); err != nil {
+		return fmt.Errorf("failed to decode traffic state: %w", err)
+	}
+	return nil
+}
+
+func (s *QuotaService) save() error {
+	s.mu.RLock()
+	data, err := json.MarshalIndent(s.usage, "", "  ")
+	s.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	path := s.cfg.Load().Cron.Traffic.StatePath
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// Task interface implementation
+
+func (s *QuotaService) Name() string {
+	return "TrafficCollect"
+}
+
+func (s *QuotaService) Spec() string {
+	return s.cfg.Load().Cron.Traffic.Cycle
+}
+
+func (s *QuotaService) Run() {
+	if err := s.RunErr(); err != nil {
+		slog.Error("Traffic collection failed", "error", err)
+	}
+}
+
+// RunErr collects the counters once and persists the totals.
+func (s *QuotaService) RunErr() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := s.Collect(ctx); err != nil {
+		return fmt.Errorf("failed to collect traffic counters: %w", err)
+	}
+	if err := s.save(); err != nil {
+		return fmt.Errorf("failed to persist traffic state: %w", err)
+	}
+	return nil
+}
+
+func (s *QuotaService) Init() error {
+	if err := s.load(); err != nil {
+		return err
+	}
+	return s.setSource(newTrafficSource(s.cfg.Load()))
+}
+
+// setSource installs and initializes source. Initializing a source restarts
+// its counters from zero, so the persisted raw counters no longer apply: a
+// counter that grew past its old value before the first collection would
+// otherwise be taken for a continuation and under-counted.
+func (s *QuotaService) setSource(source TrafficSource) error {
+	s.mu.Lock()
+	s.source = source
+	s.mu.Unlock()
+
+	i, ok := source.(Initializer)
+	if !ok {
+		return nil
+	}
+	if err := i.Init(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	for _, st := range s.usage {
+		st.Raw = Traffic{}
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// Cleanup persists the totals and releases the source.
+func (s *QuotaService) Cleanup() {
+	if err := s.save(); err != nil {
+		slog.Error("Failed to persist traffic state", "error", err)
+	}
+	s.mu.RLock()
+	source := s.source
+	s.mu.RUnlock()
+
+	if c, ok := source.(Cleaner); ok {
+		c.Cleanup()
+	}
+}
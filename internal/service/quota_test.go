@@ -0,0 +1,141 @@
+package service
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"server-master/internal/config"
+	"testing"
+	"time"
+)
+
+func TestQuotaService_CollectAndPersist(t *testing.T) {
+	tempDir := t.TempDir()
+	sourcePath := filepath.Join(tempDir, "counters.json")
+	statePath := filepath.Join(tempDir, "state", "traffic.json")
+
+	cfg := &config.Config{
+		Tokens: []config.Token{
+			{Value: "t1", Name: "laptop", Quota: &config.Quota{Total: 1000, Expire: time.Unix(2000000000, 0)}},
+			{Value: "t2", Name: "old", Quota: &config.Quota{Expire: time.Now().Add(-time.Hour)}},
+			{Value: "t3"},
+		},
+		Cron: config.CronConfig{Traffic: config.TrafficConfig{
+			Enable:     true,
+			Source:     "file",
+			SourcePath: sourcePath,
+			StatePath:  statePath,
+		}},
+	}
+
+	writeCounters := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(sourcePath, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := NewQuotaService(cfg)
+	if err := s.Init(); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+
+	writeCounters(`{"laptop": {"upload": 100, "download": 200}}`)
+	s.Run()
+	// Counter reset between collections: the new value counts in full.
+	writeCounters(`{"laptop": {"upload": 30, "download": 250}}`)
+	s.Run()
+
+	if got := s.Usage("laptop"); got.Upload != 130 || got.Download != 250 {
+		t.Errorf("Usage = %+v, want upload=130 download=250", got)
+	}
+
+	// A new instance must resume from the persisted state.
+	restarted := NewQuotaService(cfg)
+	if err := restarted.Init(); err != nil {
+		t.Fatalf("Init after restart failed: %v", err)
+	}
+	writeCounters(`{"laptop": {"upload": 40, "download": 260}}`)
+	restarted.Run()
+
+	info, ok := restarted.UserInfo("t1")
+	if !ok {
+		t.Fatal("expected quota userinfo for t1")
+	}
+	if want := "upload=140; download=260; total=1000; expire=2000000000"; info != want {
+		t.Errorf("UserInfo = %q, want %q", info, want)
+	}
+	if _, ok := restarted.UserInfo("t3"); ok {
+		t.Error("token without quota should fall back to upstream userinfo")
+	}
+
+	if restarted.Expired("t1") {
+		t.Error("t1 should not be expired")
+	}
+	if !restarted.Expired("t2") {
+		t.Error("t2 should be expired")
+	}
+	if restarted.Expired("t3") {
+		t.Error("token without quota never expires")
+	}
+}
+
+// flushedSource counts from zero after Init, like the iptables source.
+type flushedSource struct{ counters map[string]Traffic }
+
+func (s *flushedSource) Init() error {
+	s.counters = map[string]Traffic{}
+	return nil
+}
+
+func (s *flushedSource) Collect(context.Context) (map[string]Traffic, error) {
+	return s.counters, nil
+}
+
+func TestQuotaService_InitResetsRawCounters(t *testing.T) {
+	s := NewQuotaService(&config.Config{})
+	s.usage["laptop"] = &usageState{Total: Traffic{Upload: 1000}, Raw: Traffic{Upload: 100, Download: 100}}
+
+	source := &flushedSource{}
+	if err := s.setSource(source); err != nil {
+		t.Fatal(err)
+	}
+	// The new counter passes the old raw value before the first collection.
+	source.counters["laptop"] = Traffic{Upload: 150, Download: 50}
+	if err := s.Collect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Usage("laptop"); got.Upload != 1150 || got.Download != 50 {
+		t.Errorf("Usage = %+v, want upload=1150 download=50", got)
+	}
+}
+
+func TestParseAcctCounters(t *testing.T) {
+	out := `Chain sm-traffic-acct (2 references)
+    pkts      bytes target     prot opt in     out     source               destination
+     120     5000            tcp  --  *      *       0.0.0.0/0            0.0.0.0/0            tcp dpt:443 /* sm:laptop:up */
+     300    90000            tcp  --  *      *       0.0.0.0/0            0.0.0.0/0            tcp spt:443 /* sm:laptop:down */
+      10      700            tcp  --  *      *       0.0.0.0/0            0.0.0.0/0            tcp dpt:8443 /* sm:ci:up */
+`
+	got := parseAcctCounters([]byte(out))
+	if got["laptop"].Upload != 5000 || got["laptop"].Download != 90000 {
+		t.Errorf("laptop counters = %+v", got["laptop"])
+	}
+	if got["ci"].Upload != 700 || got["ci"].Download != 0 {
+		t.Errorf("ci counters = %+v", got["ci"])
+	}
+}
+
+func TestQuotaService_TokenExpiry(t *testing.T) {
+	cfg := &config.Config{Tokens: []config.Token{
+		{Value: "past", Expires: time.Now().Add(-time.Minute)},
+		{Value: "future", Expires: time.Now().Add(time.Hour)},
+		{Value: "never"},
+	}}
+	s := NewQuotaService(cfg)
+	for token, want := range map[string]bool{"past": true, "future": false, "never": false} {
+		if got := s.Expired(token); got != want {
+			t.Errorf("Expired(%q) = %v, want %v", token, got, want)
+		}
+	}
+}
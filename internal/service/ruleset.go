@@ -10,9 +10,13 @@ import (
 	"os"
 	"path/filepath"
 	"server-master/internal/config"
+	"server-master/internal/metrics"
+	"server-master/pkg/compress"
+	"server-master/pkg/ruleset"
 	"server-master/pkg/utils"
 	"sort"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/sync/errgroup"
@@ -20,17 +24,23 @@ import (
 )
 
 type RulesetService struct {
-	cfg        *config.Config
+	cfg        atomic.Pointer[config.Config]
 	httpClient *http.Client
 }
 
 func NewRulesetService(cfg *config.Config) *RulesetService {
-	return &RulesetService{
-		cfg: cfg,
+	s := &RulesetService{
 		httpClient: &http.Client{
 			Timeout: 30 * time.Second, // Increased timeout for slow rule sources
 		},
 	}
+	s.cfg.Store(cfg)
+	return s
+}
+
+// Reload swaps in a new configuration. It takes effect on the next update.
+func (s *RulesetService) Reload(cfg *config.Config) {
+	s.cfg.Store(cfg)
 }
 
 type rules struct {
@@ -38,44 +48,42 @@ type rules struct {
 }
 
 // UpdateAll downloads and updates all configured rule sets
-func (s *RulesetService) UpdateAll() {
+func (s *RulesetService) UpdateAll() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
 	defer cancel()
 
-	c := s.cfg.Cron.RuleSet
+	c := s.cfg.Load().Cron.RuleSet
 	slog.Info("Starting rule-set update task")
 
 	// 1. Load rules in parallel
-	var dr, pr, rj rules
+	loaded := make([]rules, len(c.Categories))
 	g, ctx := errgroup.WithContext(ctx)
 
-	g.Go(func() error {
-		var err error
-		dr, err = s.loadRulesParallel(ctx, c.Direct, "direct")
-		return err
-	})
-	g.Go(func() error {
-		var err error
-		pr, err = s.loadRulesParallel(ctx, c.Proxy, "proxy")
-		return err
-	})
-	g.Go(func() error {
-		var err error
-		rj, err = s.loadRulesParallel(ctx, c.Reject, "reject")
-		return err
-	})
+	for i, cat := range c.Categories {
+		g.Go(func() error {
+			var err error
+			loaded[i], err = s.loadRulesParallel(ctx, cat.Sources, cat.Name)
+			return err
+		})
+	}
 
 	if err := g.Wait(); err != nil {
-		slog.Error("Rule-set update task failed", "error", err)
-		return
+		return fmt.Errorf("rule-set update failed: %w", err)
 	}
 
-	// 2. Process and write to files
-	s.parseAndWriteDirect(dr, "direct")
-	s.atomicWriteToFile(pr, "proxy")
-	s.atomicWriteToFile(rj, "reject")
+	// 2. Process and write to files. Categories without sources are left
+	// alone, their files may be maintained by hand.
+	for i, cat := range c.Categories {
+		if len(cat.Sources) > 0 {
+			s.writeCategory(loaded[i], cat.Name)
+			if legacyRuleFiles[cat.Name] {
+				s.removeRuleFile(cat.Name)
+			}
+		}
+	}
 
 	slog.Info("Rule-set update task completed")
+	return nil
 }
 
 func (s *RulesetService) loadRulesParallel(ctx context.Context, links []string, name string) (rules, error) {
@@ -117,6 +125,9 @@ func (s *RulesetService) loadRulesParallel(ctx context.Context, links []string,
 }
 
 func (s *RulesetService) fetchOne(ctx context.Context, link string, category string) ([]string, error) {
+	start := time.Now()
+	defer func() { metrics.RulesetDownloadDuration.WithLabelValues(category).Observe(time.Since(start).Seconds()) }()
+
 	var reader io.ReadCloser
 	if strings.HasPrefix(link, "http") {
 		req, err := http.NewRequestWithContext(ctx, "GET", link, nil)
@@ -139,38 +150,108 @@ func (s *RulesetService) fetchOne(ctx context.Context, link string, category str
 		reader = file
 	}
 	defer reader.Close()
-	var rs rules
-	if err := yaml.NewDecoder(reader).Decode(&rs); err != nil {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("read rule set failed (%s): %w", category, err)
+	}
+	payload, err := ruleset.ParseSource(data)
+	if err != nil {
 		return nil, fmt.Errorf("decode rule set failed (%s): %w", category, err)
 	}
-	return rs.Payload, nil
+	return payload, nil
 }
 
 func (s *RulesetService) atomicWriteToFile(rs rules, name string) {
-	path := filepath.Join(s.cfg.RulePath, name+".yaml")
-	tmpPath := path + ".tmp"
+	path := filepath.Join(s.cfg.Load().RulePath, name+".yaml")
 
-	file, err := os.Create(tmpPath)
+	data, err := yaml.Marshal(rs)
 	if err != nil {
-		slog.Error("Create temporary rule file failed", "path", tmpPath, "error", err)
+		slog.Error("Encode rule file failed", "path", path, "error", err)
 		return
 	}
+	if err := writeFileAtomic(path, data); err != nil {
+		slog.Error("Write rule file failed", "path", path, "error", err)
+		return
+	}
+	// Pre-compressed copies spare FileHandler from compressing per request.
+	if err := compress.WriteSidecars(path, data); err != nil {
+		slog.Warn("Failed to write compressed rule file", "path", path, "error", err)
+	}
+	s.compileRuleFile(rs, name)
+	metrics.RulesetRules.WithLabelValues(name).Set(float64(len(rs.Payload)))
+	slog.Debug("Updated rule file", "path", path)
+}
 
-	if err := yaml.NewEncoder(file).Encode(rs); err != nil {
-		file.Close()
-		slog.Error("Write to temporary rule file failed", "path", tmpPath, "error", err)
+// compileRuleFile writes the mrs and sing-box forms of rule files with
+// domain or ipcidr behavior next to the YAML file.
+func (s *RulesetService) compileRuleFile(rs rules, name string) {
+	behavior := ruleFileBehavior(name)
+	if !ruleset.Compilable(behavior) {
 		return
 	}
-	file.Close()
+	for _, format := range ruleset.Formats {
+		path := filepath.Join(s.cfg.Load().RulePath, name+ruleset.Ext(format))
+		data, err := ruleset.Compile(format, behavior, rs.Payload)
+		if err == nil {
+			err = writeFileAtomic(path, data)
+		}
+		if err != nil {
+			// A stale copy must not outlive its YAML source.
+			os.Remove(path)
+			compress.RemoveSidecars(path)
+			slog.Warn("Failed to compile rule file", "path", path, "error", err)
+			continue
+		}
+		if format == ruleset.SingBoxSource {
+			if err := compress.WriteSidecars(path, data); err != nil {
+				slog.Warn("Failed to write compressed rule file", "path", path, "error", err)
+			}
+		}
+	}
+}
 
+// legacyRuleFiles are the categories that used to be written to a single
+// <category>.yaml instead of one file per kind. Once the category has been
+// written in the current layout, the stale file is removed.
+var legacyRuleFiles = map[string]bool{"direct": true, "proxy": true, "reject": true}
+
+// removeRuleFile deletes a rule file with its compressed and compiled
+// copies, so that rules no longer in the sources stop being served.
+func (s *RulesetService) removeRuleFile(name string) {
+	base := filepath.Join(s.cfg.Load().RulePath, name)
+	paths := []string{base + ".yaml"}
+	for _, format := range ruleset.Formats {
+		paths = append(paths, base+ruleset.Ext(format))
+	}
+	for _, path := range paths {
+		err := os.Remove(path)
+		compress.RemoveSidecars(path)
+		if err == nil {
+			slog.Info("Removed stale rule file", "path", path)
+		} else if !os.IsNotExist(err) {
+			slog.Warn("Failed to remove rule file", "path", path, "error", err)
+		}
+	}
+	metrics.RulesetRules.DeleteLabelValues(name)
+}
+
+// writeFileAtomic replaces path with data through a temporary file, so
+// that readers never see a partial file.
+func writeFileAtomic(path string, data []byte) error {
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
 	if err := os.Rename(tmpPath, path); err != nil {
-		slog.Error("Failed to rename temporary rule file", "from", tmpPath, "to", path, "error", err)
-		return
+		os.Remove(tmpPath)
+		return err
 	}
-	slog.Debug("Updated rule file", "path", path)
+	return nil
 }
 
-func (s *RulesetService) parseAndWriteDirect(rs rules, name string) {
+// writeCategory classifies the rules of a category and writes those of
+// each kind to their own rule file.
+func (s *RulesetService) writeCategory(rs rules, name string) {
 	sets := map[string]utils.Set[string]{
 		"ip":      utils.NewSet[string](),
 		"domain":  utils.NewSet[string](),
@@ -186,8 +267,8 @@ func (s *RulesetService) parseAndWriteDirect(rs rules, name string) {
 		sets[category].Add(processed)
 	}
 
-	for suffix, set := range sets {
-		s.writeSetToRuleFile(set, fmt.Sprintf("%s-%s", name, suffix))
+	for kind, set := range sets {
+		s.writeSetToRuleFile(set, config.RuleFile(name, kind))
 	}
 }
 
@@ -241,6 +322,7 @@ func (s *RulesetService) categorizeRule(rule string) (string, string) {
 
 func (s *RulesetService) writeSetToRuleFile(set utils.Set[string], name string) {
 	if set.Size() == 0 {
+		s.removeRuleFile(name)
 		return
 	}
 	r := rules{Payload: set.ToSlice()}
@@ -255,9 +337,15 @@ func (s *RulesetService) Name() string {
 }
 
 func (s *RulesetService) Spec() string {
-	return s.cfg.Cron.RuleSet.Cycle
+	return s.cfg.Load().Cron.RuleSet.Cycle
 }
 
 func (s *RulesetService) Run() {
-	s.UpdateAll()
+	if err := s.UpdateAll(); err != nil {
+		slog.Error("Rule-set update task failed", "error", err)
+	}
+}
+
+func (s *RulesetService) RunErr() error {
+	return s.UpdateAll()
 }
@@ -1,7 +1,14 @@
 package service
 
 import (
+	"os"
+	"path/filepath"
+	"server-master/internal/config"
+	"server-master/pkg/compress"
+	"server-master/pkg/ruleset"
 	"testing"
+
+	"gopkg.in/yaml.v3"
 )
 
 func TestCategorizeRule(t *testing.T) {
@@ -49,3 +56,164 @@ func TestCategorizeRule(t *testing.T) {
 		})
 	}
 }
+
+func TestRulesetService_CompileRuleFile(t *testing.T) {
+	dir := t.TempDir()
+	s := NewRulesetService(&config.Config{RulePath: dir})
+
+	s.atomicWriteToFile(rules{Payload: []string{"+.example.com", "foo.org"}}, "direct-domain")
+	s.atomicWriteToFile(rules{Payload: []string{"DOMAIN-KEYWORD,google"}}, "direct-classic")
+
+	for _, format := range ruleset.Formats {
+		if _, err := os.Stat(filepath.Join(dir, "direct-domain"+ruleset.Ext(format))); err != nil {
+			t.Errorf("%s of direct-domain missing: %v", format, err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "direct-classic"+ruleset.Ext(format))); err == nil {
+			t.Errorf("%s written for a classical rule file", format)
+		}
+	}
+	if _, ok := NewFileService(&config.Config{RulePath: dir}).Encoded(filepath.Join(dir, "direct-domain.json"), compress.Gzip); !ok {
+		t.Error("sing-box source has no compressed sidecar")
+	}
+
+	// A payload that no longer compiles removes the stale copies.
+	s.atomicWriteToFile(rules{Payload: []string{"bad..com"}}, "direct-domain")
+	if _, err := os.Stat(filepath.Join(dir, "direct-domain.mrs")); !os.IsNotExist(err) {
+		t.Errorf("stale mrs file kept: %v", err)
+	}
+}
+
+func TestRulesetService_UpdateAll_Categories(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "streaming.txt")
+	payload := "payload:\n  - DOMAIN-SUFFIX,netflix.com\n  - IP-CIDR,23.246.0.0/18\n  - DOMAIN-KEYWORD,nflx\n"
+	if err := os.WriteFile(source, []byte(payload), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Sources need not be YAML.
+	adblock := filepath.Join(dir, "ads.txt")
+	if err := os.WriteFile(adblock, []byte("! Title: Ads\n||ads.example.com^\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{
+		RulePath: dir,
+		Cron: config.CronConfig{RuleSet: config.RuleSetConfig{
+			Categories: []config.RuleCategory{
+				{Name: "streaming", Sources: []string{source}},
+				{Name: "reject", Sources: []string{adblock}},
+				{Name: "ai"},
+			},
+		}},
+	}
+	if err := NewRulesetService(cfg).UpdateAll(); err != nil {
+		t.Fatalf("UpdateAll failed: %v", err)
+	}
+
+	want := map[string]string{
+		"streaming-domain.yaml":  "+.netflix.com",
+		"streaming-ip.yaml":      "23.246.0.0/18",
+		"streaming-classic.yaml": "DOMAIN-KEYWORD,nflx",
+		"reject-domain.yaml":     "+.ads.example.com",
+	}
+	for file, rule := range want {
+		data, err := os.ReadFile(filepath.Join(dir, file))
+		if err != nil {
+			t.Errorf("%s missing: %v", file, err)
+			continue
+		}
+		var rs rules
+		if err := yaml.Unmarshal(data, &rs); err != nil {
+			t.Fatal(err)
+		}
+		if len(rs.Payload) != 1 || rs.Payload[0] != rule {
+			t.Errorf("%s payload = %v, want [%s]", file, rs.Payload, rule)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "ai-domain.yaml")); err == nil {
+		t.Error("rule file written for a category without rules")
+	}
+}
+
+func TestRulesetService_UpdateAll_RemovesEmptyKinds(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "streaming.txt")
+	write := func(payload string) {
+		t.Helper()
+		if err := os.WriteFile(source, []byte(payload), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := &config.Config{
+		RulePath: dir,
+		Cron: config.CronConfig{RuleSet: config.RuleSetConfig{
+			Categories: []config.RuleCategory{{Name: "streaming", Sources: []string{source}}},
+		}},
+	}
+	s := NewRulesetService(cfg)
+
+	write("DOMAIN-SUFFIX,netflix.com\nIP-CIDR,23.246.0.0/18\n")
+	if err := s.UpdateAll(); err != nil {
+		t.Fatalf("UpdateAll failed: %v", err)
+	}
+	ipFiles := []string{
+		"streaming-ip.yaml", "streaming-ip.yaml.gz", "streaming-ip.yaml.zst",
+		"streaming-ip.mrs", "streaming-ip.srs",
+		"streaming-ip.json", "streaming-ip.json.gz", "streaming-ip.json.zst",
+	}
+	for _, file := range ipFiles {
+		if _, err := os.Stat(filepath.Join(dir, file)); err != nil {
+			t.Fatalf("%s missing after first update: %v", file, err)
+		}
+	}
+
+	// The IP rules are gone from the source: their files must go too.
+	write("DOMAIN-SUFFIX,netflix.com\n")
+	if err := s.UpdateAll(); err != nil {
+		t.Fatalf("UpdateAll failed: %v", err)
+	}
+	for _, file := range ipFiles {
+		if _, err := os.Stat(filepath.Join(dir, file)); !os.IsNotExist(err) {
+			t.Errorf("stale %s kept: %v", file, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "streaming-domain.mrs")); err != nil {
+		t.Errorf("domain rule file removed: %v", err)
+	}
+}
+
+func TestRulesetService_UpdateAll_RemovesLegacyFiles(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "proxy.txt")
+	if err := os.WriteFile(source, []byte("DOMAIN-SUFFIX,example.com\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Left behind by versions that wrote one file per category.
+	legacy := []string{"proxy.yaml", "proxy.yaml.gz", "proxy.yaml.zst", "reject.yaml"}
+	for _, file := range legacy {
+		if err := os.WriteFile(filepath.Join(dir, file), []byte("payload: []\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := &config.Config{
+		RulePath: dir,
+		Cron: config.CronConfig{RuleSet: config.RuleSetConfig{
+			Categories: []config.RuleCategory{{Name: "proxy", Sources: []string{source}}, {Name: "reject"}},
+		}},
+	}
+	if err := NewRulesetService(cfg).UpdateAll(); err != nil {
+		t.Fatalf("UpdateAll failed: %v", err)
+	}
+
+	for _, file := range legacy[:3] {
+		if _, err := os.Stat(filepath.Join(dir, file)); !os.IsNotExist(err) {
+			t.Errorf("legacy %s kept: %v", file, err)
+		}
+	}
+	// Categories without sources are not managed by the task.
+	if _, err := os.Stat(filepath.Join(dir, "reject.yaml")); err != nil {
+		t.Errorf("reject.yaml of an unmanaged category removed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "proxy-domain.yaml")); err != nil {
+		t.Errorf("proxy-domain.yaml missing: %v", err)
+	}
+}
@@ -4,13 +4,17 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
-	"net/http"
 	"os"
 	"server-master/internal/config"
+	"server-master/internal/metrics"
 	"server-master/internal/model"
+	"server-master/pkg/fetch"
 	"server-master/pkg/utils"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/sync/errgroup"
@@ -18,11 +22,14 @@ import (
 )
 
 type SubscriptionService struct {
-	cfg        *config.Config
-	queue      *utils.Queue[string]
-	httpClient *http.Client
-	tokens     utils.Set[string]
-	cache      *utils.SafeMap[string, any]
+	cfg     atomic.Pointer[config.Config]
+	queue   *utils.Queue[string]
+	health  *HealthService
+	fetcher *fetch.Fetcher
+	cache   *utils.SafeMap[string, any]
+
+	mu         sync.Mutex
+	refreshing map[string]bool // additions being refreshed in the background
 }
 
 type baseCacheEntry struct {
@@ -30,104 +37,86 @@ type baseCacheEntry struct {
 	modTime time.Time
 }
 
-type depCacheEntry struct {
-	data    *Dependency
-	expires time.Time
+// AdditionStatus reports the cached fetch result of one addition.
+type AdditionStatus struct {
+	GroupName string    `json:"group_name"`
+	URL       string    `json:"url"`
+	Cached    bool      `json:"cached"`
+	FetchedAt time.Time `json:"fetched_at,omitzero"`
+	CheckedAt time.Time `json:"checked_at,omitzero"`
+	Age       string    `json:"age,omitempty"`
+	OK        bool      `json:"ok"`
+	Stale     bool      `json:"stale"`
+	Proxies   int       `json:"proxies"`
+	Error     string    `json:"error,omitempty"`
 }
 
-func NewSubscriptionService(cfg *config.Config, queue *utils.Queue[string]) *SubscriptionService {
-	tokenSet := utils.NewSet[string]()
-	tokenSet.AddAll(cfg.Tokens)
+// additionResult is the filtered content of a single addition.
+type additionResult struct {
+	Proxies  []model.ClashProxy
+	Group    model.ClashProxyGroup
+	UserInfo string
+}
 
-	return &SubscriptionService{
-		cfg:    cfg,
-		queue:  queue,
-		tokens: tokenSet,
-		cache:  utils.NewSafeMap[string, any](),
-		httpClient: &http.Client{
-			Timeout: 10 * time.Second,
-		},
+func NewSubscriptionService(cfg *config.Config, queue *utils.Queue[string]) *SubscriptionService {
+	s := &SubscriptionService{
+		queue:      queue,
+		cache:      utils.NewSafeMap[string, any](),
+		refreshing: make(map[string]bool),
+		fetcher:    fetch.New(10 * time.Second),
 	}
+	s.cfg.Store(cfg)
+	return s
 }
 
+// Reload swaps in a new configuration and drops cached upstream results so
+// that changed additions are refetched or rebuilt from their snapshots.
+func (s *SubscriptionService) Reload(cfg *config.Config) {
+	s.cfg.Store(cfg)
+	s.cache.Clear()
+}
+
+// Dependency holds the fetched additions in configuration order. Results are
+// shared with the cache and must be merged with mergeAdditions.
 type Dependency struct {
-	Proxies      []model.ClashProxy
-	ProxyGroups  []model.ClashProxyGroup
+	Results      []*additionResult
 	PrependRules []string
 	UserInfo     string
 }
 
-func (s *SubscriptionService) GetDependencies(ctx context.Context) (*Dependency, error) {
-	// Check Cache first
-	if val, ok := s.cache.Get("deps"); ok {
-		entry := val.(depCacheEntry)
-		if time.Now().Before(entry.expires) {
-			return entry.data, nil
-		}
-	}
-
-	additions := s.cfg.Additions
+// GetDependencies returns the given additions in configuration order. A
+// cached result is served as long as it is younger than cache.max-stale and
+// is refreshed in the background once it is older than cache.ttl; only
+// additions without a usable result are fetched on the request path.
+func (s *SubscriptionService) GetDependencies(ctx context.Context, additions []config.Addition) (*Dependency, error) {
 	if len(additions) == 0 {
 		return &Dependency{UserInfo: "upload=0; download=0; total=0; expire=0"}, nil
 	}
 
-	dependency := &Dependency{}
-	var mu sync.Mutex
-	var userInfo string
+	results := make([]*additionResult, len(additions))
+	ttl, maxStale := s.cacheTimes()
 
 	g, ctx := errgroup.WithContext(ctx)
 	g.SetLimit(5)
 
-	for _, it := range additions {
-		g.Go(func() error {
-			req, err := http.NewRequestWithContext(ctx, "GET", it.URL, nil)
-			if err != nil {
-				slog.Error("Failed to create request for subscription", "url", it.URL, "error", err)
-				return nil
-			}
-			ua := it.UserAgent
-			if ua == "" {
-				ua = "Clash"
+	for i, it := range additions {
+		now := time.Now()
+		entry, ok := s.lookupAddition(it)
+		if ok && entry.usable(now, maxStale) {
+			results[i] = entry.data
+			if now.Sub(entry.checkedAt) >= ttl {
+				s.refreshInBackground(it)
 			}
-			req.Header.Set("User-Agent", ua)
-
-			resp, err := s.httpClient.Do(req)
-			if err != nil {
-				slog.Error("Failed to fetch subscription", "url", it.URL, "error", err)
-				return nil
-			}
-			defer resp.Body.Close()
-
-			if resp.StatusCode != http.StatusOK {
-				slog.Error("Subscription returned non-OK status", "url", it.URL, "status", resp.Status)
-				return nil
-			}
-
-			var data model.ClashConfig
-			if err := yaml.NewDecoder(resp.Body).Decode(&data); err != nil {
-				slog.Error("Failed to decode subscription YAML", "url", it.URL, "error", err)
-				return nil
-			}
-
-			mu.Lock()
-			defer mu.Unlock()
-
-			dependency.Proxies = append(dependency.Proxies, data.Proxies...)
-			group := model.ClashProxyGroup{
-				Name:    it.GroupName,
-				Type:    it.GroupType,
-				Proxies: make([]string, 0, len(data.Proxies)),
-			}
-			for _, p := range data.Proxies {
-				group.Proxies = append(group.Proxies, p.Name)
-			}
-			dependency.ProxyGroups = append(dependency.ProxyGroups, group)
-			dependency.PrependRules = append(dependency.PrependRules, it.PrependRules...)
+			continue
+		}
+		if ok && now.Sub(entry.checkedAt) < ttl {
+			// Failed recently and nothing left to serve.
+			continue
+		}
 
-			if info := resp.Header.Get("Subscription-Userinfo"); info != "" {
-				if userInfo == "" || (len(info) > len(userInfo)) {
-					userInfo = info
-				}
+		g.Go(func() error {
+			if entry := s.refreshAddition(ctx, it); entry.err == nil {
+				results[i] = entry.data
 			}
 			return nil
 		})
@@ -135,28 +124,123 @@ func (s *SubscriptionService) GetDependencies(ctx context.Context) (*Dependency,
 
 	_ = g.Wait()
 
+	dependency := &Dependency{}
+	var userInfo string
+	for i, res := range results {
+		if res == nil {
+			continue
+		}
+		dependency.Results = append(dependency.Results, res)
+		dependency.PrependRules = append(dependency.PrependRules, additions[i].PrependRules...)
+
+		if info := res.UserInfo; info != "" {
+			if userInfo == "" || (len(info) > len(userInfo)) {
+				userInfo = info
+			}
+		}
+	}
+
 	if userInfo == "" {
 		userInfo = "upload=0; download=0; total=0; expire=0"
 	}
 	dependency.UserInfo = userInfo
 
-	// Update Cache
-	s.cache.Set("deps", depCacheEntry{
-		data:    dependency,
-		expires: time.Now().Add(5 * time.Minute),
-	})
-
 	return dependency, nil
 }
 
-func (s *SubscriptionService) getBaseConfig() (*model.ClashConfig, error) {
-	info, err := os.Stat(s.cfg.ProxyPath)
+// fetchAddition downloads the addition's subscription without filtering it.
+func (s *SubscriptionService) fetchAddition(ctx context.Context, it config.Addition) (*additionSnapshot, error) {
+	ua := it.UserAgent
+	if ua == "" {
+		ua = "Clash"
+	}
+	resp, err := s.fetcher.Fetch(ctx, it.URL, ua, it.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := model.ParseSubscription(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode subscription: %w", err)
+	}
+
+	return &additionSnapshot{
+		GroupName: it.GroupName,
+		URL:       it.URL,
+		FetchedAt: time.Now(),
+		UserInfo:  resp.Header.Get("Subscription-Userinfo"),
+		Proxies:   data.Proxies,
+	}, nil
+}
+
+// buildAddition applies the addition's filters to the upstream proxies and
+// builds its proxy group.
+func buildAddition(it config.Addition, snap *additionSnapshot) (*additionResult, error) {
+	proxies, err := filterProxies(snap.Proxies, it)
+	if err != nil {
+		return nil, err
+	}
+
+	group := model.ClashProxyGroup{
+		Name:    it.GroupName,
+		Type:    it.GroupType,
+		Proxies: make([]string, 0, len(proxies)),
+	}
+	for _, p := range proxies {
+		group.Proxies = append(group.Proxies, p.Name)
+	}
+
+	return &additionResult{
+		Proxies:  proxies,
+		Group:    group,
+		UserInfo: snap.UserInfo,
+	}, nil
+}
+
+// AdditionStatus reports the cache state of every configured addition.
+func (s *SubscriptionService) AdditionStatus() []AdditionStatus {
+	additions := s.cfg.Load().Additions
+	out := make([]AdditionStatus, 0, len(additions))
+	now := time.Now()
+	ttl, maxStale := s.cacheTimes()
+	for _, it := range additions {
+		st := AdditionStatus{GroupName: it.GroupName, URL: it.URL}
+		if val, ok := s.cache.Get(depCacheKey(it)); ok {
+			entry := val.(depCacheEntry)
+			st.Cached = true
+			st.CheckedAt = entry.checkedAt
+			st.OK = entry.usable(now, maxStale)
+			if entry.data != nil {
+				st.FetchedAt = entry.fetchedAt
+				st.Age = now.Sub(entry.fetchedAt).Round(time.Second).String()
+				st.Stale = now.Sub(entry.fetchedAt) >= ttl
+				st.Proxies = len(entry.data.Proxies)
+			}
+			if entry.err != nil {
+				st.Error = entry.err.Error()
+			}
+		}
+		out = append(out, st)
+	}
+	return out
+}
+
+// FlushCache drops all cached base configs and addition results. Snapshots
+// on disk are kept and reloaded on the next request.
+func (s *SubscriptionService) FlushCache() {
+	s.cache.Clear()
+	slog.Info("Subscription cache flushed")
+}
+
+func (s *SubscriptionService) getBaseConfig(path string) (*model.ClashConfig, error) {
+	info, err := os.Stat(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to stat base proxy file: %w", err)
 	}
 
 	// Check cache
-	if val, ok := s.cache.Get("base"); ok {
+	key := "base:" + path
+	if val, ok := s.cache.Get(key); ok {
 		entry := val.(baseCacheEntry)
 		if entry.modTime.Equal(info.ModTime()) {
 			return entry.data.Clone(), nil
@@ -164,7 +248,7 @@ func (s *SubscriptionService) getBaseConfig() (*model.ClashConfig, error) {
 	}
 
 	// Load and parse
-	data, err := os.ReadFile(s.cfg.ProxyPath)
+	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read base proxy file: %w", err)
 	}
@@ -175,7 +259,7 @@ func (s *SubscriptionService) getBaseConfig() (*model.ClashConfig, error) {
 	}
 
 	// Update cache
-	s.cache.Set("base", baseCacheEntry{
+	s.cache.Set(key, baseCacheEntry{
 		data:    &proxy,
 		modTime: info.ModTime(),
 	})
@@ -183,16 +267,48 @@ func (s *SubscriptionService) getBaseConfig() (*model.ClashConfig, error) {
 	return proxy.Clone(), nil
 }
 
-func (s *SubscriptionService) GenerateConfig(ctx context.Context) (*model.ClashConfig, string, error) {
+// GenerateConfig builds the subscription for the profile selected by token.
+func (s *SubscriptionService) GenerateConfig(ctx context.Context, token string) (*model.ClashConfig, string, error) {
+	start := time.Now()
+	defer func() { metrics.GenerateDuration.Observe(time.Since(start).Seconds()) }()
+
+	cfg := s.cfg.Load()
+	t, _ := cfg.Token(token)
+	profile := cfg.Profile(t.Profile)
+
 	// 1. Get base config (with ModTime caching)
-	proxy, err := s.getBaseConfig()
+	proxy, err := s.getBaseConfig(profile.ProxyPath)
 	if err != nil {
 		return nil, "", err
 	}
 
-	// 2. Randomize ports if queue is available
-	if s.queue != nil && !s.queue.IsEmpty() {
-		for i := range proxy.Proxies {
+	local := len(proxy.Proxies)
+
+	// 2. Get external dependencies (with TTL caching)
+	dp, err := s.GetDependencies(ctx, cfg.SelectAdditions(profile))
+	if err != nil {
+		return nil, "", err
+	}
+
+	if dp != nil {
+		mergeAdditions(proxy, dp.Results, cfg.Dedup)
+		proxy.Rules = slices.Concat(profile.PrependRules, dp.PrependRules, proxy.Rules)
+	}
+
+	// 3. Build the configured proxy groups from all merged proxies
+	generateGroups(proxy, cfg.ProxyGroups)
+
+	// 4. Reference the rule files served by /file, right after the
+	// prepended rules
+	if cfg.RuleProviders.Enable && dp != nil {
+		at := len(profile.PrependRules) + len(dp.PrependRules)
+		proxy.Rules = slices.Insert(proxy.Rules, at, addRuleProviders(ctx, cfg, proxy)...)
+	}
+
+	// 5. Randomize local ports if queue is available. This runs after the
+	// merge so that deduplication sees the real ports.
+	if *profile.DynamicPort && s.queue != nil && !s.queue.IsEmpty() {
+		for i := range proxy.Proxies[:local] {
 			portStr := s.queue.Rand()
 			if portStr != "" {
 				if port, err := strconv.Atoi(portStr); err == nil {
@@ -202,25 +318,127 @@ func (s *SubscriptionService) GenerateConfig(ctx context.Context) (*model.ClashC
 		}
 	}
 
-	// 3. Get external dependencies (with TTL caching)
-	dp, err := s.GetDependencies(ctx)
-	if err != nil {
-		return nil, "", err
+	// 6. Exclude nodes that failed their health checks
+	if s.health != nil {
+		s.health.Apply(proxy)
 	}
 
-	if dp != nil {
-		proxy.Proxies = append(proxy.Proxies, dp.Proxies...)
-		proxy.ProxyGroups = append(proxy.ProxyGroups, dp.ProxyGroups...)
-		proxy.Rules = append(dp.PrependRules, proxy.Rules...)
+	// 7. Hide groups the profile does not expose
+	if len(profile.Groups) > 0 {
+		filterGroups(proxy, profile.Groups)
+	}
+
+	// 8. Sign the rule providers served by /file for this token
+	if t.Value != "" {
+		signRuleProviders(proxy, baseURLFrom(ctx, cfg.RuleProviders), t, profile)
 	}
 
 	return proxy, dp.UserInfo, nil
 }
 
+// filterGroups removes every proxy group not listed in visible, along with
+// references to it from other groups and rules that target it.
+func filterGroups(cfg *model.ClashConfig, visible []string) {
+	keep := utils.NewSet[string]()
+	keep.AddAll(visible)
+	removeGroups(cfg, func(name string) bool { return !keep.Has(name) })
+}
+
+// removeGroups deletes the groups for which drop returns true, along with
+// references to them from other groups and rules that target them.
+func removeGroups(cfg *model.ClashConfig, drop func(name string) bool) {
+	hidden := utils.NewSet[string]()
+	groups := cfg.ProxyGroups[:0]
+	for _, g := range cfg.ProxyGroups {
+		if drop(g.Name) {
+			hidden.Add(g.Name)
+		} else {
+			groups = append(groups, g)
+		}
+	}
+	cfg.ProxyGroups = groups
+	if hidden.Size() == 0 {
+		return
+	}
+
+	for i := range cfg.ProxyGroups {
+		cfg.ProxyGroups[i].Proxies = slices.DeleteFunc(cfg.ProxyGroups[i].Proxies, hidden.Has)
+	}
+	cfg.Rules = slices.DeleteFunc(cfg.Rules, func(rule string) bool {
+		return hidden.Has(rulePolicy(rule))
+	})
+}
+
+// rulePolicy extracts the target policy of a Clash rule line.
+func rulePolicy(rule string) string {
+	parts := strings.Split(rule, ",")
+	if len(parts) < 2 {
+		return ""
+	}
+	if strings.EqualFold(strings.TrimSpace(parts[0]), "MATCH") {
+		return strings.TrimSpace(parts[1])
+	}
+	if len(parts) < 3 {
+		return ""
+	}
+	return strings.TrimSpace(parts[2])
+}
+
+// ValidateToken reports whether token grants access to a subscription.
 func (s *SubscriptionService) ValidateToken(token string) bool {
-	return s.tokens.Has(token)
+	_, ok := s.cfg.Load().Token(token)
+	return ok
+}
+
+// ProbeTargets returns every proxy that any profile may receive: the base
+// proxy files of all profiles and all additions, under their upstream
+// names. HealthService keys the results by endpoint.
+func (s *SubscriptionService) ProbeTargets(ctx context.Context) []model.ClashProxy {
+	cfg := s.cfg.Load()
+
+	paths := []string{cfg.ProxyPath}
+	for name := range cfg.Profiles {
+		paths = append(paths, cfg.Profile(name).ProxyPath)
+	}
+	slices.Sort(paths)
+	paths = slices.Compact(paths)
+
+	var targets []model.ClashProxy
+	seen := utils.NewSet[string]()
+	for _, path := range paths {
+		if seen.Has(path) {
+			continue
+		}
+		seen.Add(path)
+		base, err := s.getBaseConfig(path)
+		if err != nil {
+			slog.Warn("Failed to load base config for health check", "path", path, "error", err)
+			continue
+		}
+		targets = append(targets, base.Proxies...)
+	}
+	if dp, err := s.GetDependencies(ctx, cfg.Additions); err == nil {
+		for _, res := range dp.Results {
+			targets = append(targets, res.Proxies...)
+		}
+	}
+	return targets
+}
+
+// TokenLabel returns a name for token that is safe to expose, e.g. in
+// metrics: the configured name, or "unnamed" for tokens without one.
+func (s *SubscriptionService) TokenLabel(token string) string {
+	t, ok := s.cfg.Load().Token(token)
+	switch {
+	case !ok:
+		return "unknown"
+	case t.Name == "":
+		return "unnamed"
+	default:
+		return t.Name
+	}
 }
 
 func (s *SubscriptionService) GetConfig() config.SubscriptionConfig {
-	return s.cfg.Subscription
+	return s.cfg.Load().Subscription
 }
@@ -2,18 +2,23 @@ package service
 
 import (
 	"context"
+	"encoding/base64"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"server-master/internal/config"
 	"server-master/pkg/utils"
+	"slices"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestSubscriptionService_ValidateToken(t *testing.T) {
 	cfg := &config.Config{
-		Tokens: []string{"token1", "token2"},
+		Tokens: []config.Token{{Value: "token1"}, {Value: "token2", Profile: "default"}},
 	}
 	s := NewSubscriptionService(cfg, nil)
 
@@ -55,13 +60,13 @@ rules: []`
 				GroupType: "select",
 			},
 		},
-		Tokens: []string{"test"},
+		Tokens: []config.Token{{Value: "test"}},
 	}
 
 	s := NewSubscriptionService(cfg, utils.NewQueue[string](10))
 	
 	ctx := context.Background()
-	config, userInfo, err := s.GenerateConfig(ctx)
+	config, userInfo, err := s.GenerateConfig(ctx, "test")
 	if err != nil {
 		t.Fatalf("GenerateConfig failed: %v", err)
 	}
@@ -89,3 +94,292 @@ rules: []`
 		t.Errorf("unexpected proxy groups: %+v", config.ProxyGroups)
 	}
 }
+
+func TestSubscriptionService_GenerateConfig_LinkList(t *testing.T) {
+	tempDir := t.TempDir()
+	proxyPath := filepath.Join(tempDir, "proxy.yaml")
+	if err := os.WriteFile(proxyPath, []byte(`proxies: []`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	links := "trojan://pw@a.example.com:443#LinkA\nvless://uuid@b.example.com:443?security=tls#LinkB"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(base64.StdEncoding.EncodeToString([]byte(links))))
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		ProxyPath: proxyPath,
+		Additions: []config.Addition{
+			{URL: server.URL, GroupName: "Links", GroupType: "select"},
+		},
+	}
+
+	s := NewSubscriptionService(cfg, nil)
+	config, _, err := s.GenerateConfig(context.Background(), "")
+	if err != nil {
+		t.Fatalf("GenerateConfig failed: %v", err)
+	}
+
+	if len(config.Proxies) != 2 {
+		t.Fatalf("expected 2 proxies from link list, got %d", len(config.Proxies))
+	}
+	if config.Proxies[1].Extra.String("uuid") != "uuid" {
+		t.Errorf("vless uuid not carried over: %+v", config.Proxies[1])
+	}
+	if len(config.ProxyGroups) != 1 || len(config.ProxyGroups[0].Proxies) != 2 {
+		t.Errorf("unexpected proxy groups: %+v", config.ProxyGroups)
+	}
+
+	status := s.AdditionStatus()
+	if len(status) != 1 || !status[0].Cached || !status[0].OK || status[0].Proxies != 2 {
+		t.Errorf("unexpected addition status: %+v", status)
+	}
+	s.FlushCache()
+	if s.AdditionStatus()[0].Cached {
+		t.Error("expected cache to be empty after flush")
+	}
+}
+
+func TestSubscriptionService_GetDependencies_StaleCache(t *testing.T) {
+	var failing atomic.Bool
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if failing.Load() {
+			http.Error(w, "upstream down", http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte("proxies:\n  - {name: A, type: ss, server: a.example.com, port: 443}\n"))
+	}))
+	defer server.Close()
+
+	it := config.Addition{URL: server.URL, GroupName: "Remote", GroupType: "select"}
+	cfg := &config.Config{
+		Additions: []config.Addition{it},
+		Cache:     config.CacheConfig{Dir: t.TempDir(), TTL: 300, MaxStale: 86400},
+	}
+	ctx := context.Background()
+
+	// age shifts the cached entry into the past.
+	age := func(s *SubscriptionService, d time.Duration) {
+		val, _ := s.cache.Get(depCacheKey(it))
+		entry := val.(depCacheEntry)
+		entry.fetchedAt = entry.fetchedAt.Add(-d)
+		entry.checkedAt = entry.checkedAt.Add(-d)
+		s.cache.Set(depCacheKey(it), entry)
+	}
+
+	s := NewSubscriptionService(cfg, nil)
+	dep, err := s.GetDependencies(ctx, cfg.Additions)
+	if err != nil || len(dep.Results) != 1 {
+		t.Fatalf("initial fetch: %v, %+v", err, dep)
+	}
+
+	// An expired entry is served while the refresh fails in the background.
+	failing.Store(true)
+	age(s, 10*time.Minute)
+	dep, _ = s.GetDependencies(ctx, cfg.Additions)
+	if len(dep.Results) != 1 {
+		t.Fatalf("expected stale result to be served, got %d", len(dep.Results))
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for s.AdditionStatus()[0].Error == "" {
+		if time.Now().After(deadline) {
+			t.Fatal("background refresh did not run")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if st := s.AdditionStatus()[0]; !st.OK || !st.Stale || st.Proxies != 1 {
+		t.Errorf("unexpected status after failed refresh: %+v", st)
+	}
+
+	// A restarted service serves the snapshot without contacting upstream.
+	before := hits.Load()
+	restarted := NewSubscriptionService(cfg, nil)
+	dep, _ = restarted.GetDependencies(ctx, cfg.Additions)
+	if len(dep.Results) != 1 || dep.Results[0].Proxies[0].Name != "A" {
+		t.Fatalf("expected snapshot to be served after restart, got %+v", dep.Results)
+	}
+	if hits.Load() != before {
+		t.Error("fresh snapshot should not trigger a fetch")
+	}
+
+	// Past max-stale the addition is dropped if upstream is still down.
+	age(restarted, 48*time.Hour)
+	dep, _ = restarted.GetDependencies(ctx, cfg.Additions)
+	if len(dep.Results) != 0 {
+		t.Errorf("expected addition past max-stale to be dropped, got %d results", len(dep.Results))
+	}
+}
+
+func TestSubscriptionService_GenerateConfig_Profiles(t *testing.T) {
+	tempDir := t.TempDir()
+	proxyPath := filepath.Join(tempDir, "proxy.yaml")
+	familyPath := filepath.Join(tempDir, "family.yaml")
+
+	baseProxy := `proxies: [{name: "base", type: "ss", port: 443}]
+proxy-groups:
+  - {name: "Proxy", type: select, proxies: ["Internal", "base"]}
+  - {name: "Internal", type: select, proxies: ["base"]}
+rules: ["DOMAIN,corp.example.com,Internal", "MATCH,Proxy"]`
+	if err := os.WriteFile(proxyPath, []byte(baseProxy), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(familyPath, []byte(`proxies: [{name: "family", type: "ss"}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`proxies: [{name: "remote", type: "vmess"}]`))
+	}))
+	defer server.Close()
+
+	noDynamicPort := false
+	cfg := &config.Config{
+		ProxyPath: proxyPath,
+		Additions: []config.Addition{
+			{URL: server.URL, GroupName: "RemoteGroup", GroupType: "select"},
+		},
+		Tokens: []config.Token{
+			{Value: "laptop"},
+			{Value: "family", Profile: "family"},
+			{Value: "ci", Profile: "ci"},
+		},
+		Profiles: map[string]config.Profile{
+			"family": {ProxyPath: familyPath, Additions: []string{}},
+			"ci": {
+				Groups:       []string{"Proxy", "RemoteGroup"},
+				PrependRules: []string{"DOMAIN,ci.example.com,DIRECT"},
+				DynamicPort:  &noDynamicPort,
+			},
+		},
+		Cron: config.CronConfig{DynamicPort: config.DynamicPortConfig{Enable: true}},
+	}
+
+	queue := utils.NewQueue[string](1)
+	queue.Enqueue("20000")
+	s := NewSubscriptionService(cfg, queue)
+	ctx := context.Background()
+
+	laptop, _, err := s.GenerateConfig(ctx, "laptop")
+	if err != nil {
+		t.Fatalf("GenerateConfig(laptop) failed: %v", err)
+	}
+	if len(laptop.Proxies) != 2 || laptop.Proxies[0].Port != 20000 {
+		t.Errorf("laptop should get all proxies with dynamic ports: %+v", laptop.Proxies)
+	}
+
+	family, _, err := s.GenerateConfig(ctx, "family")
+	if err != nil {
+		t.Fatalf("GenerateConfig(family) failed: %v", err)
+	}
+	if len(family.Proxies) != 1 || family.Proxies[0].Name != "family" || len(family.ProxyGroups) != 0 {
+		t.Errorf("family should only get its own proxy file: %+v", family)
+	}
+
+	ci, _, err := s.GenerateConfig(ctx, "ci")
+	if err != nil {
+		t.Fatalf("GenerateConfig(ci) failed: %v", err)
+	}
+	if ci.Proxies[0].Port != 443 {
+		t.Errorf("ci disabled dynamic ports, got port %d", ci.Proxies[0].Port)
+	}
+	if len(ci.ProxyGroups) != 2 {
+		t.Errorf("ci should only see Proxy and RemoteGroup: %+v", ci.ProxyGroups)
+	}
+	if got := ci.ProxyGroups[0].Proxies; len(got) != 1 || got[0] != "base" {
+		t.Errorf("hidden group should be removed from members: %v", got)
+	}
+	want := []string{"DOMAIN,ci.example.com,DIRECT", "MATCH,Proxy"}
+	if len(ci.Rules) != len(want) || ci.Rules[0] != want[0] || ci.Rules[1] != want[1] {
+		t.Errorf("ci rules = %v, want %v", ci.Rules, want)
+	}
+}
+
+func TestSubscriptionService_GenerateConfig_RuleProviders(t *testing.T) {
+	tempDir := t.TempDir()
+	proxyPath := filepath.Join(tempDir, "proxy.yaml")
+	rulePath := filepath.Join(tempDir, "ruleset")
+
+	baseProxy := `proxies: [{name: "base", type: "ss", port: 443}]
+proxy-groups:
+  - {name: "Proxy", type: select, proxies: ["base"]}
+rule-providers:
+  reject-domain: {type: http, behavior: domain, url: "https://cdn.example.com/ads.yaml", path: ./ads.yaml}
+rules: ["RULE-SET,reject-domain,REJECT", "MATCH,Proxy"]`
+	if err := os.WriteFile(proxyPath, []byte(baseProxy), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(rulePath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"direct-domain.yaml", "direct-ip.yaml", "direct-ip.mrs", "proxy-classic.yaml", "reject-domain.yaml", "ai-domain.yaml"} {
+		if err := os.WriteFile(filepath.Join(rulePath, name), []byte("payload: []"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	noDynamicPort := false
+	cfg := &config.Config{
+		ProxyPath: proxyPath,
+		RulePath:  rulePath,
+		Tokens:    []config.Token{{Value: "test"}},
+		Profiles: map[string]config.Profile{
+			config.DefaultProfile: {PrependRules: []string{"DOMAIN,a.example.com,DIRECT"}, DynamicPort: &noDynamicPort},
+		},
+		Cron: config.CronConfig{RuleSet: config.RuleSetConfig{Categories: []config.RuleCategory{
+			{Name: "reject"}, {Name: "ai", Policy: "AI"}, {Name: "direct"}, {Name: "proxy"},
+		}}},
+		RuleProviders: config.RuleProvidersConfig{
+			Enable:       true,
+			Interval:     3600,
+			Format:       "mrs",
+			DirectPolicy: "DIRECT",
+			ProxyPolicy:  "Proxy",
+			RejectPolicy: "REJECT",
+		},
+	}
+	cfg.RuleProviders.Order = cfg.Cron.RuleSet.RuleFiles()
+	s := NewSubscriptionService(cfg, utils.NewQueue[string](10))
+
+	ctx := WithBaseURL(context.Background(), "https://sm.example.com/")
+	got, _, err := s.GenerateConfig(ctx, "test")
+	if err != nil {
+		t.Fatalf("GenerateConfig failed: %v", err)
+	}
+
+	wantRules := []string{
+		"DOMAIN,a.example.com,DIRECT",
+		"RULE-SET,direct-domain,DIRECT",
+		"RULE-SET,proxy-classic,Proxy",
+		"RULE-SET,direct-ip,DIRECT,no-resolve",
+		"RULE-SET,reject-domain,REJECT",
+		"MATCH,Proxy",
+	}
+	if !slices.Equal(got.Rules, wantRules) {
+		t.Errorf("Rules = %v, want %v", got.Rules, wantRules)
+	}
+
+	if rp := got.RuleProviders["reject-domain"]; rp.URL != "https://cdn.example.com/ads.yaml" {
+		t.Errorf("hand-written provider replaced: %+v", rp)
+	}
+	rp, ok := got.RuleProviders["direct-ip"]
+	if !ok {
+		t.Fatal("direct-ip provider missing")
+	}
+	if rp.Type != "http" || rp.Behavior != "ipcidr" || rp.Format != "mrs" || rp.Interval != 3600 || rp.Path != "./ruleset/direct-ip.mrs" ||
+		!strings.HasPrefix(rp.URL, "https://sm.example.com/file/direct-ip.mrs?") || !strings.Contains(rp.URL, "sig=") {
+		t.Errorf("unexpected direct-ip provider: %+v", rp)
+	}
+	// Without a compiled copy the YAML file is referenced.
+	if rp := got.RuleProviders["direct-domain"]; rp.Format != "yaml" || !strings.HasPrefix(rp.URL, "https://sm.example.com/file/direct-domain.yaml?") {
+		t.Errorf("unexpected direct-domain provider: %+v", rp)
+	}
+	if _, ok := got.RuleProviders["direct-classic"]; ok {
+		t.Error("provider added for a rule file that does not exist")
+	}
+	if _, ok := got.RuleProviders["ai-domain"]; ok {
+		t.Error("provider added for a category whose policy is not a proxy group")
+	}
+}
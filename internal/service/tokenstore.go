@@ -0,0 +1,271 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"server-master/internal/config"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrTokenStoreDisabled = errors.New("token store is not configured")
+	ErrStoredTokenMissing = errors.New("token not found")
+	ErrTokenRevoked       = errors.New("token already revoked")
+	ErrUnknownProfile     = errors.New("unknown profile")
+	// ErrTokenStoreNotApplied means the change was saved but reloading the
+	// configuration failed, so it is not in effect yet.
+	ErrTokenStoreNotApplied = errors.New("token store saved but not applied")
+)
+
+// StoredToken is a token managed at runtime. Only the sha256 hash of the
+// secret is kept; the secret is shown once when created or rotated.
+type StoredToken struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name,omitempty"`
+	Profile   string    `json:"profile,omitempty"`
+	Hash      string    `json:"hash"`
+	CreatedAt time.Time `json:"created_at"`
+	RotatedAt time.Time `json:"rotated_at,omitzero"`
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+	RevokedAt time.Time `json:"revoked_at,omitzero"`
+}
+
+// TokenSpec holds the settings of a new stored token.
+type TokenSpec struct {
+	Name      string    `json:"name"`
+	Profile   string    `json:"profile"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// TokenStore keeps subscription tokens in a JSON file next to config.yaml.
+// The active tokens are merged into the configuration, so a change takes
+// effect through a config reload.
+type TokenStore struct {
+	cfg atomic.Pointer[config.Config]
+
+	mu       sync.Mutex
+	onChange func() error
+}
+
+func NewTokenStore(cfg *config.Config) *TokenStore {
+	s := &TokenStore{}
+	s.cfg.Store(cfg)
+	return s
+}
+
+// Reload swaps in a new configuration.
+func (s *TokenStore) Reload(cfg *config.Config) {
+	s.cfg.Store(cfg)
+}
+
+// OnChange registers the function that applies a modified store.
+func (s *TokenStore) OnChange(f func() error) {
+	s.mu.Lock()
+	s.onChange = f
+	s.mu.Unlock()
+}
+
+// LoadTokens returns the tokens of the store at path that have not been
+// revoked, in the form merged into the configuration. A missing file holds
+// no tokens.
+func LoadTokens(path string) ([]config.Token, error) {
+	entries, err := readTokenStore(path)
+	if err != nil {
+		return nil, err
+	}
+	var out []config.Token
+	for _, e := range entries {
+		if !e.RevokedAt.IsZero() {
+			continue
+		}
+		out = append(out, config.Token{Value: e.Hash, Name: e.Name, Profile: e.Profile, Expires: e.ExpiresAt})
+	}
+	return out, nil
+}
+
+// List returns all stored tokens, including revoked ones, oldest first.
+func (s *TokenStore) List() ([]StoredToken, error) {
+	path := s.cfg.Load().TokenStore.Path
+	if path == "" {
+		return nil, ErrTokenStoreDisabled
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return readTokenStore(path)
+}
+
+// Create adds a token and returns its secret. The secret is also returned
+// with ErrTokenStoreNotApplied, as the token has been saved.
+func (s *TokenStore) Create(spec TokenSpec) (string, StoredToken, error) {
+	cfg := s.cfg.Load()
+	if spec.Profile != "" && spec.Profile != config.DefaultProfile {
+		if _, ok := cfg.Profiles[spec.Profile]; !ok {
+			return "", StoredToken{}, fmt.Errorf("%w %q", ErrUnknownProfile, spec.Profile)
+		}
+	}
+	secret, hash, err := newTokenSecret()
+	if err != nil {
+		return "", StoredToken{}, err
+	}
+	id := make([]byte, 4)
+	if _, err := rand.Read(id); err != nil {
+		return "", StoredToken{}, err
+	}
+	entry := StoredToken{
+		ID:        hex.EncodeToString(id),
+		Name:      spec.Name,
+		Profile:   spec.Profile,
+		Hash:      hash,
+		CreatedAt: time.Now(),
+		ExpiresAt: spec.ExpiresAt,
+	}
+
+	err = s.update(func(entries []StoredToken) ([]StoredToken, error) {
+		return append(entries, entry), nil
+	})
+	if err != nil && !errors.Is(err, ErrTokenStoreNotApplied) {
+		return "", StoredToken{}, err
+	}
+	slog.Info("Token created", "id", entry.ID, "name", entry.Name)
+	return secret, entry, err
+}
+
+// Revoke disables a token. It stays listed for reference.
+func (s *TokenStore) Revoke(id string) (StoredToken, error) {
+	var out StoredToken
+	err := s.update(func(entries []StoredToken) ([]StoredToken, error) {
+		e, err := findStoredToken(entries, id)
+		if err != nil {
+			return nil, err
+		}
+		e.RevokedAt = time.Now()
+		out = *e
+		return entries, nil
+	})
+	if err == nil || errors.Is(err, ErrTokenStoreNotApplied) {
+		slog.Info("Token revoked", "id", id, "name", out.Name)
+	}
+	return out, err
+}
+
+// Rotate replaces the secret of a token, keeping its other settings, and
+// returns the new secret. The old secret stops working immediately. As
+// with Create, the secret is also returned with ErrTokenStoreNotApplied.
+func (s *TokenStore) Rotate(id string) (string, StoredToken, error) {
+	secret, hash, err := newTokenSecret()
+	if err != nil {
+		return "", StoredToken{}, err
+	}
+	var out StoredToken
+	err = s.update(func(entries []StoredToken) ([]StoredToken, error) {
+		e, err := findStoredToken(entries, id)
+		if err != nil {
+			return nil, err
+		}
+		e.Hash = hash
+		e.RotatedAt = time.Now()
+		out = *e
+		return entries, nil
+	})
+	if err != nil && !errors.Is(err, ErrTokenStoreNotApplied) {
+		return "", StoredToken{}, err
+	}
+	slog.Info("Token rotated", "id", id, "name", out.Name)
+	return secret, out, err
+}
+
+// update applies f to the stored tokens, writes them back and applies the
+// change. If applying fails, the returned error wraps
+// ErrTokenStoreNotApplied.
+func (s *TokenStore) update(f func([]StoredToken) ([]StoredToken, error)) error {
+	path := s.cfg.Load().TokenStore.Path
+	if path == "" {
+		return ErrTokenStoreDisabled
+	}
+
+	s.mu.Lock()
+	entries, err := readTokenStore(path)
+	if err == nil {
+		entries, err = f(entries)
+	}
+	if err == nil {
+		err = writeTokenStore(path, entries)
+	}
+	onChange := s.onChange
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if onChange != nil {
+		if err := onChange(); err != nil {
+			return fmt.Errorf("%w: %w", ErrTokenStoreNotApplied, err)
+		}
+	}
+	return nil
+}
+
+func findStoredToken(entries []StoredToken, id string) (*StoredToken, error) {
+	for i := range entries {
+		if entries[i].ID != id {
+			continue
+		}
+		if !entries[i].RevokedAt.IsZero() {
+			return nil, ErrTokenRevoked
+		}
+		return &entries[i], nil
+	}
+	return nil, ErrStoredTokenMissing
+}
+
+// newTokenSecret returns a random token and its stored hash.
+func newTokenSecret() (secret, hash string, err error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	secret = base64.RawURLEncoding.EncodeToString(b)
+	hash, err = config.HashToken(secret, "sha256")
+	return secret, hash, err
+}
+
+func readTokenStore(path string) ([]StoredToken, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read token store: %w", err)
+	}
+	var entries []StoredToken
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to decode token store: %w", err)
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].CreatedAt.Before(entries[j].CreatedAt) })
+	return entries, nil
+}
+
+func writeTokenStore(path string, entries []StoredToken) error {
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"errors"
+	"path/filepath"
+	"server-master/internal/config"
+	"testing"
+	"time"
+)
+
+func TestTokenStore_Lifecycle(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	cfg := &config.Config{
+		TokenStore: config.TokenStoreConfig{Path: path},
+		Profiles:   map[string]config.Profile{"family": {}},
+	}
+	s := NewTokenStore(cfg)
+	changes := 0
+	s.OnChange(func() error { changes++; return nil })
+
+	// active returns the config a reload would produce.
+	active := func() *config.Config {
+		t.Helper()
+		tokens, err := LoadTokens(path)
+		if err != nil {
+			t.Fatalf("LoadTokens failed: %v", err)
+		}
+		return &config.Config{Tokens: tokens}
+	}
+
+	if _, _, err := s.Create(TokenSpec{Profile: "missing"}); !errors.Is(err, ErrUnknownProfile) {
+		t.Errorf("Create with unknown profile: err = %v, want ErrUnknownProfile", err)
+	}
+
+	expires := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+	secret, entry, err := s.Create(TokenSpec{Name: "phone", Profile: "family", ExpiresAt: expires})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	got, ok := active().Token(secret)
+	if !ok || got.Name != "phone" || got.Profile != "family" || !got.Expires.Equal(expires) {
+		t.Errorf("Token(secret) = %+v, %v; want the created token", got, ok)
+	}
+	if _, ok := active().Token(entry.Hash); ok {
+		t.Error("stored hash must not be accepted as a token")
+	}
+
+	newSecret, _, err := s.Rotate(entry.ID)
+	if err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+	if _, ok := active().Token(secret); ok {
+		t.Error("old secret still valid after rotation")
+	}
+	if _, ok := active().Token(newSecret); !ok {
+		t.Error("new secret not valid after rotation")
+	}
+
+	if _, err := s.Revoke(entry.ID); err != nil {
+		t.Fatalf("Revoke failed: %v", err)
+	}
+	if _, ok := active().Token(newSecret); ok {
+		t.Error("revoked token still valid")
+	}
+	if _, err := s.Revoke(entry.ID); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("second Revoke: err = %v, want ErrTokenRevoked", err)
+	}
+	if _, _, err := s.Rotate("nope"); !errors.Is(err, ErrStoredTokenMissing) {
+		t.Errorf("Rotate unknown id: err = %v, want ErrStoredTokenMissing", err)
+	}
+
+	entries, err := s.List()
+	if err != nil || len(entries) != 1 || entries[0].RevokedAt.IsZero() {
+		t.Errorf("List = %+v, %v; want one revoked token", entries, err)
+	}
+	if changes != 3 {
+		t.Errorf("OnChange called %d times, want 3", changes)
+	}
+
+	if _, err := NewTokenStore(&config.Config{}).List(); !errors.Is(err, ErrTokenStoreDisabled) {
+		t.Errorf("List without a path: err = %v, want ErrTokenStoreDisabled", err)
+	}
+}
+
+func TestTokenStore_ApplyFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	s := NewTokenStore(&config.Config{TokenStore: config.TokenStoreConfig{Path: path}})
+	reloadErr := errors.New("invalid config")
+	s.OnChange(func() error { return reloadErr })
+
+	secret, entry, err := s.Create(TokenSpec{Name: "phone"})
+	if !errors.Is(err, ErrTokenStoreNotApplied) || !errors.Is(err, reloadErr) {
+		t.Fatalf("Create: err = %v, want ErrTokenStoreNotApplied wrapping the reload error", err)
+	}
+	if secret == "" || entry.ID == "" {
+		t.Error("Create must return the saved token even if it was not applied")
+	}
+	if entries, _ := s.List(); len(entries) != 1 {
+		t.Errorf("stored %d tokens, want 1", len(entries))
+	}
+
+	if secret, _, err := s.Rotate(entry.ID); !errors.Is(err, ErrTokenStoreNotApplied) || secret == "" {
+		t.Errorf("Rotate = %q, %v; want the new secret with ErrTokenStoreNotApplied", secret, err)
+	}
+	if _, err := s.Revoke(entry.ID); !errors.Is(err, ErrTokenStoreNotApplied) {
+		t.Errorf("Revoke: err = %v, want ErrTokenStoreNotApplied", err)
+	}
+}
@@ -0,0 +1,162 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"server-master/internal/config"
+	"strconv"
+	"strings"
+)
+
+// Traffic is a pair of byte counters.
+type Traffic struct {
+	Upload   int64 `json:"upload"`
+	Download int64 `json:"download"`
+}
+
+// TrafficSource reports cumulative byte counters keyed by token name.
+// Counters may reset (after a reboot, say); QuotaService detects this and
+// keeps its own running totals. Sources may implement Initializer and Cleaner;
+// the counters of an Initializer start from zero after Init.
+type TrafficSource interface {
+	Collect(ctx context.Context) (map[string]Traffic, error)
+}
+
+// newTrafficSource builds the source selected by cron.traffic.source.
+func newTrafficSource(cfg *config.Config) TrafficSource {
+	switch cfg.Cron.Traffic.Source {
+	case "file":
+		return &fileTrafficSource{path: cfg.Cron.Traffic.SourcePath}
+	default:
+		return &iptablesTrafficSource{tokens: cfg.Tokens, ipt: &iptablesRunner{}}
+	}
+}
+
+// fileTrafficSource reads counters written by an external tool as
+// {"name": {"upload": 1, "download": 2}}.
+type fileTrafficSource struct {
+	path string
+}
+
+func (s *fileTrafficSource) Collect(ctx context.Context) (map[string]Traffic, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("read traffic file failed: %w", err)
+	}
+	var out map[string]Traffic
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, fmt.Errorf("decode traffic file failed: %w", err)
+	}
+	return out, nil
+}
+
+const acctChainName = "sm-traffic-acct"
+
+// iptablesTrafficSource counts bytes on each quota token's port with
+// accounting rules in a dedicated filter chain. Config.Validate ensures no
+// two quota tokens share a port.
+type iptablesTrafficSource struct {
+	tokens []config.Token
+	ipt    *iptablesRunner
+}
+
+func acctComment(name, dir string) string {
+	return "sm:" + name + ":" + dir
+}
+
+func (s *iptablesTrafficSource) Init() error {
+	// If the chain exists, flush it; otherwise, create it and link it.
+	if err := s.ipt.Run("-F", acctChainName); err != nil {
+		if err := s.ipt.Run("-N", acctChainName); err != nil {
+			return fmt.Errorf("failed to create chain %s: %w", acctChainName, err)
+		}
+		if err := s.ipt.Run("-I", "INPUT", "-j", acctChainName); err != nil {
+			return fmt.Errorf("failed to link %s chain to INPUT: %w", acctChainName, err)
+		}
+		if err := s.ipt.Run("-I", "OUTPUT", "-j", acctChainName); err != nil {
+			return fmt.Errorf("failed to link %s chain to OUTPUT: %w", acctChainName, err)
+		}
+	}
+
+	for _, t := range s.tokens {
+		if t.Quota == nil {
+			continue
+		}
+		port := strconv.Itoa(t.Quota.Port)
+		// Upload is what the client sends to the server port.
+		if err := s.ipt.Run("-A", acctChainName, "-p", "tcp", "--dport", port,
+			"-m", "comment", "--comment", acctComment(t.Name, "up")); err != nil {
+			return fmt.Errorf("failed to add upload counter for %s: %w", t.Name, err)
+		}
+		if err := s.ipt.Run("-A", acctChainName, "-p", "tcp", "--sport", port,
+			"-m", "comment", "--comment", acctComment(t.Name, "down")); err != nil {
+			return fmt.Errorf("failed to add download counter for %s: %w", t.Name, err)
+		}
+	}
+	return nil
+}
+
+func (s *iptablesTrafficSource) Collect(ctx context.Context) (map[string]Traffic, error) {
+	out, err := s.ipt.Output("-L", acctChainName, "-v", "-n", "-x")
+	if err != nil {
+		return nil, fmt.Errorf("list accounting chain failed: %w", err)
+	}
+	return parseAcctCounters(out), nil
+}
+
+// parseAcctCounters extracts the byte column of rules tagged with
+// acctComment from `iptables -L -v -n -x` output.
+func parseAcctCounters(out []byte) map[string]Traffic {
+	res := make(map[string]Traffic)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		start := strings.Index(line, "/* sm:")
+		if start == -1 {
+			continue
+		}
+		end := strings.Index(line[start:], " */")
+		if end == -1 {
+			continue
+		}
+		tag := strings.TrimPrefix(line[start:start+end], "/* sm:")
+		idx := strings.LastIndex(tag, ":")
+		if idx == -1 {
+			continue
+		}
+		name, dir := tag[:idx], tag[idx+1:]
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		n, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		t := res[name]
+		switch dir {
+		case "up":
+			t.Upload += n
+		case "down":
+			t.Download += n
+		}
+		res[name] = t
+	}
+	return res
+}
+
+func (s *iptablesTrafficSource) Cleanup() {
+	_ = s.ipt.Run("-D", "INPUT", "-j", acctChainName)
+	_ = s.ipt.Run("-D", "OUTPUT", "-j", acctChainName)
+	_ = s.ipt.Run("-F", acctChainName)
+	if err := s.ipt.Run("-X", acctChainName); err != nil {
+		slog.Error("Failed to delete accounting chain", "chain", acctChainName, "error", err)
+	}
+}
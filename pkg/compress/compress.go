@@ -0,0 +1,125 @@
+// Package compress negotiates HTTP content encodings and maintains
+// pre-compressed sidecar files.
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	Gzip = "gzip"
+	Zstd = "zstd"
+)
+
+// Encodings lists the supported encodings in order of preference.
+var Encodings = []string{Zstd, Gzip}
+
+// zstdEncoder is shared; EncodeAll is safe for concurrent use.
+var zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedBetterCompression))
+
+// Ext returns the file extension of sidecars in encoding.
+func Ext(encoding string) string {
+	switch encoding {
+	case Gzip:
+		return ".gz"
+	case Zstd:
+		return ".zst"
+	}
+	return ""
+}
+
+// Negotiate picks the encoding to use for a request with the given
+// Accept-Encoding header, or "" for none. Encodings with a higher q-value
+// win; ties go to the order of Encodings.
+func Negotiate(acceptEncoding string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+	quality := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		quality[name] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, enc := range Encodings {
+		q, ok := quality[enc]
+		if !ok {
+			q, ok = quality["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = enc, q
+		}
+	}
+	return best
+}
+
+// Encode compresses data in encoding.
+func Encode(encoding string, data []byte) ([]byte, error) {
+	switch encoding {
+	case Zstd:
+		return zstdEncoder.EncodeAll(data, nil), nil
+	case Gzip:
+		var buf bytes.Buffer
+		w, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	return nil, fmt.Errorf("unsupported encoding %q", encoding)
+}
+
+// WriteSidecars stores data, the new content of path, compressed in every
+// encoding next to it. Sidecars that cannot be written are removed so that
+// stale content is never served.
+func WriteSidecars(path string, data []byte) error {
+	var firstErr error
+	for _, enc := range Encodings {
+		sidecar := path + Ext(enc)
+		err := writeSidecar(sidecar, enc, data)
+		if err != nil {
+			os.Remove(sidecar)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// RemoveSidecars deletes the sidecars of path, if any.
+func RemoveSidecars(path string) {
+	for _, enc := range Encodings {
+		os.Remove(path + Ext(enc))
+	}
+}
+
+func writeSidecar(sidecar, encoding string, data []byte) error {
+	encoded, err := Encode(encoding, data)
+	if err != nil {
+		return err
+	}
+	tmpPath := sidecar + ".tmp"
+	if err := os.WriteFile(tmpPath, encoded, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, sidecar)
+}
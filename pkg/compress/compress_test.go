@@ -0,0 +1,81 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"gzip", Gzip},
+		{"gzip, deflate, br, zstd", Zstd},
+		{"zstd;q=0.5, gzip", Gzip},
+		{"zstd;q=0, gzip;q=0.1", Gzip},
+		{"GZIP", Gzip},
+		{"*", Zstd},
+		{"*, zstd;q=0", Gzip},
+		{"gzip;q=0", ""},
+	}
+	for _, tt := range tests {
+		if got := Negotiate(tt.header); got != tt.want {
+			t.Errorf("Negotiate(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func decode(t *testing.T, encoding string, data []byte) []byte {
+	t.Helper()
+	var r io.Reader
+	switch encoding {
+	case Gzip:
+		gr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = gr
+	case Zstd:
+		zr, err := zstd.NewReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zr.Close()
+		r = zr
+	}
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestWriteSidecars(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "direct-domain.yaml")
+	data := bytes.Repeat([]byte("  - +.example.com\n"), 1000)
+
+	if err := WriteSidecars(path, data); err != nil {
+		t.Fatalf("WriteSidecars failed: %v", err)
+	}
+	for _, enc := range Encodings {
+		encoded, err := os.ReadFile(path + Ext(enc))
+		if err != nil {
+			t.Fatalf("sidecar %s missing: %v", enc, err)
+		}
+		if len(encoded) >= len(data) {
+			t.Errorf("%s sidecar is %d bytes, not smaller than %d", enc, len(encoded), len(data))
+		}
+		if got := decode(t, enc, encoded); !bytes.Equal(got, data) {
+			t.Errorf("%s sidecar does not decode to the content", enc)
+		}
+	}
+}
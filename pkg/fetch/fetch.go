@@ -0,0 +1,194 @@
+// Package fetch downloads subscriptions with per-source transport options.
+package fetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Options configures how a single subscription source is fetched. It is
+// embedded inline in the addition configs of the server and the client.
+type Options struct {
+	// Timeout of each attempt in seconds; 0 uses the fetcher's default.
+	Timeout int `yaml:"timeout" json:"timeout"`
+	// Retries is the number of extra attempts after a network error or a
+	// 5xx / 429 response.
+	Retries int `yaml:"retries" json:"retries"`
+	// RetryBackoff is the delay in seconds before the first retry; it doubles
+	// on every further retry. Defaults to 1.
+	RetryBackoff int `yaml:"retry-backoff" json:"retry_backoff"`
+	// Proxy is an http://, https://, socks5:// or socks5h:// URL used to
+	// reach the source.
+	Proxy   string            `yaml:"proxy" json:"proxy"`
+	Headers map[string]string `yaml:"headers" json:"headers"`
+	// Username and Password are sent as HTTP basic auth.
+	Username string `yaml:"username" json:"username"`
+	Password string `yaml:"password" json:"-"`
+}
+
+// Validate checks the options of the source at rawURL.
+func (o *Options) Validate(rawURL string) error {
+	if o.Timeout < 0 || o.Retries < 0 || o.RetryBackoff < 0 {
+		return fmt.Errorf("timeout, retries and retry-backoff must not be negative")
+	}
+	if IsFile(rawURL) {
+		if o.Proxy != "" || len(o.Headers) > 0 || o.Username != "" {
+			return fmt.Errorf("proxy, headers and basic auth do not apply to file:// sources")
+		}
+		return nil
+	}
+	if o.Proxy != "" {
+		u, err := url.Parse(o.Proxy)
+		if err != nil {
+			return fmt.Errorf("invalid proxy: %w", err)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+		}
+	}
+	return nil
+}
+
+// IsFile reports whether rawURL refers to a local file.
+func IsFile(rawURL string) bool {
+	return strings.HasPrefix(rawURL, "file://")
+}
+
+// Response is a fetched subscription.
+type Response struct {
+	Body   []byte
+	Header http.Header
+}
+
+// Fetcher downloads subscriptions over HTTP(S) or from local files.
+type Fetcher struct {
+	// Timeout is the default timeout of each attempt.
+	Timeout time.Duration
+
+	// second is the unit of Options.RetryBackoff, shortened in tests.
+	second time.Duration
+
+	mu         sync.Mutex
+	transports map[string]*http.Transport // by proxy URL
+}
+
+func New(timeout time.Duration) *Fetcher {
+	return &Fetcher{
+		Timeout:    timeout,
+		second:     time.Second,
+		transports: make(map[string]*http.Transport),
+	}
+}
+
+// Fetch downloads rawURL. file:// URLs are read from disk, either as
+// file:///abs/path or as file://relative/path.
+func (f *Fetcher) Fetch(ctx context.Context, rawURL, userAgent string, opts Options) (*Response, error) {
+	if IsFile(rawURL) {
+		body, err := os.ReadFile(strings.TrimPrefix(rawURL, "file://"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read subscription file: %w", err)
+		}
+		return &Response{Body: body, Header: http.Header{}}, nil
+	}
+
+	client, err := f.client(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	backoff := time.Duration(max(opts.RetryBackoff, 1)) * f.second
+	for attempt := 0; ; attempt++ {
+		resp, err := f.do(ctx, client, rawURL, userAgent, opts)
+		var retry retryable
+		if err == nil || attempt >= opts.Retries || !errors.As(err, &retry) {
+			return resp, err
+		}
+
+		slog.Warn("Subscription fetch failed, retrying", "url", rawURL, "attempt", attempt+1, "delay", backoff, "error", err)
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// retryable marks errors worth another attempt.
+type retryable struct{ error }
+
+func (r retryable) Unwrap() error { return r.error }
+
+func (f *Fetcher) do(ctx context.Context, client *http.Client, rawURL, userAgent string, opts Options) (*Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	for k, v := range opts.Headers {
+		req.Header.Set(k, v)
+	}
+	if userAgent != "" {
+		req.Header.Set("User-Agent", userAgent)
+	}
+	if opts.Username != "" || opts.Password != "" {
+		req.SetBasicAuth(opts.Username, opts.Password)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("failed to fetch subscription: %w", err)
+		}
+		return nil, retryable{fmt.Errorf("failed to fetch subscription: %w", err)}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("subscription returned non-OK status: %s", resp.Status)
+		if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+			return nil, retryable{err}
+		}
+		return nil, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, retryable{fmt.Errorf("failed to read subscription body: %w", err)}
+	}
+	return &Response{Body: body, Header: resp.Header}, nil
+}
+
+func (f *Fetcher) client(opts Options) (*http.Client, error) {
+	timeout := f.Timeout
+	if opts.Timeout > 0 {
+		timeout = time.Duration(opts.Timeout) * time.Second
+	}
+	if opts.Proxy == "" {
+		return &http.Client{Timeout: timeout}, nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	transport, ok := f.transports[opts.Proxy]
+	if !ok {
+		proxyURL, err := url.Parse(opts.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy: %w", err)
+		}
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = http.ProxyURL(proxyURL)
+		f.transports[opts.Proxy] = transport
+	}
+	return &http.Client{Timeout: timeout, Transport: transport}, nil
+}
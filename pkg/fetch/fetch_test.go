@@ -0,0 +1,126 @@
+package fetch
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFetcher_Fetch(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		switch r.URL.Path {
+		case "/flaky":
+			if n < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+			return
+		case "/auth":
+			user, pass, ok := r.BasicAuth()
+			if !ok || user != "u" || pass != "p" || r.Header.Get("X-Token") != "abc" || r.UserAgent() != "Clash" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	f := New(0)
+	f.second = time.Millisecond
+	ctx := context.Background()
+
+	t.Run("retries server errors", func(t *testing.T) {
+		calls.Store(0)
+		resp, err := f.Fetch(ctx, server.URL+"/flaky", "", Options{Retries: 2})
+		if err != nil || string(resp.Body) != "ok" {
+			t.Fatalf("expected success after retries, got %v", err)
+		}
+		if calls.Load() != 3 {
+			t.Errorf("expected 3 attempts, got %d", calls.Load())
+		}
+	})
+
+	t.Run("gives up after retries", func(t *testing.T) {
+		calls.Store(0)
+		if _, err := f.Fetch(ctx, server.URL+"/flaky", "", Options{Retries: 1}); err == nil {
+			t.Fatal("expected error")
+		}
+		if calls.Load() != 2 {
+			t.Errorf("expected 2 attempts, got %d", calls.Load())
+		}
+	})
+
+	t.Run("does not retry client errors", func(t *testing.T) {
+		calls.Store(0)
+		if _, err := f.Fetch(ctx, server.URL+"/missing", "", Options{Retries: 3}); err == nil {
+			t.Fatal("expected error")
+		}
+		if calls.Load() != 1 {
+			t.Errorf("expected 1 attempt, got %d", calls.Load())
+		}
+	})
+
+	t.Run("headers and basic auth", func(t *testing.T) {
+		opts := Options{Headers: map[string]string{"X-Token": "abc"}, Username: "u", Password: "p"}
+		if _, err := f.Fetch(ctx, server.URL+"/auth", "Clash", opts); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("http proxy", func(t *testing.T) {
+		var proxied atomic.Bool
+		proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			proxied.Store(r.URL.Host != "")
+			w.Write([]byte("via proxy"))
+		}))
+		defer proxy.Close()
+
+		resp, err := f.Fetch(ctx, "http://upstream.invalid/sub", "", Options{Proxy: proxy.URL})
+		if err != nil || string(resp.Body) != "via proxy" || !proxied.Load() {
+			t.Fatalf("expected request through proxy, got %v", err)
+		}
+	})
+
+	t.Run("file source", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "sub.yaml")
+		if err := os.WriteFile(path, []byte("proxies: []"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := f.Fetch(ctx, "file://"+path, "", Options{})
+		if err != nil || string(resp.Body) != "proxies: []" {
+			t.Fatalf("unexpected file fetch result: %v", err)
+		}
+	})
+}
+
+func TestOptions_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		opts    Options
+		wantErr bool
+	}{
+		{"plain", "https://example.com", Options{}, false},
+		{"socks5 proxy", "https://example.com", Options{Proxy: "socks5://127.0.0.1:1080"}, false},
+		{"unsupported proxy", "https://example.com", Options{Proxy: "ftp://127.0.0.1"}, true},
+		{"negative retries", "https://example.com", Options{Retries: -1}, true},
+		{"file with headers", "file:///tmp/sub.yaml", Options{Headers: map[string]string{"A": "b"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.opts.Validate(tt.url); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package ruleset
+
+import (
+	"bytes"
+	"encoding/binary"
+	"server-master/pkg/compress"
+	"strings"
+)
+
+// mrsMagic starts every mrs file, version 1.
+var mrsMagic = [4]byte{'M', 'R', 'S', 1}
+
+// mrsBehavior is the behavior byte of the mrs header.
+var mrsBehavior = map[string]byte{Domain: 0, IPCIDR: 1}
+
+// compileMRS writes payload in mihomo's mrs format: a zstd stream holding
+// the header, the number of rules, an empty extra block and the domain set
+// or the address ranges.
+func compileMRS(behavior string, payload []string) ([]byte, error) {
+	var body bytes.Buffer
+	var count int
+	switch behavior {
+	case Domain:
+		var keys []string
+		keys, count = mrsDomainKeys(payload)
+		ss := newSuccinctSet(keys)
+		body.WriteByte(1) // domain set version
+		writeUint64s(&body, ss.leaves)
+		writeUint64s(&body, ss.labelBitmap)
+		binary.Write(&body, binary.BigEndian, int64(len(ss.labels)))
+		body.Write(ss.labels)
+	case IPCIDR:
+		var rr []ipRange
+		rr, count = parseRanges(payload)
+		body.WriteByte(1) // ip set version
+		binary.Write(&body, binary.BigEndian, int64(len(rr)))
+		for _, r := range rr {
+			from, to := r.from.As16(), r.to.As16()
+			body.Write(from[:])
+			body.Write(to[:])
+		}
+	}
+	if count == 0 {
+		return nil, ErrEmpty
+	}
+
+	var buf bytes.Buffer
+	buf.Write(mrsMagic[:])
+	buf.WriteByte(mrsBehavior[behavior])
+	binary.Write(&buf, binary.BigEndian, int64(count))
+	binary.Write(&buf, binary.BigEndian, int64(0)) // extra
+	buf.Write(body.Bytes())
+	return compress.Encode(compress.Zstd, buf.Bytes())
+}
+
+// mrsDomainKeys returns the reversed keys of mihomo's domain set for
+// payload and the number of valid entries. "+.example.com" is stored as
+// both "example.com" and "+.example.com", and ".example.com" as
+// "+.example.com".
+func mrsDomainKeys(payload []string) ([]string, int) {
+	var keys []string
+	count := 0
+	for _, s := range payload {
+		d, ok := parseDomain(s)
+		if !ok {
+			continue
+		}
+		count++
+		if rest, ok := strings.CutPrefix(d, "+."); ok {
+			keys = append(keys, reverse(rest), reverse(d))
+		} else if strings.HasPrefix(d, ".") {
+			keys = append(keys, reverse("+"+d))
+		} else {
+			keys = append(keys, reverse(d))
+		}
+	}
+	return keys, count
+}
+
+func writeUint64s(buf *bytes.Buffer, v []uint64) {
+	binary.Write(buf, binary.BigEndian, int64(len(v)))
+	binary.Write(buf, binary.BigEndian, v)
+}
@@ -0,0 +1,156 @@
+// Package ruleset reads rule sources of various formats and compiles
+// rule-set payloads of domain and ipcidr behavior into mihomo's binary
+// format (mrs) and sing-box's source and binary formats.
+package ruleset
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+// Behaviors that can be compiled.
+const (
+	Domain = "domain"
+	IPCIDR = "ipcidr"
+)
+
+// Output formats.
+const (
+	MRS           = "mrs"
+	SingBoxSource = "sing-box-source"
+	SingBoxBinary = "sing-box-binary"
+)
+
+// Formats lists every output format.
+var Formats = []string{MRS, SingBoxSource, SingBoxBinary}
+
+// ErrEmpty is returned when a payload has no valid entry for its behavior.
+var ErrEmpty = errors.New("no valid rules in payload")
+
+// Ext returns the file extension of a rule set compiled to format.
+func Ext(format string) string {
+	switch format {
+	case MRS:
+		return ".mrs"
+	case SingBoxSource:
+		return ".json"
+	case SingBoxBinary:
+		return ".srs"
+	}
+	return ""
+}
+
+// Compilable reports whether rule sets of behavior can be compiled.
+func Compilable(behavior string) bool {
+	return behavior == Domain || behavior == IPCIDR
+}
+
+// Compile converts payload, the entries of a YAML rule set of behavior, to
+// format. Entries that are not valid for the behavior are skipped.
+func Compile(format, behavior string, payload []string) ([]byte, error) {
+	if !Compilable(behavior) {
+		return nil, fmt.Errorf("behavior %q cannot be compiled", behavior)
+	}
+	switch format {
+	case MRS:
+		return compileMRS(behavior, payload)
+	case SingBoxSource:
+		return compileSingBoxSource(behavior, payload)
+	case SingBoxBinary:
+		return compileSingBoxBinary(behavior, payload)
+	}
+	return nil, fmt.Errorf("unsupported format %q", format)
+}
+
+// parseDomain normalizes an entry of a domain payload as mihomo does,
+// reporting false for entries it would reject. Besides plain domains,
+// "+.example.com" matches the domain and its subdomains, ".example.com"
+// only its subdomains and "*" a single label.
+func parseDomain(s string) (string, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" || s == "+" || strings.HasSuffix(s, ".") {
+		return "", false
+	}
+	labels := strings.Split(s, ".")
+	for _, l := range labels[1:] {
+		if l == "" {
+			return "", false
+		}
+	}
+	return s, true
+}
+
+// parsePrefix parses an entry of an ipcidr payload. Bare addresses are
+// taken as single-address prefixes.
+func parsePrefix(s string) (netip.Prefix, bool) {
+	s = strings.TrimSpace(s)
+	if p, err := netip.ParsePrefix(s); err == nil {
+		return p.Masked(), true
+	}
+	if a, err := netip.ParseAddr(s); err == nil && a.Zone() == "" {
+		return netip.PrefixFrom(a, a.BitLen()), true
+	}
+	return netip.Prefix{}, false
+}
+
+// ipRange is an inclusive range of addresses of one family.
+type ipRange struct {
+	from, to netip.Addr
+}
+
+// lastAddr returns the highest address in p.
+func lastAddr(p netip.Prefix) netip.Addr {
+	a := p.Addr().As16()
+	bits := p.Bits()
+	if p.Addr().Is4() {
+		bits += 96
+	}
+	for i := bits; i < 128; i++ {
+		a[i/8] |= 0x80 >> (i % 8)
+	}
+	last := netip.AddrFrom16(a)
+	if p.Addr().Is4() {
+		return last.Unmap()
+	}
+	return last
+}
+
+// parseRanges parses an ipcidr payload into sorted ranges, merging those
+// that overlap or touch, and returns them with the number of valid entries.
+func parseRanges(payload []string) ([]ipRange, int) {
+	var rr []ipRange
+	for _, s := range payload {
+		if p, ok := parsePrefix(s); ok {
+			rr = append(rr, ipRange{p.Addr(), lastAddr(p)})
+		}
+	}
+	count := len(rr)
+	sort.Slice(rr, func(i, j int) bool { return rr[i].from.Less(rr[j].from) })
+
+	var merged []ipRange
+	for _, r := range rr {
+		if n := len(merged); n > 0 {
+			cur := &merged[n-1]
+			if cur.to.BitLen() == r.from.BitLen() && (r.from.Compare(cur.to) <= 0 || cur.to.Next() == r.from) {
+				if r.to.Compare(cur.to) > 0 {
+					cur.to = r.to
+				}
+				continue
+			}
+		}
+		merged = append(merged, r)
+	}
+	return merged, count
+}
+
+// reverse reverses s rune by rune, as the domain tries of both clients do.
+func reverse(s string) string {
+	r := []rune(s)
+	for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+		r[i], r[j] = r[j], r[i]
+	}
+	return string(r)
+}
@@ -0,0 +1,260 @@
+package ruleset
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/netip"
+	"slices"
+	"sort"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// decodeSet lists the keys of a succinct set, reversed back.
+func decodeSet(leaves, labelBitmap []uint64, labels []byte) []string {
+	bit := func(bm []uint64, i int) bool { return i>>6 < len(bm) && bm[i>>6]&(1<<uint(i&63)) != 0 }
+	type edge struct {
+		label byte
+		child int
+	}
+	children := map[int][]edge{}
+	node, next, l := 0, 1, 0
+	for i := 0; node < next; i++ {
+		if bit(labelBitmap, i) {
+			node++
+			continue
+		}
+		children[node] = append(children[node], edge{labels[l], next})
+		l++
+		next++
+	}
+
+	var keys []string
+	var walk func(node int, prefix []byte)
+	walk = func(node int, prefix []byte) {
+		if bit(leaves, node) {
+			keys = append(keys, reverse(string(prefix)))
+		}
+		for _, e := range children[node] {
+			walk(e.child, append(slices.Clone(prefix), e.label))
+		}
+	}
+	walk(0, nil)
+	sort.Strings(keys)
+	return keys
+}
+
+type reader struct {
+	t *testing.T
+	r *bytes.Reader
+}
+
+func (r reader) byte() byte {
+	r.t.Helper()
+	b, err := r.r.ReadByte()
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	return b
+}
+
+func (r reader) int64() int64 {
+	r.t.Helper()
+	var v int64
+	if err := binary.Read(r.r, binary.BigEndian, &v); err != nil {
+		r.t.Fatal(err)
+	}
+	return v
+}
+
+func (r reader) uvarint() uint64 {
+	r.t.Helper()
+	v, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	return v
+}
+
+func (r reader) bytes(n int) []byte {
+	r.t.Helper()
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		r.t.Fatal(err)
+	}
+	return b
+}
+
+func (r reader) uint64s(n int) []uint64 {
+	r.t.Helper()
+	v := make([]uint64, n)
+	if err := binary.Read(r.r, binary.BigEndian, v); err != nil {
+		r.t.Fatal(err)
+	}
+	return v
+}
+
+func openMRS(t *testing.T, data []byte, behavior byte, count int64) reader {
+	t.Helper()
+	zr, err := zstd.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	raw, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := reader{t, bytes.NewReader(raw)}
+	if magic := r.bytes(4); !bytes.Equal(magic, mrsMagic[:]) {
+		t.Fatalf("magic = %q", magic)
+	}
+	if b := r.byte(); b != behavior {
+		t.Errorf("behavior = %d, want %d", b, behavior)
+	}
+	if n := r.int64(); n != count {
+		t.Errorf("count = %d, want %d", n, count)
+	}
+	if extra := r.int64(); extra != 0 {
+		t.Errorf("extra length = %d, want 0", extra)
+	}
+	if v := r.byte(); v != 1 {
+		t.Errorf("set version = %d, want 1", v)
+	}
+	return r
+}
+
+func TestCompileMRS_Domain(t *testing.T) {
+	payload := []string{"+.Example.com", "foo.org", ".sub.net", "*.wild.io", "bad..com", "trailing.", ""}
+	data, err := Compile(MRS, Domain, payload)
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	r := openMRS(t, data, 0, 4)
+	leaves := r.uint64s(int(r.int64()))
+	bitmap := r.uint64s(int(r.int64()))
+	labels := r.bytes(int(r.int64()))
+	got := decodeSet(leaves, bitmap, labels)
+	want := []string{"*.wild.io", "+.example.com", "+.sub.net", "example.com", "foo.org"}
+	if !slices.Equal(got, want) {
+		t.Errorf("domain set = %q, want %q", got, want)
+	}
+}
+
+func TestCompileMRS_IPCIDR(t *testing.T) {
+	payload := []string{"10.128.0.0/9", "10.0.0.0/9", "192.168.1.1", "2001:db8::/32", "10.1.0.0/16", "nonsense"}
+	data, err := Compile(MRS, IPCIDR, payload)
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	r := openMRS(t, data, 1, 5)
+	var got []string
+	for n := r.int64(); n > 0; n-- {
+		from := netip.AddrFrom16([16]byte(r.bytes(16))).Unmap()
+		to := netip.AddrFrom16([16]byte(r.bytes(16))).Unmap()
+		got = append(got, from.String()+"-"+to.String())
+	}
+	want := []string{
+		"10.0.0.0-10.255.255.255",
+		"192.168.1.1-192.168.1.1",
+		"2001:db8::-2001:db8:ffff:ffff:ffff:ffff:ffff:ffff",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("ranges = %q, want %q", got, want)
+	}
+}
+
+func TestCompileSingBoxSource(t *testing.T) {
+	data, err := Compile(SingBoxSource, Domain, []string{"+.example.com", "foo.org", ".sub.net", "+.*.wild.io"})
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	var rs singBoxRuleSet
+	if err := json.Unmarshal(data, &rs); err != nil {
+		t.Fatal(err)
+	}
+	want := singBoxRule{
+		Domain:       []string{"foo.org"},
+		DomainSuffix: []string{"example.com", ".sub.net"},
+		DomainRegex:  []string{`^(.+\.)?[^.]+\.wild\.io$`},
+	}
+	if rs.Version != 1 || len(rs.Rules) != 1 {
+		t.Fatalf("got version %d with %d rules", rs.Version, len(rs.Rules))
+	}
+	r := rs.Rules[0]
+	if !slices.Equal(r.Domain, want.Domain) || !slices.Equal(r.DomainSuffix, want.DomainSuffix) || !slices.Equal(r.DomainRegex, want.DomainRegex) {
+		t.Errorf("rule = %+v, want %+v", r, want)
+	}
+}
+
+func TestCompileSingBoxBinary(t *testing.T) {
+	data, err := Compile(SingBoxBinary, Domain, []string{"+.example.com", "foo.org", "*.wild.io"})
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	if !bytes.HasPrefix(data, []byte{'S', 'R', 'S', 1}) {
+		t.Fatalf("header = %q", data[:4])
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(data[4:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := reader{t, bytes.NewReader(raw)}
+	if n := r.uvarint(); n != 1 {
+		t.Fatalf("rule count = %d", n)
+	}
+	if typ := r.byte(); typ != 0 {
+		t.Fatalf("rule type = %d", typ)
+	}
+	if item := r.byte(); item != srsItemDomain {
+		t.Fatalf("item = %d, want domain", item)
+	}
+	if v := r.byte(); v != 1 {
+		t.Fatalf("matcher version = %d", v)
+	}
+	leaves := r.uint64s(int(r.uvarint()))
+	bitmap := r.uint64s(int(r.uvarint()))
+	labels := r.bytes(int(r.uvarint()))
+	got := decodeSet(leaves, bitmap, labels)
+	want := []string{"\r.example.com", "example.com", "foo.org"}
+	if !slices.Equal(got, want) {
+		t.Errorf("domain keys = %q, want %q", got, want)
+	}
+
+	if item := r.byte(); item != srsItemDomainRegex {
+		t.Fatalf("item = %d, want domain regex", item)
+	}
+	if n := r.uvarint(); n != 1 {
+		t.Fatalf("regex count = %d", n)
+	}
+	if re := string(r.bytes(int(r.uvarint()))); re != `^[^.]+\.wild\.io$` {
+		t.Errorf("regex = %q", re)
+	}
+	if final, invert := r.byte(), r.byte(); final != srsItemFinal || invert != 0 {
+		t.Errorf("trailer = %x %x", final, invert)
+	}
+}
+
+func TestCompile_Invalid(t *testing.T) {
+	if _, err := Compile(MRS, Domain, []string{"", "bad..com"}); !errors.Is(err, ErrEmpty) {
+		t.Errorf("empty payload: got %v, want ErrEmpty", err)
+	}
+	if _, err := Compile(SingBoxBinary, IPCIDR, []string{"example.com"}); !errors.Is(err, ErrEmpty) {
+		t.Errorf("payload without prefixes: got %v, want ErrEmpty", err)
+	}
+	if _, err := Compile(MRS, "classical", []string{"DOMAIN,example.com"}); err == nil {
+		t.Error("expected classical rule sets to be rejected")
+	}
+}
@@ -0,0 +1,192 @@
+package ruleset
+
+import (
+	"bufio"
+	"bytes"
+	"net/netip"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// yamlPayload detects YAML rule sets, the only kind with a payload key.
+var yamlPayload = regexp.MustCompile(`(?m)^payload:`)
+
+// ruleTypeAliases maps rule types of Surge, Loon and Quantumult X to their
+// Clash names.
+var ruleTypeAliases = map[string]string{
+	"HOST":         "DOMAIN",
+	"HOST-SUFFIX":  "DOMAIN-SUFFIX",
+	"HOST-KEYWORD": "DOMAIN-KEYWORD",
+	"IP6-CIDR":     "IP-CIDR6",
+	"DEST-PORT":    "DST-PORT",
+	"SRC-IP":       "SRC-IP-CIDR",
+}
+
+// unsupportedRuleTypes cannot be expressed in a Clash rule set.
+var unsupportedRuleTypes = map[string]bool{
+	"USER-AGENT": true,
+	"URL-REGEX":  true,
+	"DOMAIN-SET": true,
+	"RULE-SET":   true,
+}
+
+// ParseSource reads the rules of a rule source. Besides YAML documents with
+// a payload key it accepts line-based lists, recognizing each line by its
+// shape:
+//
+//	DOMAIN-SUFFIX,example.com     Clash, Surge, Loon or Quantumult X rule
+//	example.com, .example.com     plain domain, or domain set entry
+//	192.0.2.0/24, 192.0.2.1       plain CIDR or address
+//	0.0.0.0 ads.example.com       hosts entry
+//	||ads.example.com^            AdBlock / AdGuard domain rule
+//
+// Entries are normalized to Clash rules or domain payload entries; lines
+// that cannot be expressed as Clash rules, such as AdBlock exceptions and
+// cosmetic filters, are dropped.
+func ParseSource(data []byte) ([]string, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if yamlPayload.Match(data) {
+		var doc struct {
+			Payload []string `yaml:"payload"`
+		}
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+		return doc.Payload, nil
+	}
+
+	var payload []string
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for sc.Scan() {
+		payload = append(payload, parseLine(sc.Text())...)
+	}
+	return payload, sc.Err()
+}
+
+func parseLine(line string) []string {
+	line = strings.TrimSpace(line)
+	switch {
+	case line == "", strings.HasPrefix(line, "#"), strings.HasPrefix(line, "!"),
+		strings.HasPrefix(line, "//"), strings.HasPrefix(line, ";"), strings.HasPrefix(line, "["):
+		return nil
+	case strings.HasPrefix(line, "@@"), strings.Contains(line, "##"), strings.Contains(line, "#@#"):
+		// AdBlock exceptions and cosmetic filters
+		return nil
+	case strings.HasPrefix(line, "||"):
+		return parseAdBlock(line)
+	}
+	if i := strings.Index(line, " #"); i >= 0 {
+		line = strings.TrimSpace(line[:i])
+	}
+
+	if strings.Contains(line, ",") {
+		if rule, ok := parseRule(line); ok {
+			return []string{rule}
+		}
+		return nil
+	}
+	fields := strings.Fields(line)
+	if len(fields) > 1 {
+		return parseHosts(fields)
+	}
+	if p, err := netip.ParsePrefix(line); err == nil {
+		return []string{p.Masked().String()}
+	}
+	if a, err := netip.ParseAddr(line); err == nil && a.Zone() == "" {
+		return []string{netip.PrefixFrom(a, a.BitLen()).String()}
+	}
+	// In domain sets a leading dot includes the domain itself.
+	if rest, ok := strings.CutPrefix(line, "."); ok {
+		line = "+." + rest
+	}
+	if d, ok := parseDomain(line); ok && strings.Contains(d, ".") && plainDomain(strings.TrimPrefix(d, "+."), true) {
+		return []string{d}
+	}
+	return nil
+}
+
+// parseRule normalizes a "TYPE,value[,options]" rule. Logical rules are
+// kept as they are; for the others only the no-resolve option is kept,
+// since Quantumult X lists put a policy in its place.
+func parseRule(line string) (string, bool) {
+	parts := strings.Split(line, ",")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	kind := strings.ToUpper(parts[0])
+	if alias, ok := ruleTypeAliases[kind]; ok {
+		kind = alias
+	}
+	switch {
+	case kind == "AND" || kind == "OR" || kind == "NOT":
+		return line, true
+	case unsupportedRuleTypes[kind] || parts[1] == "":
+		return "", false
+	}
+	rule := kind + "," + parts[1]
+	for _, opt := range parts[2:] {
+		if strings.EqualFold(opt, "no-resolve") {
+			rule += ",no-resolve"
+		}
+	}
+	return rule, true
+}
+
+// parseHosts converts the host names of a hosts file entry to DOMAIN
+// rules. Names without a dot, such as localhost, are skipped.
+func parseHosts(fields []string) []string {
+	if _, err := netip.ParseAddr(fields[0]); err != nil {
+		return nil
+	}
+	var rules []string
+	for _, host := range fields[1:] {
+		if strings.HasPrefix(host, "#") {
+			break
+		}
+		host = strings.ToLower(host)
+		if _, err := netip.ParseAddr(host); err == nil {
+			continue
+		}
+		if strings.Contains(host, ".") && plainDomain(host, false) && host != "localhost.localdomain" {
+			rules = append(rules, "DOMAIN,"+host)
+		}
+	}
+	return rules
+}
+
+// parseAdBlock converts a "||example.com^" rule, which blocks the domain
+// and its subdomains, to a DOMAIN-SUFFIX rule. Rules matching URL paths or
+// patterns, or carrying modifiers other than $important, are skipped.
+func parseAdBlock(line string) []string {
+	rule, modifiers, _ := strings.Cut(strings.TrimPrefix(line, "||"), "$")
+	if modifiers != "" && modifiers != "important" {
+		return nil
+	}
+	domain, ok := strings.CutSuffix(strings.TrimSuffix(rule, "|"), "^")
+	domain = strings.ToLower(domain)
+	if !ok || !strings.Contains(domain, ".") || !plainDomain(domain, false) {
+		return nil
+	}
+	return []string{"DOMAIN-SUFFIX," + domain}
+}
+
+// plainDomain reports whether d is a lower-case domain of host name
+// characters only, or also "*" labels if wildcard is set.
+func plainDomain(d string, wildcard bool) bool {
+	if d == "" {
+		return false
+	}
+	for _, c := range d {
+		if c == '*' && wildcard {
+			continue
+		}
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '.' || c == '-' || c == '_' || c > 0x7f) {
+			return false
+		}
+	}
+	_, ok := parseDomain(d)
+	return ok
+}
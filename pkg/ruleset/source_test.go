@@ -0,0 +1,59 @@
+package ruleset
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseSource(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   []string
+	}{
+		{
+			name:   "yaml",
+			source: "# comment\npayload:\n  - DOMAIN,example.com\n  - '+.example.org'\n",
+			want:   []string{"DOMAIN,example.com", "+.example.org"},
+		},
+		{
+			name:   "plain list",
+			source: "\xef\xbb\xbfexample.com\n.example.org\n*.wild.io\n192.0.2.1\n2001:db8::/32\n\n# comment\nnot a domain\nlocalhost\n",
+			want:   []string{"example.com", "+.example.org", "*.wild.io", "192.0.2.1/32", "2001:db8::/32"},
+		},
+		{
+			name: "surge list",
+			source: "# NAME: Example\nDOMAIN-SUFFIX,example.com\nIP-CIDR,192.0.2.0/24,no-resolve\n" +
+				"USER-AGENT,Example*\nDEST-PORT,853\nAND,((DOMAIN,a.com),(DST-PORT,443))\n",
+			want: []string{"DOMAIN-SUFFIX,example.com", "IP-CIDR,192.0.2.0/24,no-resolve", "DST-PORT,853", "AND,((DOMAIN,a.com),(DST-PORT,443))"},
+		},
+		{
+			name:   "quantumult x list",
+			source: "host-suffix, example.com, proxy\nip6-cidr, 2001:db8::/32, proxy\n",
+			want:   []string{"DOMAIN-SUFFIX,example.com", "IP-CIDR6,2001:db8::/32"},
+		},
+		{
+			name:   "hosts",
+			source: "127.0.0.1 localhost\n::1 localhost ip6-localhost\n0.0.0.0 0.0.0.0\n0.0.0.0 ads.example.com Tracker.Example.net # trackers\n",
+			want:   []string{"DOMAIN,ads.example.com", "DOMAIN,tracker.example.net"},
+		},
+		{
+			name: "adblock",
+			source: "[Adblock Plus 2.0]\n! Title: Example\n||ads.example.com^\n||Tracker.example.net^$important\n" +
+				"@@||cdn.example.com^\n||example.org/banner^\n||*.example.io^\n||third.example.com^$third-party\n" +
+				"example.com##.banner\n",
+			want: []string{"DOMAIN-SUFFIX,ads.example.com", "DOMAIN-SUFFIX,tracker.example.net"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSource([]byte(tt.source))
+			if err != nil {
+				t.Fatalf("ParseSource failed: %v", err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("ParseSource() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
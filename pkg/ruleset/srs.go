@@ -0,0 +1,192 @@
+package ruleset
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+// singBoxVersion is the rule-set version generated. Version 1 is read by
+// every sing-box release with rule-set support.
+const singBoxVersion = 1
+
+// srsMagic starts every sing-box binary rule set.
+var srsMagic = [3]byte{'S', 'R', 'S'}
+
+// Rule item types of the sing-box binary format.
+const (
+	srsItemDomain      = 2
+	srsItemDomainRegex = 4
+	srsItemIPCIDR      = 6
+	srsItemFinal       = 0xFF
+)
+
+// singBoxRule is a headless rule of a sing-box source rule set. Its items
+// match if any of them does.
+type singBoxRule struct {
+	Domain       []string `json:"domain,omitempty"`
+	DomainSuffix []string `json:"domain_suffix,omitempty"`
+	DomainRegex  []string `json:"domain_regex,omitempty"`
+	IPCIDR       []string `json:"ip_cidr,omitempty"`
+}
+
+type singBoxRuleSet struct {
+	Version int           `json:"version"`
+	Rules   []singBoxRule `json:"rules"`
+}
+
+// singBoxRuleFor converts payload to a single sing-box rule. Wildcard
+// labels, which sing-box lacks, become regular expressions.
+func singBoxRuleFor(behavior string, payload []string) (singBoxRule, error) {
+	var r singBoxRule
+	switch behavior {
+	case Domain:
+		seen := make(map[string]bool)
+		for _, s := range payload {
+			d, ok := parseDomain(s)
+			if !ok || seen[d] {
+				continue
+			}
+			seen[d] = true
+			switch {
+			case strings.Contains(d, "*"):
+				r.DomainRegex = append(r.DomainRegex, wildcardRegexp(d))
+			case strings.HasPrefix(d, "+."):
+				r.DomainSuffix = append(r.DomainSuffix, d[2:])
+			case strings.HasPrefix(d, "."):
+				r.DomainSuffix = append(r.DomainSuffix, d)
+			default:
+				r.Domain = append(r.Domain, d)
+			}
+		}
+	case IPCIDR:
+		seen := make(map[string]bool)
+		for _, s := range payload {
+			p, ok := parsePrefix(s)
+			if !ok || seen[p.String()] {
+				continue
+			}
+			seen[p.String()] = true
+			r.IPCIDR = append(r.IPCIDR, p.String())
+		}
+	}
+	if len(r.Domain)+len(r.DomainSuffix)+len(r.DomainRegex)+len(r.IPCIDR) == 0 {
+		return r, ErrEmpty
+	}
+	return r, nil
+}
+
+// wildcardRegexp translates a domain with "*" labels, and possibly a
+// leading "+" or "." label, to a regular expression.
+func wildcardRegexp(d string) string {
+	prefix := "^"
+	if rest, ok := strings.CutPrefix(d, "+."); ok {
+		prefix, d = `^(.+\.)?`, rest
+	} else if rest, ok := strings.CutPrefix(d, "."); ok {
+		prefix, d = `^.+\.`, rest
+	}
+	labels := strings.Split(d, ".")
+	for i, l := range labels {
+		if l == "*" {
+			labels[i] = `[^.]+`
+		} else {
+			labels[i] = regexp.QuoteMeta(l)
+		}
+	}
+	return prefix + strings.Join(labels, `\.`) + "$"
+}
+
+func compileSingBoxSource(behavior string, payload []string) ([]byte, error) {
+	r, err := singBoxRuleFor(behavior, payload)
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(singBoxRuleSet{Version: singBoxVersion, Rules: []singBoxRule{r}}, "", "  ")
+}
+
+// compileSingBoxBinary writes payload in sing-box's srs format: the magic
+// and version followed by a zlib stream of the rules.
+func compileSingBoxBinary(behavior string, payload []string) ([]byte, error) {
+	r, err := singBoxRuleFor(behavior, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	writeUvarint(&body, 1) // rule count
+	body.WriteByte(0)      // default rule
+	if len(r.Domain) > 0 || len(r.DomainSuffix) > 0 {
+		body.WriteByte(srsItemDomain)
+		writeDomainMatcher(&body, r.Domain, r.DomainSuffix)
+	}
+	if len(r.DomainRegex) > 0 {
+		body.WriteByte(srsItemDomainRegex)
+		writeUvarint(&body, uint64(len(r.DomainRegex)))
+		for _, s := range r.DomainRegex {
+			writeUvarint(&body, uint64(len(s)))
+			body.WriteString(s)
+		}
+	}
+	if len(r.IPCIDR) > 0 {
+		rr, _ := parseRanges(r.IPCIDR)
+		body.WriteByte(srsItemIPCIDR)
+		body.WriteByte(1) // ip set version
+		binary.Write(&body, binary.BigEndian, uint64(len(rr)))
+		for _, x := range rr {
+			for _, a := range [][]byte{x.from.AsSlice(), x.to.AsSlice()} {
+				writeUvarint(&body, uint64(len(a)))
+				body.Write(a)
+			}
+		}
+	}
+	body.WriteByte(srsItemFinal)
+	body.WriteByte(0) // not inverted
+
+	var buf bytes.Buffer
+	buf.Write(srsMagic[:])
+	buf.WriteByte(singBoxVersion)
+	zw, _ := zlib.NewWriterLevel(&buf, zlib.BestCompression)
+	if _, err := zw.Write(body.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// srsPrefixLabel marks suffix keys in sing-box's domain matcher.
+const srsPrefixLabel = "\r"
+
+// writeDomainMatcher writes sing-box's domain matcher in the legacy form of
+// rule-set version 1, where a suffix "example.com" is stored as the domain
+// itself plus the suffix ".example.com".
+func writeDomainMatcher(buf *bytes.Buffer, domains, suffixes []string) {
+	var keys []string
+	for _, d := range suffixes {
+		if !strings.HasPrefix(d, ".") {
+			keys = append(keys, reverse(d))
+			d = "." + d
+		}
+		keys = append(keys, reverse(srsPrefixLabel+d))
+	}
+	for _, d := range domains {
+		keys = append(keys, reverse(d))
+	}
+	ss := newSuccinctSet(keys)
+
+	buf.WriteByte(1) // matcher version
+	for _, bm := range [][]uint64{ss.leaves, ss.labelBitmap} {
+		writeUvarint(buf, uint64(len(bm)))
+		binary.Write(buf, binary.BigEndian, bm)
+	}
+	writeUvarint(buf, uint64(len(ss.labels)))
+	buf.Write(ss.labels)
+}
+
+func writeUvarint(buf *bytes.Buffer, v uint64) {
+	buf.Write(binary.AppendUvarint(nil, v))
+}
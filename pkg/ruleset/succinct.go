@@ -0,0 +1,68 @@
+package ruleset
+
+import "sort"
+
+// succinctSet is the LOUDS-encoded trie that both mihomo and sing-box use
+// to store domain sets. Nodes are numbered breadth-first; labelBitmap holds
+// a 0 for every child of a node followed by a 1, labels the byte of every
+// edge and leaves a 1 for every node that ends a key.
+type succinctSet struct {
+	leaves      []uint64
+	labelBitmap []uint64
+	labels      []byte
+}
+
+// newSuccinctSet builds the set of keys. Duplicates are removed.
+func newSuccinctSet(keys []string) succinctSet {
+	keys = sortedUnique(keys)
+	var ss succinctSet
+	if len(keys) == 0 {
+		return ss
+	}
+
+	type span struct{ s, e, col int }
+	queue := []span{{0, len(keys), 0}}
+	lIdx := 0
+	for i := 0; i < len(queue); i++ {
+		elt := queue[i]
+		if elt.col == len(keys[elt.s]) {
+			elt.s++
+			setBit(&ss.leaves, i)
+		}
+		for j := elt.s; j < elt.e; {
+			frm := j
+			for ; j < elt.e && keys[j][elt.col] == keys[frm][elt.col]; j++ {
+			}
+			queue = append(queue, span{frm, j, elt.col + 1})
+			ss.labels = append(ss.labels, keys[frm][elt.col])
+			growBits(&ss.labelBitmap, lIdx)
+			lIdx++
+		}
+		setBit(&ss.labelBitmap, lIdx)
+		lIdx++
+	}
+	return ss
+}
+
+func growBits(bm *[]uint64, i int) {
+	for i>>6 >= len(*bm) {
+		*bm = append(*bm, 0)
+	}
+}
+
+func setBit(bm *[]uint64, i int) {
+	growBits(bm, i)
+	(*bm)[i>>6] |= 1 << uint(i&63)
+}
+
+func sortedUnique(keys []string) []string {
+	keys = append([]string(nil), keys...)
+	sort.Strings(keys)
+	out := keys[:0]
+	for i, k := range keys {
+		if i == 0 || k != keys[i-1] {
+			out = append(out, k)
+		}
+	}
+	return out
+}
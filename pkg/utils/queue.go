@@ -65,6 +65,17 @@ func (q *Queue[T]) Clear() {
 	q.cnt = 0
 }
 
+// Resize changes the capacity of the queue, discarding its contents.
+func (q *Queue[T]) Resize(size int) {
+	q.rw.Lock()
+	defer q.rw.Unlock()
+	q.items = make([]T, size)
+	q.size = size
+	q.l = 0
+	q.r = -1
+	q.cnt = 0
+}
+
 func (q *Queue[T]) Has(item T) bool {
 	q.rw.RLock()
 	defer q.rw.RUnlock()
@@ -76,6 +87,17 @@ func (q *Queue[T]) Has(item T) bool {
 	return false
 }
 
+// Items returns a copy of the queued items, oldest first.
+func (q *Queue[T]) Items() []T {
+	q.rw.RLock()
+	defer q.rw.RUnlock()
+	out := make([]T, q.cnt)
+	for i := range q.cnt {
+		out[i] = q.items[(q.l+i)%q.size]
+	}
+	return out
+}
+
 func (q *Queue[T]) Rand() T {
 	q.rw.RLock()
 	defer q.rw.RUnlock()